@@ -2,8 +2,10 @@ import { vi } from "vitest";
 
 export class TFile {
   path: string;
+  extension: string;
   constructor(path: string) {
     this.path = path;
+    this.extension = path.includes(".") ? path.slice(path.lastIndexOf(".") + 1) : "";
   }
 }
 
@@ -1,6 +1,6 @@
 import { afterEach, beforeEach, describe, expect, it, vi } from "vitest";
 import { requestUrl } from "obsidian";
-import { FluxSync } from "./sync";
+import { contentHash, FluxSync } from "./sync";
 import type { FluxSettings } from "./settings";
 
 const defaultSettings: FluxSettings = {
@@ -148,3 +148,37 @@ describe("FluxSync", () => {
     });
   });
 });
+
+describe("pushAllNow", () => {
+  it("splits the push into batches that fit the server's limit", async () => {
+    const { TFile } = await import("obsidian");
+    const pushes: { files: { path: string }[] }[] = [];
+    vi.mocked(requestUrl).mockReset();
+    vi.mocked(requestUrl).mockImplementation(async ({ url, body }: { url: string; body?: string }) => {
+      if (url.endsWith("/health")) {
+        return { status: 200, json: Promise.resolve({ status: "ok", limits: { maxPushBytes: 400, maxPathLength: 1024 } }) };
+      }
+      expect(new TextEncoder().encode(body).length).toBeLessThanOrEqual(400);
+      pushes.push(JSON.parse(body!));
+      return { status: 200, json: Promise.resolve({ results: [] }) };
+    });
+    const files = ["a.md", "b.md", "c.md", "big.md"].map((p) => new TFile(p));
+    const vault = {
+      read: vi.fn(async (f: { path: string }) => (f.path === "big.md" ? "x".repeat(1000) : "y".repeat(20))),
+    };
+
+    const sync = new FluxSync(defaultSettings, vault as any);
+    await expect(sync.pushAllNow(files as any)).rejects.toThrow("too large to push: big.md");
+
+    expect(pushes.length).toBe(2);
+    expect(pushes.flatMap((p) => p.files.map((f) => f.path))).toEqual(["a.md", "b.md", "c.md"]);
+  });
+});
+
+describe("contentHash", () => {
+  it("hashes UTF-8 bytes like the server", async () => {
+    // Same vectors as the server's TestContentHash.
+    expect(await contentHash("")).toBe("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855");
+    expect(await contentHash("Café 日本 🎉")).toBe("sha256:29210f25b4b8142d7f3d2343a6320a6d604be8e9ea428aea9bc44ae112ce0817");
+  });
+});
@@ -4,11 +4,14 @@ import type { FluxSettings } from "./settings";
 
 const ORIGIN_FLUX = "flux";
 const PUSH_DEBOUNCE_MS = 500;
+/** Server default for limits.max_push_bytes, used when /health doesn't report the limit. */
+const DEFAULT_MAX_PUSH_BYTES = 10 * 1024 * 1024;
 
-function contentHash(str: string): string {
-  let h = 0;
-  for (let i = 0; i < str.length; i++) h = ((h << 5) - h + str.charCodeAt(i)) | 0;
-  return `sha256:${Math.abs(h).toString(36)}${str.length}`;
+/** "sha256:" + lowercase hex SHA-256 of the UTF-8 bytes; must match the server's sync.ContentHash. */
+export async function contentHash(str: string): Promise<string> {
+  const digest = await crypto.subtle.digest("SHA-256", new TextEncoder().encode(str));
+  const hex = Array.from(new Uint8Array(digest), (b) => b.toString(16).padStart(2, "0")).join("");
+  return `sha256:${hex}`;
 }
 
 function errorMessage(e: unknown): string {
@@ -118,7 +121,7 @@ export class FluxSync {
           const content = await this.vault.read(file);
           const res = await this.api("/push", {
             method: "POST",
-            body: JSON.stringify({ files: [{ path, content, hash: contentHash(content) }] }),
+            body: JSON.stringify({ files: [{ path, content, hash: await contentHash(content) }] }),
           });
           if (!res.ok) throw new Error(`Push failed: ${res.status}`);
           new Notice(`Flux: pushed ${path}`);
@@ -134,21 +137,58 @@ export class FluxSync {
     for (const f of files) await this.pushFile(f);
   }
 
-  /** Push multiple files in one request and await (for initial sync on enable). */
+  /** The largest JSON push the server accepts, as reported by /health. */
+  private async maxPushBytes(): Promise<number> {
+    try {
+      const res = await this.api("/health");
+      const data = (await res.json()) as { limits?: { maxPushBytes?: unknown } };
+      const n = data?.limits?.maxPushBytes;
+      if (res.ok && typeof n === "number" && n > 0) return n;
+    } catch (e) {
+      console.warn("[Flux] health check:", e);
+    }
+    return DEFAULT_MAX_PUSH_BYTES;
+  }
+
+  /**
+   * Push multiple files and await (for initial sync on enable), in as few requests as fit the
+   * server's push limit. A file too large for any request is skipped and reported once the
+   * rest are pushed.
+   */
   async pushAllNow(files: TFile[]): Promise<void> {
     if (!this.settings.enabled || !this.baseUrl || this.applyingPull) return;
-    const pushFiles: PushFile[] = [];
+    const limit = await this.maxPushBytes();
+    const encoder = new TextEncoder();
+    const size = (v: unknown) => encoder.encode(JSON.stringify(v)).length;
+    const envelope = size({ files: [], deleted: [] });
+    let batch: PushFile[] = [];
+    let batchBytes = envelope;
+    const tooLarge: string[] = [];
+    const send = async () => {
+      if (batch.length === 0) return;
+      const res = await this.api("/push", {
+        method: "POST",
+        body: JSON.stringify({ files: batch, deleted: [] }),
+      });
+      if (!res.ok) throw new Error(`Push failed: ${res.status}`);
+      batch = [];
+      batchBytes = envelope;
+    };
     for (const f of files) {
       if (f.extension !== "md") continue;
       const content = await this.vault.read(f);
-      pushFiles.push({ path: f.path, content, hash: contentHash(content) });
+      const file: PushFile = { path: f.path, content, hash: await contentHash(content) };
+      const n = size(file) + 1; // plus the separating comma
+      if (envelope + n > limit) {
+        tooLarge.push(f.path);
+        continue;
+      }
+      if (batchBytes + n > limit) await send();
+      batch.push(file);
+      batchBytes += n;
     }
-    if (pushFiles.length === 0) return;
-    const res = await this.api("/push", {
-      method: "POST",
-      body: JSON.stringify({ files: pushFiles, deleted: [] }),
-    });
-    if (!res.ok) throw new Error(`Push failed: ${res.status}`);
+    await send();
+    if (tooLarge.length) throw new Error(`too large to push: ${tooLarge.join(", ")}`);
   }
 
   async pull(): Promise<void> {
@@ -186,7 +226,7 @@ export class FluxSync {
           const existing = this.vault.getAbstractFileByPath(path);
           if (existing && existing instanceof TFile) {
             const cur = await this.vault.read(existing);
-            if (contentHash(cur) !== f.hash) {
+            if ((await contentHash(cur)) !== f.hash) {
               await this.vault.modify(existing, f.content, {});
               applied++;
             }
@@ -251,7 +291,7 @@ export class FluxSync {
     if (file instanceof TFile) {
       try {
         const content = await this.vault.read(file);
-        files.push({ path: norm(file.path), content, hash: contentHash(content) });
+        files.push({ path: norm(file.path), content, hash: await contentHash(content) });
       } catch (e) {
         console.error("[Flux] rename read error:", e);
         new Notice(`Flux: rename failed — ${errorMessage(e)}`);
@@ -2,55 +2,320 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	gosync "sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/shaun/flux/server/internal/api"
+	"github.com/shaun/flux/server/internal/certs"
+	"github.com/shaun/flux/server/internal/config"
 	"github.com/shaun/flux/server/internal/github"
+	"github.com/shaun/flux/server/internal/mirror"
 	"github.com/shaun/flux/server/internal/sync"
 )
 
 func main() {
 	_ = godotenv.Load(".env")
-	owner := os.Getenv("FLUX_GIT_OWNER")
-	if owner == "" {
-		log.Fatal("[Flux] FLUX_GIT_OWNER not set")
+
+	configPath := flag.String("config", os.Getenv("FLUX_CONFIG"), "path to YAML config file (env FLUX_CONFIG)")
+	listen := flag.String("listen", "", "listen address, overrides config and env")
+	dataDir := flag.String("data", "", "storage directory, overrides config and env")
+	flag.Parse()
+
+	// load layers file, env and flags; used at startup and on every reload.
+	load := func() (*config.Config, error) {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ApplyEnv(os.Getenv)
+		if *listen != "" {
+			cfg.Listen = *listen
+		}
+		if *dataDir != "" {
+			cfg.Storage.Dir = *dataDir
+		}
+		return cfg, cfg.Validate()
+	}
+	cfg, err := load()
+	if err != nil {
+		log.Fatalf("[Flux] Invalid config:\n%v", err)
+	}
+
+	store, err := openStore(cfg.Storage)
+	if err != nil {
+		log.Fatalf("[Flux] Open store: %v", err)
+	}
+
+	remotes := buildRemotes(cfg.Mirrors)
+	if len(remotes) == 0 {
+		log.Print("[Flux] No mirrors configured; running standalone")
 	}
-	repo := os.Getenv("FLUX_GIT_REPO")
-	if repo == "" {
-		log.Fatal("[Flux] FLUX_GIT_REPO not set")
+	for _, r := range remotes {
+		seedStore(store, r)
+	}
+	handler := api.NewHandler(store, cfg, asMirrors(remotes)...)
+	if cfg.Storage.Backend == "file" {
+		if err := handler.Mirrors().LoadQueue(cfg.Storage.QueuePath()); err != nil {
+			log.Printf("[Flux] Load mirror queue failed (starting empty): %v", err)
+		} else if n := handler.Mirrors().Pending(); n > 0 {
+			log.Printf("[Flux] Restored %d undelivered mirror change(s)", n)
+		}
+	}
+	router := api.NewRouter(handler)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go collectGarbage(ctx, store, handler)
+	go retryMirrors(ctx, store, handler)
+	watchConfig(ctx, *configPath, handler, load)
+
+	srv := &http.Server{Addr: cfg.Listen, Handler: router}
+	servers := []*http.Server{srv}
+	errc := make(chan error, 2)
+	if cfg.TLS.Enabled() {
+		reloader, err := certs.New(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.ClientCA, certs.ClientAuth(cfg.TLS.ClientAuth))
+		if err != nil {
+			log.Fatalf("[Flux] Load TLS certificate: %v", err)
+		}
+		srv.TLSConfig = reloader.TLSConfig()
+		watchCerts(ctx, cfg.TLS, reloader)
+		if cfg.TLS.RedirectHTTP != "" {
+			redirect := &http.Server{Addr: cfg.TLS.RedirectHTTP, Handler: api.RedirectToHTTPS(cfg.Listen)}
+			servers = append(servers, redirect)
+			go func() {
+				log.Printf("Flux redirecting HTTP on %s to HTTPS", redirect.Addr)
+				errc <- redirect.ListenAndServe()
+			}()
+		}
 	}
-	token := os.Getenv("FLUX_GIT_TOKEN")
-	if token == "" {
-		log.Fatal("[Flux] FLUX_GIT_TOKEN not set")
+	go func() {
+		log.Printf("Flux server listening on %s (TLS: %t)", cfg.Listen, cfg.TLS.Enabled())
+		if srv.TLSConfig != nil {
+			// Certificates come from TLSConfig so they can be reloaded.
+			errc <- srv.ListenAndServeTLS("", "")
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-errc:
+		log.Fatalf("[Flux] Server: %v", err)
+	case <-ctx.Done():
 	}
+	stop()
+	shutdown(servers, store, handler, cfg.Storage)
+}
 
-	store := sync.NewStore()
+// watchCerts reloads the certificate, key and client CA whenever one of them changes on disk,
+// e.g. after a certbot renewal. A renewal caught half-written keeps the old certificate
+// until the next change completes it.
+func watchCerts(ctx context.Context, t config.TLS, reloader *certs.Reloader) {
+	reload := func() {
+		if err := reloader.Reload(); err != nil {
+			log.Printf("[Flux] TLS reload failed, keeping current certificate: %v", err)
+			return
+		}
+		log.Print("[Flux] TLS certificate reloaded")
+	}
+	for _, f := range []string{t.Cert, t.Key, t.ClientCA} {
+		if f != "" {
+			go config.Watch(ctx, f, 10*time.Second, reload)
+		}
+	}
+}
 
-	// Seed store from GitHub on startup
+// shutdown stops accepting connections, lets in-flight requests finish, saves documents
+// open for collaborative editing, flushes the store and drains the mirror queue, all within
+// the configured timeout. Mirror changes that could not be delivered in time are saved and
+// retried on the next start.
+func shutdown(servers []*http.Server, store *sync.Store, handler *api.Handler, storage config.Storage) {
+	timeout := handler.Config().ShutdownTimeout
+	log.Printf("[Flux] Shutting down (up to %s)", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("[Flux] In-flight requests did not finish: %v", err)
+			srv.Close()
+		}
+	}
+	handler.CloseCollab()
+	if err := store.Flush(); err != nil {
+		log.Printf("[Flux] Store flush failed: %v", err)
+	}
+	mirrors := handler.Mirrors()
+	if mirrors.Pending() > 0 {
+		if err := mirrors.Flush(ctx, store); err != nil {
+			log.Printf("[Flux] Mirror drain failed: %v", err)
+		}
+	}
+	if storage.Backend != "file" {
+		return
+	}
+	if err := mirrors.SaveQueue(storage.QueuePath()); err != nil {
+		log.Printf("[Flux] Save mirror queue failed: %v", err)
+	} else if n := mirrors.Pending(); n > 0 {
+		log.Printf("[Flux] Saved %d undelivered mirror change(s) for next start", n)
+	}
+	log.Print("[Flux] Shutdown complete")
+}
+
+func openStore(s config.Storage) (*sync.Store, error) {
+	if s.Backend == "memory" {
+		return sync.NewStore(), nil
+	}
+	return sync.OpenStore(s.StorePath())
+}
+
+func buildRemotes(mirrors []config.Mirror) []*github.Remote {
+	var out []*github.Remote
+	for _, m := range mirrors {
+		out = append(out, github.NewRemote(github.NewClient(), m.Owner, m.Repo, m.Token))
+	}
+	return out
+}
+
+func asMirrors(remotes []*github.Remote) []mirror.Mirror {
+	out := make([]mirror.Mirror, len(remotes))
+	for i, r := range remotes {
+		out[i] = r
+	}
+	return out
+}
+
+// seedStore loads the remote's notes into a store that has never held any files (first run).
+func seedStore(store *sync.Store, remote *github.Remote) {
+	if files, deleted := store.Len(); files > 0 || deleted > 0 {
+		return
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
-	gh := github.NewClient()
-	fetched, err := gh.FetchFromRepo(ctx, token, owner, repo)
+	fetched, err := remote.Fetch(ctx)
 	if err != nil {
-		log.Printf("[Flux] Fetch from GitHub failed (continuing with empty store): %v", err)
-	} else {
-		for _, f := range fetched {
-			store.UpsertFile(f.Path, f.Content, f.Hash)
+		log.Printf("[Flux] Fetch from %s failed (continuing with empty store): %v", remote.Name(), err)
+		return
+	}
+	for _, f := range fetched {
+		store.UpsertFile(f.Path, f.Content, f.Hash)
+	}
+	if err := store.Flush(); err != nil {
+		log.Printf("[Flux] Store flush failed: %v", err)
+	}
+	log.Printf("[Flux] Loaded %d files from %s", len(fetched), remote.Name())
+}
+
+// watchConfig reloads on SIGHUP and, when a config file is used, whenever it changes on disk.
+// An invalid new config is logged and rejected; the running one stays active.
+func watchConfig(ctx context.Context, path string, handler *api.Handler, load func() (*config.Config, error)) {
+	var mu gosync.Mutex
+	reload := func(reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		next, err := load()
+		if err != nil {
+			log.Printf("[Flux] Config reload (%s) rejected, keeping current config:\n%v", reason, err)
+			return
 		}
-		log.Printf("[Flux] Loaded %d files from GitHub", len(fetched))
+		cur := handler.Config()
+		if keys := cur.RestartRequired(next); len(keys) > 0 {
+			log.Printf("[Flux] Config reload: %s changed; takes effect after restart", strings.Join(keys, ", "))
+			cur.KeepRestartOnly(next)
+		}
+		handler.Reload(next, asMirrors(buildRemotes(next.Mirrors))...)
+		log.Printf("[Flux] Config reloaded (%s)", reason)
 	}
 
-	handler := api.NewHandler(store)
-	router := api.NewRouter(handler)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload("SIGHUP")
+		}
+	}()
+	if path != "" {
+		go config.Watch(ctx, path, 2*time.Second, func() { reload("file changed") })
+	}
+}
 
-	addr := ":8080"
-	if p := os.Getenv("PORT"); p != "" {
-		addr = ":" + p
+// staleDevice is how long a device may go unseen before it stops holding back
+// acknowledged tombstone collection and is dropped from the registry.
+const staleDevice = 30 * 24 * time.Hour
+
+// collectGarbage drops tombstones and earlier file versions older than the configured
+// retention once an hour. Zero keeps them forever. With retention.acked_tombstones it also
+// drops tombstones every active device has pulled past; devices not seen for staleDevice
+// don't count, and are forgotten.
+func collectGarbage(ctx context.Context, store *sync.Store, handler *api.Handler) {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		retention := handler.Config().Retention
+		pruned := false
+		if keep := retention.Tombstones; keep > 0 {
+			if n := store.PruneTombstones(time.Now().Add(-keep).UnixMilli()); n > 0 {
+				log.Printf("[Flux] Pruned %d tombstones older than %s", n, keep)
+				pruned = true
+			}
+		}
+		if rev, ok := store.AckedByAll(time.Now().Add(-staleDevice).UnixMilli()); ok && retention.AckedTombstones {
+			if n := store.PruneAcked(rev); n > 0 {
+				log.Printf("[Flux] Pruned %d tombstones acknowledged by every device", n)
+				pruned = true
+			}
+		}
+		if n := store.PruneDevices(time.Now().Add(-staleDevice).UnixMilli()); n > 0 {
+			log.Printf("[Flux] Forgot %d devices not seen for %s", n, staleDevice)
+			pruned = true
+		}
+		if keep := retention.History; keep > 0 {
+			if n := store.PruneHistory(time.Now().Add(-keep).UnixMilli()); n > 0 {
+				log.Printf("[Flux] Pruned %d file versions older than %s", n, keep)
+				pruned = true
+			}
+		}
+		if pruned {
+			if err := store.Flush(); err != nil {
+				log.Printf("[Flux] Store flush failed: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// mirrorRetryTimeout bounds each retry, so a hung mirror can't hold up later deliveries.
+const mirrorRetryTimeout = time.Minute
+
+// retryMirrors redelivers changes left queued by failed pushes, so mirrors catch up
+// even when no further pushes arrive.
+func retryMirrors(ctx context.Context, store *sync.Store, handler *api.Handler) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if handler.Mirrors().Pending() == 0 {
+			continue
+		}
+		attempt, cancel := context.WithTimeout(ctx, mirrorRetryTimeout)
+		err := handler.Mirrors().Flush(attempt, store)
+		cancel()
+		if err != nil {
+			log.Printf("[Flux] Mirror retry failed: %v", err)
+		}
 	}
-	log.Printf("Flux server listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, router))
 }
@@ -6,7 +6,9 @@ require (
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/google/go-github/v66 v66.0.0
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.19.2
 	golang.org/x/oauth2 v0.23.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/google/go-querystring v1.1.0 // indirect
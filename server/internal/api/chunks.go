@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// Large files can move as chunks (see sync.Chunk): a client lists a file's chunks, downloads
+// only the ones it lacks, and before a push uploads only the chunks the server lacks, then
+// sends the file as PushFile.Chunks.
+
+// GetChunk serves one chunk's bytes. A chunk never changes, so it may be cached indefinitely.
+func (h *Handler) GetChunk(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+	data, err := h.store.Blobs().Get(hash)
+	if err != nil {
+		respondError(w, r, http.StatusNotFound, CodeNotFound, "chunk not found", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", etag(hash))
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Write(data)
+}
+
+// PutChunk stores a chunk ahead of the push that names it. The body must hash to the URL's hash.
+func (h *Handler) PutChunk(w http.ResponseWriter, r *http.Request) {
+	data, ok := readBody(w, r, h.Config().Limits.MaxPushBytes)
+	if !ok {
+		return
+	}
+	if got := sync.ChunkHash(data); got != chi.URLParam(r, "hash") {
+		respondError(w, r, http.StatusBadRequest, CodeHashMismatch, "body does not match the chunk hash", map[string]string{"hash": got})
+		return
+	}
+	if _, err := h.store.Blobs().Put(data); err != nil {
+		log.Printf("[Flux] Chunk write failed (request %s): %v", requestID(r.Context()), err)
+		respondError(w, r, http.StatusInternalServerError, CodeStoreFailed, "chunk write failed", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MissingChunks reports which of the listed chunks the server does not have, in request order.
+func (h *Handler) MissingChunks(w http.ResponseWriter, r *http.Request) {
+	var req ChunksRequest
+	if !decodeJSON(w, r, h.Config(), &req) {
+		return
+	}
+	res := ChunksResponse{Missing: []string{}}
+	for _, hash := range req.Hashes {
+		if !h.store.Blobs().Has(hash) {
+			res.Missing = append(res.Missing, hash)
+		}
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// fileChunks answers a chunked GetFile: f's metadata and the hashes of its stored chunks.
+// Content the store keeps inline (small files, or a write not saved yet) comes back as
+// content instead; reading never stores anything.
+func (h *Handler) fileChunks(w http.ResponseWriter, f *sync.File) {
+	res := fileResponse(f)
+	if hashes, ok := h.store.Blobs().Stored(f.Content); ok {
+		res.Content, res.Chunks = "", hashes
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// joinChunks assembles a pushed file's content from its chunks. Content must be UTF-8 text:
+// files travel as JSON strings, which would replace the invalid bytes of a binary file.
+func joinChunks(tx *sync.Tx, f *PushFile, r *PushResult) bool {
+	content, err := tx.Blobs().Join(f.Chunks)
+	switch {
+	case errors.Is(err, sync.ErrNotFound):
+		r.Status, r.Reason = ResultRejected, "missing chunks"
+	case err != nil:
+		r.Status, r.Reason = ResultRejected, "unreadable chunks"
+	case !utf8.ValidString(content):
+		r.Status, r.Reason = ResultRejected, "content is not UTF-8 text"
+	case f.Hash != "" && f.Hash != sync.ContentHash(content):
+		r.Status, r.Reason = ResultRejected, "chunked content does not match hash"
+	default:
+		f.Content = content
+		return true
+	}
+	return false
+}
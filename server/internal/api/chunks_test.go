@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestChunks_roundTrip(t *testing.T) {
+	var b strings.Builder
+	for i := range 20000 {
+		fmt.Fprintf(&b, "line %d of a long note\n", i)
+	}
+	content := b.String()
+	store := sync.NewStore()
+	store.UpsertFile("long.md", content, sync.ContentHash(content))
+	r := NewRouter(NewHandler(store, config.Default(), &fakeMirror{}))
+
+	// Until the store saves the file as chunks, listing them returns the content and stores nothing.
+	rec := serve(t, r, http.MethodGet, "/v1/files/long.md?chunks=true", "", nil)
+	var f FileResponse
+	if err := json.NewDecoder(rec.Body).Decode(&f); err != nil || f.Content != content || f.Chunks != nil {
+		t.Fatalf("unsaved file: %v %d chunks", err, len(f.Chunks))
+	}
+	if h := sync.ChunkHash(sync.Chunk([]byte(content))[0]); store.Blobs().Has(h) {
+		t.Fatal("listing chunks must not store them")
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Download: list the chunks, then fetch each one.
+	rec = serve(t, r, http.MethodGet, "/v1/files/long.md?chunks=true", "", nil)
+	f = FileResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&f); err != nil || f.Content != "" || len(f.Chunks) < 10 {
+		t.Fatalf("chunk list: %d %v %d chunks", rec.Code, err, len(f.Chunks))
+	}
+	var got bytes.Buffer
+	for _, h := range f.Chunks {
+		rec := serve(t, r, http.MethodGet, "/v1/chunks/"+h, "", nil)
+		if rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag(h) {
+			t.Fatalf("chunk %s: %d", h, rec.Code)
+		}
+		got.Write(rec.Body.Bytes())
+	}
+	if got.String() != content {
+		t.Fatal("chunks should rebuild the file")
+	}
+
+	// Upload an edit: only the chunks around it are missing.
+	edited := strings.Replace(content, "line 10000 ", "line ten thousand ", 1)
+	var hashes []string
+	data := map[string][]byte{}
+	for _, c := range sync.Chunk([]byte(edited)) {
+		h := sync.ChunkHash(c)
+		hashes = append(hashes, h)
+		data[h] = c
+	}
+	list, _ := json.Marshal(ChunksRequest{Hashes: hashes})
+	rec = serve(t, r, http.MethodPost, "/v1/chunks/missing", string(list), nil)
+	var missing ChunksResponse
+	json.NewDecoder(rec.Body).Decode(&missing)
+	if len(missing.Missing) == 0 || len(missing.Missing) > 3 {
+		t.Fatalf("a one-line edit should need a few chunks, got %d", len(missing.Missing))
+	}
+	push, _ := json.Marshal(PushRequest{Files: []PushFile{{Path: "long.md", Chunks: hashes, Hash: sync.ContentHash(edited)}}})
+	rec = serve(t, r, http.MethodPost, "/v1/push", string(push), nil)
+	var res PushResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.Results[0].Reason != "missing chunks" {
+		t.Fatalf("push before uploading chunks: %+v", res.Results)
+	}
+	for _, h := range missing.Missing {
+		if rec := serve(t, r, http.MethodPut, "/v1/chunks/"+h, string(data[h]), nil); rec.Code != http.StatusNoContent {
+			t.Fatalf("upload %s: %d", h, rec.Code)
+		}
+	}
+	rec = serve(t, r, http.MethodPost, "/v1/push", string(push), nil)
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.Results[0].Status != ResultAccepted {
+		t.Fatalf("chunked push: %+v", res.Results)
+	}
+	if f, _ := store.Get("long.md"); f.Content != edited {
+		t.Fatal("pushed chunks should rebuild the edit")
+	}
+
+	bad, _ := json.Marshal(PushRequest{Files: []PushFile{{Path: "long.md", Chunks: hashes, Hash: "wrong"}}})
+	rec = serve(t, r, http.MethodPost, "/v1/push", string(bad), nil)
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.Results[0].Reason != "chunked content does not match hash" {
+		t.Fatalf("hash check: %+v", res.Results)
+	}
+
+	// Binary content would not survive as a JSON string, so it is refused, not stored.
+	binary := []byte{0x89, 'P', 'N', 'G', 0xff}
+	if rec := serve(t, r, http.MethodPut, "/v1/chunks/"+sync.ChunkHash(binary), string(binary), nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("binary chunk upload: %d", rec.Code)
+	}
+	push, _ = json.Marshal(PushRequest{Files: []PushFile{{Path: "img.png", Chunks: []string{sync.ChunkHash(binary)}}}})
+	res = PushResponse{}
+	json.NewDecoder(serve(t, r, http.MethodPost, "/v1/push", string(push), nil).Body).Decode(&res)
+	if len(res.Results) != 1 || res.Results[0].Status != ResultRejected || res.Results[0].Reason != "content is not UTF-8 text" {
+		t.Fatalf("binary chunked push: %+v", res.Results)
+	}
+	if rec := serve(t, r, http.MethodGet, "/v1/files/img.png", "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("binary file stored: %d", rec.Code)
+	}
+}
+
+func TestChunks_errors(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.MaxPushBytes = 8
+	r := NewRouter(NewHandler(sync.NewStore(), cfg))
+
+	rec := serve(t, r, http.MethodPut, "/v1/chunks/"+sync.ChunkHash([]byte("a")), "b", nil)
+	var e ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&e)
+	if rec.Code != http.StatusBadRequest || e.Code != CodeHashMismatch {
+		t.Fatalf("mismatched chunk: %d %+v", rec.Code, e)
+	}
+	rec = serve(t, r, http.MethodPut, "/v1/chunks/x", "far too long", nil)
+	json.NewDecoder(rec.Body).Decode(&e)
+	if rec.Code != http.StatusRequestEntityTooLarge || e.Code != CodeBodyTooLarge {
+		t.Fatalf("oversized chunk: %d %+v", rec.Code, e)
+	}
+	// A body cut off mid-upload is a bad request, not an oversized one.
+	req := httptest.NewRequest(http.MethodPut, "/v1/chunks/x", io.MultiReader(strings.NewReader("ab"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	json.NewDecoder(rec.Body).Decode(&e)
+	if rec.Code != http.StatusBadRequest || e.Code != CodeUnreadableBody {
+		t.Fatalf("unreadable chunk: %d %+v", rec.Code, e)
+	}
+	if rec := serve(t, r, http.MethodGet, "/v1/chunks/"+sync.ChunkHash([]byte("a")), "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown chunk: %d", rec.Code)
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/chunks/missing", "{", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad json: %d", rec.Code)
+	}
+}
@@ -0,0 +1,397 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/shaun/flux/server/internal/collab"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// Collaborative editing: each document someone has open over /v1/collab is a room holding the
+// authoritative collab.Doc. The room merges every client's updates into it and relays them
+// to the other viewers. A room opens from the stored markdown and writes the merged text back
+// like any other change; once the last viewer leaves it closes, so clients that reconnect
+// start over from a fresh "sync".
+
+// peerQueue is how many messages may wait for a slow connection before it is dropped.
+const peerQueue = 256
+
+type rooms struct {
+	mu      gosync.Mutex
+	open    map[string]*room         // keyed by path
+	closing map[string]chan struct{} // paths whose closed room is still saving; closed when done
+}
+
+type room struct {
+	path   string
+	mu     gosync.Mutex
+	doc    *collab.Doc
+	peers  map[*peer]bool
+	base   string // hash of the content last loaded from or saved to the store
+	saveTo string // where saves go: path, or the conflict copy once path was deleted meanwhile
+	dirty  bool
+	device string // last device to edit; the next save is credited to it
+	saving gosync.Mutex
+	done   chan struct{} // closed with the room; stops autosave
+}
+
+// errDropped rejects messages from a peer that was already dropped, e.g. for being too slow.
+var errDropped = errors.New("connection was dropped")
+
+type peer struct {
+	Peer
+	send   chan []byte
+	code   int // close frame to send once send is closed; 0 just drops the connection
+	reason string
+}
+
+// Collab upgrades to a WebSocket on which clients edit one document together; see
+// CollabMessage for the protocol.
+func (h *Handler) Collab(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.filePath(w, r)
+	if !ok {
+		return
+	}
+	if !isWebSocket(r) {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		respondError(w, r, http.StatusUpgradeRequired, CodeWebSocketRequired, "websocket upgrade required", nil)
+		return
+	}
+	cfg := h.Config()
+	// Browsers don't preflight WebSocket upgrades, so the CORS origins are enforced here.
+	if o := r.Header.Get("Origin"); o != "" && !slices.Contains(cfg.CORS.AllowedOrigins, "*") && !slices.Contains(cfg.CORS.AllowedOrigins, o) {
+		respondError(w, r, http.StatusForbidden, CodeOriginNotAllowed, "origin not allowed", map[string]string{"origin": o})
+		return
+	}
+	conn, err := upgradeWebSocket(w, r, cfg.Limits.MaxPushBytes)
+	if err != nil {
+		log.Printf("[Flux] Collab upgrade failed (request %s): %v", requestID(r.Context()), err)
+		return
+	}
+	pr := &peer{
+		Peer: Peer{Session: newSession(), Device: deviceID(r.Context()), Name: clip(r.Header.Get(headerDeviceName))},
+		send: make(chan []byte, peerQueue),
+	}
+	rm := h.join(p, pr, cfg.Collab.SaveInterval)
+	go pr.write(conn)
+	code, reason := 0, ""
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if we := (*wsError)(nil); errors.As(err, &we) {
+				code, reason = we.code, we.msg
+			}
+			break
+		}
+		var msg CollabMessage
+		if err = json.Unmarshal(data, &msg); err == nil {
+			err = rm.receive(pr, msg)
+		}
+		if err != nil {
+			rm.mu.Lock()
+			if rm.peers[pr] {
+				select {
+				case pr.send <- encodeMessage(CollabMessage{Type: "error", Code: CodeInvalidUpdate, Message: err.Error()}):
+				default:
+				}
+			}
+			rm.mu.Unlock()
+			code, reason = closePolicy, "invalid message"
+			break
+		}
+	}
+	h.leave(rm, pr, code, reason)
+}
+
+// write sends queued messages to the connection and pings it, until send is closed.
+func (p *peer) write(conn *wsConn) {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-p.send:
+			if !ok {
+				if p.code != 0 {
+					conn.Close(p.code, p.reason)
+				} else {
+					conn.conn.Close()
+				}
+				return
+			}
+			if conn.WriteText(msg) != nil {
+				conn.conn.Close()
+				return
+			}
+		case <-ping.C:
+			if conn.Ping() != nil {
+				conn.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// join adds p to the room for path, opening it from the store if nobody has it open, and
+// queues p's "sync". A room still saving on its way out is waited for, so the new one loads
+// the saved text.
+func (h *Handler) join(path string, p *peer, saveEvery time.Duration) *room {
+	h.rooms.mu.Lock()
+	defer h.rooms.mu.Unlock()
+	for {
+		ch, ok := h.rooms.closing[path]
+		if !ok || h.rooms.open[path] != nil {
+			break
+		}
+		h.rooms.mu.Unlock()
+		<-ch
+		h.rooms.mu.Lock()
+	}
+	rm := h.rooms.open[path]
+	if rm == nil {
+		content, hash := "", ""
+		if f, ok := h.store.Get(path); ok {
+			content, hash = f.Content, f.Hash
+		}
+		rm = &room{path: path, doc: collab.NewDoc(content), peers: make(map[*peer]bool), base: hash, saveTo: path, done: make(chan struct{})}
+		h.rooms.open[path] = rm
+		go h.autosave(rm, saveEvery)
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.peers[p] = true
+	presence := rm.presence()
+	p.send <- encodeMessage(CollabMessage{Type: "sync", Path: path, Session: p.Session, Clock: rm.doc.Clock(), Runs: rm.doc.Runs(), Peers: presence.Peers})
+	rm.broadcast(p, presence)
+	return rm
+}
+
+// leave removes p, closing its connection with code. The last peer to leave closes the
+// room and saves it.
+func (h *Handler) leave(rm *room, p *peer, code int, reason string) {
+	h.rooms.mu.Lock()
+	rm.mu.Lock()
+	if rm.peers[p] {
+		rm.drop(p, code, reason)
+	}
+	last := len(rm.peers) == 0 && h.rooms.open[rm.path] == rm
+	if len(rm.peers) > 0 {
+		rm.broadcast(nil, rm.presence())
+	}
+	rm.mu.Unlock()
+	if !last {
+		h.rooms.mu.Unlock()
+		return
+	}
+	done := h.close(rm)
+	h.rooms.mu.Unlock()
+	changed := h.saveRoom(rm)
+	done()
+	h.mirror(changed)
+}
+
+// CloseCollab disconnects every collab client and saves the open documents, e.g. on
+// shutdown. The saved changes are queued for the mirrors, not delivered.
+func (h *Handler) CloseCollab() {
+	h.rooms.mu.Lock()
+	var closed []*room
+	var done []func()
+	for _, rm := range h.rooms.open {
+		rm.mu.Lock()
+		for p := range rm.peers {
+			rm.drop(p, closeGoingAway, "server shutting down")
+		}
+		rm.mu.Unlock()
+		closed, done = append(closed, rm), append(done, h.close(rm))
+	}
+	h.rooms.mu.Unlock()
+	for i, rm := range closed {
+		h.mirrors.Enqueue(h.saveRoom(rm)...)
+		done[i]()
+	}
+}
+
+// close takes rm out of the registry so nobody new joins it, and marks its path as closing
+// until the returned func is called once rm is saved. Callers hold h.rooms.mu.
+func (h *Handler) close(rm *room) func() {
+	delete(h.rooms.open, rm.path)
+	close(rm.done)
+	ch := make(chan struct{})
+	h.rooms.closing[rm.path] = ch
+	return func() {
+		h.rooms.mu.Lock()
+		delete(h.rooms.closing, rm.path)
+		h.rooms.mu.Unlock()
+		close(ch)
+	}
+}
+
+func (h *Handler) autosave(rm *room, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-rm.done:
+			return
+		case <-t.C:
+			h.mirror(h.saveRoom(rm))
+		}
+	}
+}
+
+// saveRoom writes the room's text to the store if it changed since the last save and
+// returns the paths the mirrors need to see. A version written to the path by other means
+// in the meantime loses to the room's and is kept as a conflict copy. If the path was
+// deleted meanwhile the deletion stands: the room's text is kept as a conflict copy instead,
+// and later saves go to that copy. While another device holds the path's lock, saving waits
+// for a later attempt.
+func (h *Handler) saveRoom(rm *room) []string {
+	rm.saving.Lock()
+	defer rm.saving.Unlock()
+	rm.mu.Lock()
+	if !rm.dirty {
+		rm.mu.Unlock()
+		return nil
+	}
+	text, device, base, target := rm.doc.Text(), rm.device, rm.base, rm.saveTo
+	rm.dirty = false
+	rm.mu.Unlock()
+
+	hash := sync.ContentHash(text)
+	var changed []string
+	err := h.store.Batch(func(tx *sync.Tx) error {
+		tx.As(device)
+		if _, locked := tx.Locked(target); locked {
+			return errLocked
+		}
+		cur, exists := tx.Get(target)
+		switch {
+		case exists && cur.Hash == hash:
+			return nil
+		case !exists && base != "" && tx.Deleted(target):
+			c := tx.KeepConflict(target, text, hash)
+			target = c.Copy
+			changed = append(changed, c.Copy)
+			return nil
+		}
+		tx.UpsertFile(target, text, hash)
+		changed = append(changed, target)
+		if exists && cur.Hash != base {
+			tx.As(cur.Device)
+			changed = append(changed, tx.KeepConflict(target, cur.Content, cur.Hash).Copy)
+		}
+		return nil
+	})
+	rm.mu.Lock()
+	if err != nil {
+		rm.dirty = true
+	} else {
+		rm.base, rm.saveTo = hash, target
+	}
+	rm.mu.Unlock()
+	if err != nil {
+		log.Printf("[Flux] Collab save of %s deferred: %v", target, err)
+		return nil
+	}
+	if err := h.store.Flush(); err != nil {
+		log.Printf("[Flux] Store flush failed: %v", err)
+	}
+	return changed
+}
+
+// mirrorTimeout bounds a delivery made outside a request, so a hung mirror can't hold up
+// the ones that follow.
+const mirrorTimeout = time.Minute
+
+// mirror delivers changes made outside a request; failures stay queued for retry.
+func (h *Handler) mirror(changed []string) {
+	if len(changed) == 0 {
+		return
+	}
+	h.mirrors.Enqueue(changed...)
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	defer cancel()
+	h.syncMirrors(ctx)
+}
+
+// receive applies one client message. Callers don't hold rm.mu.
+func (rm *room) receive(p *peer, msg CollabMessage) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if !rm.peers[p] {
+		return errDropped
+	}
+	switch msg.Type {
+	case "update":
+		for _, op := range msg.Ops {
+			if op.Type == "insert" && op.ID.Client != p.Session {
+				return errors.New("inserts must use the session as client")
+			}
+		}
+		n, err := rm.doc.Apply(msg.Ops...)
+		if n > 0 {
+			rm.dirty, rm.device = true, p.Device
+			rm.broadcast(p, CollabMessage{Type: "update", Session: p.Session, Ops: msg.Ops[:n]})
+		}
+		return err
+	case "presence":
+		p.State = msg.State
+		rm.broadcast(nil, rm.presence())
+		return nil
+	}
+	return fmt.Errorf("unknown message type %q", msg.Type)
+}
+
+// broadcast queues msg for every peer but except. Peers too slow to keep up are dropped.
+// Callers hold rm.mu.
+func (rm *room) broadcast(except *peer, msg CollabMessage) {
+	data := encodeMessage(msg)
+	for p := range rm.peers {
+		if p == except {
+			continue
+		}
+		select {
+		case p.send <- data:
+		default:
+			rm.drop(p, closePolicy, "too slow")
+		}
+	}
+}
+
+// drop removes p and has its connection closed once its queue is sent. Callers hold rm.mu.
+func (rm *room) drop(p *peer, code int, reason string) {
+	delete(rm.peers, p)
+	p.code, p.reason = code, reason
+	close(p.send)
+}
+
+// presence lists the peers, sorted by session. Callers hold rm.mu.
+func (rm *room) presence() CollabMessage {
+	msg := CollabMessage{Type: "presence", Peers: []Peer{}}
+	for p := range rm.peers {
+		msg.Peers = append(msg.Peers, p.Peer)
+	}
+	slices.SortFunc(msg.Peers, func(a, b Peer) int { return strings.Compare(a.Session, b.Session) })
+	return msg
+}
+
+func encodeMessage(msg CollabMessage) []byte {
+	data, _ := json.Marshal(msg)
+	return data
+}
+
+func newSession() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shaun/flux/server/internal/collab"
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestCollab(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("note.md", "hello", "h1")
+	cfg := config.Default()
+	cfg.Collab.SaveInterval = 10 * time.Millisecond
+	h := NewHandler(store, cfg)
+	srv := httptest.NewServer(NewRouter(h))
+	defer srv.Close()
+
+	a := dialWS(t, srv, "/v1/collab/note.md", map[string]string{headerDeviceID: "laptop", headerDeviceName: "Laptop"})
+	sa := a.recv()
+	if sa.Type != "sync" || sa.Path != "note.md" || sa.Clock != 5 || len(sa.Runs) != 1 || sa.Runs[0].Text != "hello" {
+		t.Fatalf("sync: %+v", sa)
+	}
+	if len(sa.Peers) != 1 || sa.Peers[0] != (Peer{Session: sa.Session, Device: "laptop", Name: "Laptop"}) {
+		t.Fatalf("sync peers: %+v", sa.Peers)
+	}
+	b := dialWS(t, srv, "/v1/collab/note.md", map[string]string{headerDeviceID: "phone"})
+	sb := b.recv()
+	if len(sb.Peers) != 2 || sb.Session == sa.Session {
+		t.Fatalf("second sync: %+v", sb)
+	}
+	if msg := a.recv(); msg.Type != "presence" || len(msg.Peers) != 2 {
+		t.Fatalf("presence on join: %+v", msg)
+	}
+
+	op := collab.Op{Type: "insert", ID: collab.ID{Client: sa.Session, Seq: 6}, After: &collab.ID{Seq: 5}, Text: " world"}
+	a.send(CollabMessage{Type: "update", Ops: []collab.Op{op}})
+	if msg := b.recv(); msg.Type != "update" || msg.Session != sa.Session || len(msg.Ops) != 1 || msg.Ops[0].Text != " world" {
+		t.Fatalf("relayed update: %+v", msg)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if f, _ := store.Get("note.md"); f.Content == "hello world" {
+			if f.Device != "laptop" || f.Hash != sync.ContentHash("hello world") {
+				t.Fatalf("saved: %+v", f)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("document was not saved")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	b.send(CollabMessage{Type: "presence", State: map[string]any{"cursor": 3}})
+	for _, c := range []*wsClient{a, b} {
+		msg := c.recv()
+		if msg.Type != "presence" || len(msg.Peers) != 2 {
+			t.Fatalf("presence: %+v", msg)
+		}
+		for _, p := range msg.Peers {
+			if p.Session == sb.Session && p.State.(map[string]any)["cursor"] != 3.0 {
+				t.Fatalf("presence state: %+v", p)
+			}
+		}
+	}
+
+	a.frame(opClose, true, true, "\x03\xe8")
+	if code := a.closed(); code != 1000 {
+		t.Fatalf("close: %d", code)
+	}
+	if msg := b.recv(); msg.Type != "presence" || len(msg.Peers) != 1 {
+		t.Fatalf("presence on leave: %+v", msg)
+	}
+	b.send(CollabMessage{Type: "update", Ops: []collab.Op{{Type: "insert", ID: collab.ID{Client: sa.Session, Seq: 20}, Text: "x"}}})
+	if msg := b.recv(); msg.Type != "error" || msg.Code != CodeInvalidUpdate {
+		t.Fatalf("forged insert: %+v", msg)
+	}
+	if code := b.closed(); code != closePolicy {
+		t.Fatalf("close after error: %d", code)
+	}
+	for {
+		h.rooms.mu.Lock()
+		n := len(h.rooms.open)
+		h.rooms.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("room stayed open")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if f, _ := store.Get("note.md"); f.Content != "hello world" {
+		t.Fatalf("rejected update must not be saved: %q", f.Content)
+	}
+
+	cfg.CORS.AllowedOrigins = []string{"app://obsidian.md"}
+	header := map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "MDEyMzQ1Njc4OWFiY2RlZg==", "Origin": "https://evil.example"}
+	if rec := serve(t, NewRouter(h), http.MethodGet, "/v1/collab/note.md", "", header); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), CodeOriginNotAllowed) {
+		t.Fatalf("foreign origin: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, NewRouter(h), http.MethodGet, "/v1/collab/../x", "", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid path: %d", rec.Code)
+	}
+}
+
+func TestCollab_rooms(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("note.md", "v1", "h1")
+	fake := &fakeMirror{}
+	h := NewHandler(store, config.Default(), fake)
+	newPeer := func(session, device string) *peer {
+		return &peer{Peer: Peer{Session: session, Device: device}, send: make(chan []byte, peerQueue)}
+	}
+
+	p := newPeer("s", "laptop")
+	rm := h.join("note.md", p, time.Hour)
+	if err := rm.receive(p, CollabMessage{Type: "update", Ops: []collab.Op{{Type: "insert", ID: collab.ID{Client: "s", Seq: 3}, After: &collab.ID{Seq: 2}, Text: "!"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.receive(p, CollabMessage{Type: "cursor"}); err == nil {
+		t.Fatal("unknown message type accepted")
+	}
+	if err := rm.receive(p, CollabMessage{Type: "update", Ops: []collab.Op{{Type: "delete", ID: collab.ID{Client: "x", Seq: 1}}}}); err == nil {
+		t.Fatal("delete of an unknown character accepted")
+	}
+
+	// Another device writes the file and locks it while the room is open.
+	store.Batch(func(tx *sync.Tx) error {
+		tx.As("phone")
+		tx.UpsertFile("note.md", "phone edit", "h2")
+		return nil
+	})
+	store.Lock("note.md", "phone", time.Minute)
+	if changed := h.saveRoom(rm); changed != nil || !rm.dirty {
+		t.Fatalf("save under another device's lock: %v", changed)
+	}
+	if f, _ := store.Get("note.md"); f.Content != "phone edit" {
+		t.Fatalf("locked file overwritten: %q", f.Content)
+	}
+	store.Unlock("note.md", "phone")
+
+	// The last peer leaving saves; the phone's version is kept as a conflict copy.
+	h.leave(rm, p, closeGoingAway, "")
+	if f, _ := store.Get("note.md"); f.Content != "v1!" || f.Device != "laptop" {
+		t.Fatalf("saved on leave: %+v", f)
+	}
+	cs := store.Conflicts()
+	if len(cs) != 1 || cs[0].Device != "phone" || cs[0].Hash != "h2" || cs[0].Winner != sync.ContentHash("v1!") {
+		t.Fatalf("conflicts: %+v", cs)
+	}
+	if c, _ := store.Get(cs[0].Copy); c.Content != "phone edit" {
+		t.Fatalf("conflict copy: %+v", c)
+	}
+	if fake.calls != 1 || len(h.rooms.open) != 0 || p.code != closeGoingAway {
+		t.Fatalf("after leave: %d mirror calls, %d rooms, close %d", fake.calls, len(h.rooms.open), p.code)
+	}
+
+	// A peer that can't keep up is dropped; the others stay.
+	p1, p2 := newPeer("s1", "laptop"), newPeer("s2", "phone")
+	rm = h.join("note.md", p1, time.Hour)
+	h.join("note.md", p2, time.Hour)
+	for len(p2.send) < cap(p2.send) {
+		p2.send <- nil
+	}
+	rm.receive(p1, CollabMessage{Type: "presence", State: "typing"})
+	if rm.peers[p2] || !rm.peers[p1] || p2.code != closePolicy {
+		t.Fatalf("slow peer: %v", rm.peers)
+	}
+	if err := rm.receive(p2, CollabMessage{Type: "update", Ops: []collab.Op{{Type: "insert", ID: collab.ID{Client: "s2", Seq: 1}, Text: "x"}}}); !errors.Is(err, errDropped) {
+		t.Fatalf("update from a dropped peer: %v", err)
+	}
+
+	// Shutdown saves open documents and queues them without delivering.
+	rm.receive(p1, CollabMessage{Type: "update", Ops: []collab.Op{{Type: "insert", ID: collab.ID{Client: "s1", Seq: 10}, Text: "# "}}})
+	h.CloseCollab()
+	if f, _ := store.Get("note.md"); f.Content != "# v1!" {
+		t.Fatalf("saved on shutdown: %q", f.Content)
+	}
+	if len(h.rooms.open) != 0 || p1.code != closeGoingAway || h.mirrors.Pending() != 1 || fake.calls != 1 {
+		t.Fatalf("after shutdown: %d rooms, close %d, %d pending", len(h.rooms.open), p1.code, h.mirrors.Pending())
+	}
+	h.leave(rm, p1, 0, "")
+
+	// A file deleted while its room is open stays deleted; the room's text is kept as a
+	// conflict copy, which later saves go to.
+	p = newPeer("s3", "laptop")
+	rm = h.join("note.md", p, time.Hour)
+	store.DeleteFile("note.md")
+	rm.receive(p, CollabMessage{Type: "update", Ops: []collab.Op{{Type: "insert", ID: collab.ID{Client: "s3", Seq: 1}, Text: "> "}}})
+	changed := h.saveRoom(rm)
+	if _, ok := store.Get("note.md"); ok || len(changed) != 1 {
+		t.Fatalf("deleted file brought back: %v", changed)
+	}
+	var c sync.Conflict
+	for _, c = range store.Conflicts() {
+		if c.Copy == changed[0] {
+			break
+		}
+	}
+	if c.Path != "note.md" || c.Copy != changed[0] || c.Winner != "" || c.Hash != sync.ContentHash("> # v1!") {
+		t.Fatalf("conflict for the deleted file: %+v", c)
+	}
+	rm.receive(p, CollabMessage{Type: "update", Ops: []collab.Op{{Type: "insert", ID: collab.ID{Client: "s3", Seq: 3}, After: &collab.ID{Client: "s3", Seq: 1}, Text: ">"}}})
+	h.leave(rm, p, closeGoingAway, "")
+	if f, _ := store.Get(c.Copy); f.Content != ">> # v1!" || len(store.Conflicts()) != 2 {
+		t.Fatalf("later save: %+v", f)
+	}
+	if _, ok := store.Get("note.md"); ok || len(h.rooms.closing) != 0 {
+		t.Fatalf("after leave: %d rooms closing", len(h.rooms.closing))
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	gosync "sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompress unwraps gzip and zstd request bodies. Size limits apply to the decompressed
+// body, so a small compressed payload can't expand past them.
+func decompress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				respondError(w, r, http.StatusBadRequest, CodeInvalidEncoding, "body is not valid gzip", nil)
+				return
+			}
+			defer zr.Close()
+			r.Body = zr
+		case "zstd":
+			zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(8<<20))
+			if err != nil {
+				respondError(w, r, http.StatusBadRequest, CodeInvalidEncoding, "body is not valid zstd", nil)
+				return
+			}
+			defer zr.Close()
+			r.Body = zr.IOReadCloser()
+		default:
+			respondError(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedEncoding, "unsupported content encoding",
+				map[string]any{"encoding": enc, "supported": []string{"gzip", "zstd"}})
+			return
+		}
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+		next.ServeHTTP(w, r)
+	})
+}
+
+// compression encodes responses with zstd or gzip when the client accepts it, preferring zstd.
+func compression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks zstd or gzip from an Accept-Encoding header, honouring q=0.
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, enc := range []string{"zstd", "gzip"} {
+		if accepted[enc] {
+			return enc
+		}
+	}
+	return ""
+}
+
+var (
+	gzipWriters = gosync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	zstdWriters = gosync.Pool{New: func() any {
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+		return zw
+	}}
+)
+
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// compressWriter decides whether to encode when the status is written: bodies that are
+// empty (204, 304) or already encoded pass through untouched.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	enc         flushWriter
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	h := cw.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		switch cw.encoding {
+		case "zstd":
+			zw := zstdWriters.Get().(*zstd.Encoder)
+			zw.Reset(cw.ResponseWriter)
+			cw.enc = zw
+		default:
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.enc = gw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.enc == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.enc.Write(b)
+}
+
+// Flush pushes encoded data to the client so streamed responses arrive as they are written.
+func (cw *compressWriter) Flush() {
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	switch enc := cw.enc.(type) {
+	case *zstd.Encoder:
+		enc.Close()
+		zstdWriters.Put(enc)
+	case *gzip.Writer:
+		enc.Close()
+		gzipWriters.Put(enc)
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.WriteString(zw, s)
+	zw.Close()
+	return buf.String()
+}
+
+func zstded(t *testing.T, s string) string {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(zw.EncodeAll([]byte(s), nil))
+}
+
+func TestCompression_requests(t *testing.T) {
+	store := sync.NewStore()
+	r := NewRouter(NewHandler(store, config.Default()))
+	body := `{"files":[{"path":"a.md","content":"a","hash":"ha"}]}`
+	if rec := serve(t, r, http.MethodPost, "/push", gzipped(t, body), map[string]string{"Content-Encoding": "gzip"}); rec.Code != http.StatusOK {
+		t.Errorf("gzip push: %d %s", rec.Code, rec.Body.String())
+	}
+	body = `{"files":[{"path":"b.md","content":"b","hash":"hb"}]}`
+	if rec := serve(t, r, http.MethodPost, "/push", zstded(t, body), map[string]string{"Content-Encoding": "zstd"}); rec.Code != http.StatusOK {
+		t.Errorf("zstd push: %d %s", rec.Code, rec.Body.String())
+	}
+	if files, _ := store.Len(); files != 2 {
+		t.Fatalf("compressed pushes should apply: %d files", files)
+	}
+	for enc, want := range map[string]int{"br": http.StatusUnsupportedMediaType, "gzip": http.StatusBadRequest, "zstd": http.StatusBadRequest} {
+		rec := serve(t, r, http.MethodPost, "/push", "not compressed", map[string]string{"Content-Encoding": enc})
+		var e ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&e)
+		if rec.Code != want || e.Code == "" {
+			t.Errorf("%s garbage: %d %+v", enc, rec.Code, e)
+		}
+	}
+
+	// The push limit applies after decompression.
+	cfg := config.Default()
+	cfg.Limits.MaxPushBytes = 64
+	r = NewRouter(NewHandler(sync.NewStore(), cfg))
+	big := `{"files":[{"path":"a.md","content":"` + string(bytes.Repeat([]byte("x"), 1000)) + `"}]}`
+	if rec := serve(t, r, http.MethodPost, "/push", gzipped(t, big), map[string]string{"Content-Encoding": "gzip"}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("gzip bomb should hit the limit: %d", rec.Code)
+	}
+}
+
+func TestCompression_responses(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("a.md", "hello", "ha")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	rec := serve(t, r, http.MethodGet, "/pull", "", map[string]string{"Accept-Encoding": "gzip"})
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("gzip response: %q", rec.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pull PullResponse
+	if err := json.NewDecoder(zr).Decode(&pull); err != nil || len(pull.Files) != 1 {
+		t.Fatalf("gzip pull: %v %+v", err, pull)
+	}
+
+	rec = serve(t, r, http.MethodGet, "/pull", "", map[string]string{"Accept-Encoding": "gzip, zstd"})
+	if rec.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatalf("zstd should be preferred: %q", rec.Header().Get("Content-Encoding"))
+	}
+	zd, _ := zstd.NewReader(rec.Body)
+	defer zd.Close()
+	if err := json.NewDecoder(zd).Decode(&pull); err != nil || len(pull.Files) != 1 {
+		t.Fatalf("zstd pull: %v %+v", err, pull)
+	}
+
+	for name, hdr := range map[string]map[string]string{
+		"none":    {},
+		"refused": {"Accept-Encoding": "gzip;q=0"},
+		"304":     {"Accept-Encoding": "gzip", "If-None-Match": `"ha"`},
+	} {
+		rec := serve(t, r, http.MethodGet, "/v1/files/a.md", "", hdr)
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: unexpected encoding %q", name, rec.Header().Get("Content-Encoding"))
+		}
+	}
+	rec = serve(t, r, http.MethodHead, "/v1/files/a.md", "", map[string]string{"Accept-Encoding": "gzip", "Accept": "text/plain"})
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Content-Length") != "5" {
+		t.Errorf("HEAD should describe the identity body: %v", rec.Header())
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                    "",
+		"gzip":                "gzip",
+		"deflate, gzip;q=0.5": "gzip",
+		"gzip, zstd":          "zstd",
+		"zstd;q=0, gzip":      "gzip",
+		"br":                  "",
+	} {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompressWriter_flush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	cw := &compressWriter{ResponseWriter: rec, encoding: "gzip"}
+	io.WriteString(cw, "first")
+	cw.Flush()
+	if !rec.Flushed || rec.Body.Len() == 0 {
+		t.Fatal("Flush should push encoded bytes through")
+	}
+	cw.close()
+	zr, _ := gzip.NewReader(rec.Body)
+	if b, _ := io.ReadAll(zr); string(b) != "first" {
+		t.Fatalf("flushed stream: %q", b)
+	}
+}
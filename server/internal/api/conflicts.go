@@ -0,0 +1,50 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// ListConflicts returns the unresolved conflict copies written by pushes and replays with
+// conflictCopies set.
+func (h *Handler) ListConflicts(w http.ResponseWriter, r *http.Request) {
+	res := ConflictsResponse{Conflicts: []ConflictInfo{}}
+	for _, c := range h.store.Conflicts() {
+		res.Conflicts = append(res.Conflicts, ConflictInfo{
+			Path:      c.Path,
+			Copy:      c.Copy,
+			Device:    c.Device,
+			Hash:      c.Hash,
+			Winner:    c.Winner,
+			CreatedAt: c.CreatedAt,
+			Revision:  c.Rev,
+		})
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// ResolveConflict deletes a conflict copy, named by its path, once the client has merged
+// what it needs into the original.
+func (h *Handler) ResolveConflict(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.filePath(w, r)
+	if !ok {
+		return
+	}
+	var removed []string
+	err := h.store.Batch(func(tx *sync.Tx) error {
+		tx.As(deviceID(r.Context()))
+		var err error
+		removed, err = tx.ResolveConflict(p)
+		return err
+	})
+	if errors.Is(err, sync.ErrNotFound) {
+		respondError(w, r, http.StatusNotFound, CodeNotFound, "conflict not found", nil)
+		return
+	}
+	if !h.commit(w, r, removed) {
+		return
+	}
+	respondJSON(w, http.StatusOK, DeleteResponse{Deleted: removed})
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestConflicts(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("Note.md", "server", "hs")
+	fake := &fakeMirror{}
+	r := NewRouter(NewHandler(store, config.Default(), fake))
+	laptop := map[string]string{headerDeviceID: "laptop-1", headerDeviceName: "Laptop"}
+	day := time.Now().UTC().Format(time.DateOnly)
+	decode := func(method, path, body string, header map[string]string) PushResponse {
+		t.Helper()
+		rec := serve(t, r, method, path, body, header)
+		var res PushResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: %d %v", method, path, rec.Code, err)
+		}
+		return res
+	}
+
+	res := decode(http.MethodPost, "/v1/push", `{"strict":true,"conflictCopies":true,"files":[{"path":"Note.md","content":"mine","baseHash":"old"}]}`, laptop)
+	want := "Note (conflict from Laptop " + day + ").md"
+	if got := res.Results[0]; got.Status != ResultCopied || got.Copy != want || got.Hash != "hs" {
+		t.Fatalf("a strict push with copies keeps both versions: %+v", got)
+	}
+	if f, _ := store.Get("Note.md"); f.Content != "server" {
+		t.Fatal("the server version stays at the path")
+	}
+	if f, _ := store.Get(want); f.Content != "mine" || f.Device != "laptop-1" {
+		t.Fatalf("copy: %+v", f)
+	}
+	if fake.calls != 1 {
+		t.Fatal("the copy is mirrored")
+	}
+
+	for _, c := range []struct{ method, path, body, status string }{
+		{http.MethodPost, "/v1/push", `{"conflictCopies":true,"files":[{"path":"Note.md","content":"a","vector":{"laptop-1":1}}]}`, ResultCopied},
+		{http.MethodPost, "/v1/push", `{"conflictCopies":true,"files":[{"path":"Note.md","baseHash":"old","patch":[{"at":0}]}]}`, ResultConflict},
+		{http.MethodPost, "/v1/push", `{"files":[{"path":"Note.md","content":"b","baseHash":"old"}]}`, ResultConflict},
+		{http.MethodPost, "/v1/replay", `{"conflictCopies":true,"ops":[{"op":"create","path":"Note.md","content":"c"}]}`, ResultCopied},
+		{http.MethodPost, "/v1/replay", `{"conflictCopies":true,"ops":[{"op":"modify","path":"Note.md","content":"d","baseHash":"old"}]}`, ResultCopied},
+	} {
+		if got := decode(c.method, c.path, c.body, laptop).Results[0]; got.Status != c.status {
+			t.Errorf("%s %s: %+v, want %s", c.path, c.body, got, c.status)
+		}
+	}
+	stream := map[string]string{"Content-Type": ndjson, headerDeviceID: "phone-1"}
+	if got := decode(http.MethodPost, "/v1/push?conflictCopies=true", `{"op":"write","path":"Note.md","content":"e","baseHash":"old"}`, stream).Results[0]; got.Copy != "Note (conflict from phone-1 "+day+").md" {
+		t.Errorf("NDJSON push: %+v", got)
+	}
+
+	var list ConflictsResponse
+	json.NewDecoder(serve(t, r, http.MethodGet, "/v1/conflicts", "", nil).Body).Decode(&list)
+	if len(list.Conflicts) != 5 {
+		t.Fatalf("conflicts: %+v", list.Conflicts)
+	}
+	if c := list.Conflicts[3]; c.Path != "Note.md" || c.Copy != want || c.Device != "laptop-1" || c.Winner != "hs" || c.Revision == 0 {
+		t.Fatalf("conflict: %+v", c)
+	}
+
+	rec := serve(t, r, http.MethodDelete, "/v1/conflicts/"+url.PathEscape(want), "", nil)
+	var del DeleteResponse
+	if json.NewDecoder(rec.Body).Decode(&del); rec.Code != http.StatusOK || len(del.Deleted) != 1 || del.Deleted[0] != want {
+		t.Fatalf("resolve: %d %+v", rec.Code, del)
+	}
+	if _, ok := store.Get(want); ok || len(store.Conflicts()) != 4 {
+		t.Fatal("resolving deletes the copy and the record")
+	}
+	if rec := serve(t, r, http.MethodDelete, "/v1/conflicts/Note.md", "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("resolve a file that isn't a copy: %d", rec.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// Clients identify themselves with these headers on every authenticated request. All are
+// optional; without X-Device-ID a request is served but not recorded.
+const (
+	headerDeviceID       = "X-Device-ID"
+	headerDeviceName     = "X-Device-Name"
+	headerDevicePlatform = "X-Device-Platform"
+	headerClientVersion  = "X-Client-Version"
+
+	maxDeviceField = 128
+)
+
+var deviceHeaders = []string{
+	headerDeviceID + ": Stable ID of the calling device; records it in /v1/devices and attributes its changes.",
+	headerDeviceName + ": Human-readable device name, e.g. \"Laptop\".",
+	headerDevicePlatform + ": Platform of the device, e.g. \"macos\" or \"ios\".",
+	headerClientVersion + ": Version of the client making the request.",
+}
+
+type deviceIDKey struct{}
+
+// device records the calling device and refuses revoked ones. Longer header values are cut
+// to maxDeviceField bytes. X-Device-ID is chosen by the client, so revocation is advisory: it
+// stops a well-behaved client that was retired, not one that drops or changes the header.
+// Access control is the authentication layer's job (users, client certificates).
+func (h *Handler) device(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := clip(r.Header.Get(headerDeviceID))
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d := h.store.SeeDevice(sync.Device{
+			ID:            id,
+			Name:          clip(r.Header.Get(headerDeviceName)),
+			Platform:      clip(r.Header.Get(headerDevicePlatform)),
+			ClientVersion: clip(r.Header.Get(headerClientVersion)),
+		})
+		if d.RevokedAt != 0 {
+			respondError(w, r, http.StatusForbidden, CodeDeviceRevoked, "device revoked", map[string]string{"device": id})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deviceIDKey{}, id)))
+	})
+}
+
+func clip(s string) string {
+	if len(s) > maxDeviceField {
+		return s[:maxDeviceField]
+	}
+	return s
+}
+
+// deviceID returns the ID recorded by the device middleware, or "" for anonymous requests.
+func deviceID(ctx context.Context) string {
+	id, _ := ctx.Value(deviceIDKey{}).(string)
+	return id
+}
+
+// ListDevices returns every device that has identified itself, with how far behind it is.
+func (h *Handler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	rev := h.store.Revision()
+	res := DevicesResponse{Devices: []DeviceInfo{}}
+	for _, d := range h.store.Devices() {
+		res.Devices = append(res.Devices, deviceInfo(d, rev))
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// RevokeDevice refuses further requests that send the device's ID. Its acknowledgements no
+// longer hold back tombstone collection.
+func (h *Handler) RevokeDevice(w http.ResponseWriter, r *http.Request) {
+	d, err := h.store.RevokeDevice(chi.URLParam(r, "id"))
+	if errors.Is(err, sync.ErrNotFound) {
+		respondError(w, r, http.StatusNotFound, CodeNotFound, "device not found", nil)
+		return
+	}
+	if err := h.store.Flush(); err != nil {
+		log.Printf("[Flux] Store flush failed (request %s): %v", requestID(r.Context()), err)
+		respondError(w, r, http.StatusInternalServerError, CodeStoreFailed, "store write failed", nil)
+		return
+	}
+	respondJSON(w, http.StatusOK, deviceInfo(d, h.store.Revision()))
+}
+
+func deviceInfo(d sync.Device, rev int64) DeviceInfo {
+	return DeviceInfo{
+		ID:            d.ID,
+		Name:          d.Name,
+		Platform:      d.Platform,
+		ClientVersion: d.ClientVersion,
+		FirstSeen:     d.FirstSeen,
+		LastSeen:      d.LastSeen,
+		Acked:         d.Acked,
+		Behind:        rev - d.Acked,
+		RevokedAt:     d.RevokedAt,
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestDevices(t *testing.T) {
+	store := sync.NewStore()
+	r := NewRouter(NewHandler(store, config.Default(), &fakeMirror{}))
+	laptop := map[string]string{
+		"Content-Type":   "application/json",
+		headerDeviceID:   "laptop-1",
+		headerDeviceName: "Laptop", headerDevicePlatform: "macos", headerClientVersion: "1.4.0",
+	}
+	phone := map[string]string{headerDeviceID: "phone-1"}
+
+	rec := serve(t, r, http.MethodPost, "/v1/push", `{"files":[{"path":"a.md","content":"a","hash":"ha"},{"path":"b.md","content":"b","hash":"hb"}]}`, laptop)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("push: %d %s", rec.Code, rec.Body)
+	}
+	serve(t, r, http.MethodDelete, "/v1/files/b.md", "", laptop)
+	serve(t, r, http.MethodGet, "/v1/status", "", nil)
+
+	// Changes name the device that made them.
+	var m ManifestResponse
+	json.NewDecoder(serve(t, r, http.MethodGet, "/v1/manifest", "", phone).Body).Decode(&m)
+	if len(m.Files) != 1 || m.Files[0].Device != "laptop-1" || len(m.Deleted) != 1 || m.Deleted[0].Device != "laptop-1" {
+		t.Fatalf("manifest: %+v", m)
+	}
+
+	// A pull with since acknowledges it, unless filtered.
+	serve(t, r, http.MethodGet, "/pull?since=3", "", laptop)
+	serve(t, r, http.MethodGet, "/v1/pull?since=2&prefix=a", "", phone)
+	var res DevicesResponse
+	json.NewDecoder(serve(t, r, http.MethodGet, "/v1/devices", "", nil).Body).Decode(&res)
+	if len(res.Devices) != 2 {
+		t.Fatalf("anonymous requests aren't recorded: %+v", res.Devices)
+	}
+	d := res.Devices[0]
+	if d.ID != "laptop-1" || d.Name != "Laptop" || d.Platform != "macos" || d.ClientVersion != "1.4.0" || d.Acked != 3 || d.Behind != 0 || d.LastSeen == 0 {
+		t.Fatalf("laptop: %+v", d)
+	}
+	if d := res.Devices[1]; d.Acked != 0 || d.Behind != 3 {
+		t.Fatalf("phone: %+v", d)
+	}
+
+	rec = serve(t, r, http.MethodDelete, "/v1/devices/phone-1", "", nil)
+	var info DeviceInfo
+	if json.NewDecoder(rec.Body).Decode(&info); rec.Code != http.StatusOK || info.RevokedAt == 0 {
+		t.Fatalf("revoke: %d %+v", rec.Code, info)
+	}
+	if _, ok := store.AckedByAll(0); !ok {
+		t.Fatal("the laptop still counts")
+	}
+	rec = serve(t, r, http.MethodGet, "/v1/pull", "", phone)
+	var e ErrorResponse
+	if json.NewDecoder(rec.Body).Decode(&e); rec.Code != http.StatusForbidden || e.Code != CodeDeviceRevoked {
+		t.Fatalf("revoked device: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, r, http.MethodDelete, "/v1/devices/nope", "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown device: %d", rec.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// Error codes. Codes are stable and safe to branch on; messages are for people and may change.
+// Every code is described in errorCodes.
+const (
+	CodeInvalidJSON           = "invalid_json"
+	CodeBodyTooLarge          = "body_too_large"
+	CodeUnreadableBody        = "unreadable_body"
+	CodeNotText               = "not_text"
+	CodeInvalidEncoding       = "invalid_encoding"
+	CodeUnsupportedEncoding   = "unsupported_encoding"
+	CodeInvalidPath           = "invalid_path"
+	CodeInvalidQuery          = "invalid_query"
+	CodeHashMismatch          = "hash_mismatch"
+	CodeUnauthorized          = "unauthorized"
+	CodeDeviceRequired        = "device_required"
+	CodeDeviceRevoked         = "device_revoked"
+	CodeNotFound              = "not_found"
+	CodePrecondition          = "precondition_failed"
+	CodeLocked                = "locked"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeWebSocketRequired     = "websocket_required"
+	CodeInvalidUpdate         = "invalid_update"
+	CodeOriginNotAllowed      = "origin_not_allowed"
+	CodePushRejected          = "push_rejected"
+	CodeResyncRequired        = "resync_required"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeIdempotencyInProgress = "idempotency_in_progress"
+	CodeStoreFailed           = "store_failed"
+	CodeMirrorFailed          = "mirror_failed"
+	CodeInternal              = "internal"
+)
+
+// errorCodes is the catalogue published in the OpenAPI document.
+var errorCodes = []struct{ Code, Description string }{
+	{CodeInvalidJSON, "Body is not valid JSON for the endpoint."},
+	{CodeBodyTooLarge, "Body exceeds limits.max_push_bytes; details.limit is the limit."},
+	{CodeUnreadableBody, "A raw body could not be read in full, e.g. the upload was cut off; retry."},
+	{CodeNotText, "A raw file body is not valid UTF-8. Only text files are synced; binary content is refused rather than corrupted."},
+	{CodeInvalidEncoding, "Body does not decode with its Content-Encoding."},
+	{CodeUnsupportedEncoding, "Content-Encoding is not gzip or zstd; details.supported lists the options."},
+	{CodeInvalidPath, "Path is empty, absolute, too long or contains \"..\"."},
+	{CodeInvalidQuery, "A query parameter such as limit, cursor or glob is malformed."},
+	{CodeHashMismatch, "An uploaded chunk does not hash to the address it was sent to; details.hash is its actual hash."},
+	{CodeUnauthorized, "Missing or wrong credentials."},
+	{CodeDeviceRequired, "The endpoint acts for a device and needs the X-Device-ID header."},
+	{CodeDeviceRevoked, "The X-Device-ID was revoked via DELETE /v1/devices/{id}; details.device is the ID. Revocation is advisory, not access control."},
+	{CodeNotFound, "No such route or file."},
+	{CodePrecondition, "If-Match or If-None-Match did not hold."},
+	{CodeLocked, "Another device holds the lock on the path; details.lock says which and until when."},
+	{CodeMethodNotAllowed, "The route exists but not for this method."},
+	{CodeWebSocketRequired, "The endpoint only serves WebSocket (version 13) upgrades."},
+	{CodeInvalidUpdate, "Sent in a collab \"error\" message: the client's message could not be applied; it is disconnected and should resync."},
+	{CodeOriginNotAllowed, "A browser WebSocket upgrade came from an origin not in cors.allowed_origins; details.origin is the origin."},
+	{CodePushRejected, "Strict push refused and nothing applied; details.results says why."},
+	{CodeResyncRequired, "Tombstones newer than since (or than the cursor's start) were pruned; resync from /v1/manifest or a full pull, then pull with since at least details.horizon."},
+	{CodeIdempotencyKeyReused, "The idempotency key was already used for a push with a different body."},
+	{CodeIdempotencyInProgress, "A push with the same idempotency key is still running; retry shortly."},
+	{CodeStoreFailed, "The server could not persist the change."},
+	{CodeMirrorFailed, "Change saved but not yet mirrored; it stays queued for retry. details.pending counts queued paths."},
+	{CodeInternal, "Unexpected server error."},
+}
+
+// ErrorResponse is the body of every error. Error repeats Message for clients that predate Code.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Error     string `json:"error"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// respondError writes the error envelope. Message must never carry upstream error text,
+// which can include tokens or internal URLs; log that instead, tagged with the request ID.
+func respondError(w http.ResponseWriter, r *http.Request, status int, code, message string, details any) {
+	respondJSON(w, status, ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Error:     message,
+		Details:   details,
+		RequestID: requestID(r.Context()),
+	})
+}
+
+type requestIDKey struct{}
+
+// withRequestID tags each request with the caller's X-Request-ID, or a fresh one, and echoes it
+// in the response so clients can quote it when reporting an error.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			var b [8]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID set by withRequestID, or "" outside the router.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+var (
+	errPrecondition = errors.New("precondition failed")
+	errNoFile       = errors.New("no such file")
+)
+
+// etag is the strong entity tag for a file hash.
+func etag(hash string) string {
+	return strconv.Quote(hash)
+}
+
+// etagMatches reports whether an If-Match / If-None-Match header lists tag or "*".
+// Weak tags compare by value, which is enough for content hashes and revisions.
+func etagMatches(header, tag string) bool {
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// revisionETag tags a listing by the store revision it reflects. It is weak because
+// compressed and NDJSON variants are equivalent rather than byte-identical.
+func revisionETag(rev int64, variant string) string {
+	return "W/" + strconv.Quote("r"+strconv.FormatInt(rev, 10)+variant)
+}
+
+// notModified answers 304 when If-None-Match already names tag. Polling clients whose copy is
+// current get an empty response, and the listing is never built.
+func notModified(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("Cache-Control", "no-cache")
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, tag) {
+		w.Header().Set("ETag", tag)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// preconditionsMet evaluates If-Match and If-None-Match against the current file for a write.
+func preconditionsMet(r *http.Request, cur *sync.File, exists bool) bool {
+	if im := r.Header.Get("If-Match"); im != "" && (!exists || !etagMatches(im, etag(cur.Hash))) {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && exists && etagMatches(inm, etag(cur.Hash)) {
+		return false
+	}
+	return true
+}
+
+// filePath returns the unescaped {path} of a /v1/files route, answering with an error itself if it is unsafe.
+func (h *Handler) filePath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	p := chi.URLParam(r, "*")
+	var err error
+	if r.URL.RawPath != "" {
+		// chi routes on the escaped path when there is one, e.g. for names containing "%2F" or "?".
+		p, err = url.PathUnescape(p)
+	}
+	if err != nil || !safePath(p, h.Config().Limits.MaxPathLength) {
+		respondError(w, r, http.StatusBadRequest, CodeInvalidPath, "invalid path", nil)
+		return "", false
+	}
+	return p, true
+}
+
+func fileResponse(f *sync.File) FileResponse {
+	return FileResponse{Path: f.Path, Content: f.Content, Hash: f.Hash, Revision: f.Rev, UpdatedAt: f.UpdatedAt, Device: f.Device, Vector: f.Vector}
+}
+
+func fileInfo(f *sync.File) FileInfo {
+	return FileInfo{Path: f.Path, Hash: f.Hash, Size: len(f.Content), Revision: f.Rev, UpdatedAt: f.UpdatedAt, Device: f.Device, Vector: f.Vector}
+}
+
+func setFileHeaders(w http.ResponseWriter, f *sync.File) {
+	w.Header().Set("ETag", etag(f.Hash))
+	w.Header().Set("Last-Modified", time.UnixMilli(f.UpdatedAt).UTC().Format(http.TimeFormat))
+}
+
+// ListFiles returns metadata for every live file under the optional prefix query parameter.
+func (h *Handler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	files, _ := h.store.GetFiles()
+	res := FileListResponse{Files: []FileInfo{}}
+	for _, f := range files {
+		if strings.HasPrefix(f.Path, prefix) {
+			res.Files = append(res.Files, fileInfo(f))
+		}
+	}
+	slices.SortFunc(res.Files, func(a, b FileInfo) int { return strings.Compare(a.Path, b.Path) })
+	respondJSON(w, http.StatusOK, res)
+}
+
+// GetFile returns one file as JSON, or as raw text when the client asks for text/plain or
+// text/markdown. HEAD answers with the headers only, to check existence and hash. With
+// ?chunks=true the content is replaced by the hashes of its chunks.
+func (h *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.filePath(w, r)
+	if !ok {
+		return
+	}
+	f, ok := h.store.Get(p)
+	if !ok {
+		respondError(w, r, http.StatusNotFound, CodeNotFound, "file not found", nil)
+		return
+	}
+	setFileHeaders(w, f)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag(f.Hash)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == http.MethodGet && r.URL.Query().Get("chunks") != "" {
+		h.fileChunks(w, f)
+		return
+	}
+	if wantsText(r) {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(f.Content)))
+		if r.Method != http.MethodHead {
+			io.WriteString(w, f.Content)
+		}
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "application/json")
+		return
+	}
+	respondJSON(w, http.StatusOK, fileResponse(f))
+}
+
+func wantsText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/markdown") || strings.Contains(accept, "text/plain")
+}
+
+// PutFile creates or replaces one file. The body is a PutFileRequest when sent as JSON and
+// the raw content otherwise. If-Match guards against overwriting someone else's change;
+// If-None-Match: * only creates.
+func (h *Handler) PutFile(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.filePath(w, r)
+	if !ok {
+		return
+	}
+	cfg := h.Config()
+	var req PutFileRequest
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+		if !decodeJSON(w, r, cfg, &req) {
+			return
+		}
+	} else {
+		body, ok := readBody(w, r, cfg.Limits.MaxPushBytes)
+		if !ok {
+			return
+		}
+		if !utf8.Valid(body) {
+			respondError(w, r, http.StatusBadRequest, CodeNotText, "content is not UTF-8 text", nil)
+			return
+		}
+		req.Content = string(body)
+	}
+	if req.Hash == "" {
+		req.Hash = sync.ContentHash(req.Content)
+	}
+	var created, changed bool
+	var lock sync.Lock
+	err := h.store.Batch(func(tx *sync.Tx) error {
+		tx.As(deviceID(r.Context()))
+		if l, ok := tx.Locked(p); ok {
+			lock = l
+			return errLocked
+		}
+		cur, exists := tx.Get(p)
+		if !preconditionsMet(r, cur, exists) {
+			return errPrecondition
+		}
+		created = !exists
+		changed = !exists || cur.Hash != req.Hash || cur.Content != req.Content
+		if changed {
+			tx.UpsertFile(p, req.Content, req.Hash)
+		}
+		return nil
+	})
+	if errors.Is(err, errLocked) {
+		respondLocked(w, r, lock)
+		return
+	}
+	if err != nil {
+		respondError(w, r, http.StatusPreconditionFailed, CodePrecondition, "precondition failed", nil)
+		return
+	}
+	if changed && !h.commit(w, r, []string{p}) {
+		return
+	}
+	f, _ := h.store.Get(p)
+	setFileHeaders(w, f)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	respondJSON(w, status, fileResponse(f))
+}
+
+// DeleteFile tombstones a file, or every file under a folder. If-Match applies to single files.
+func (h *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.filePath(w, r)
+	if !ok {
+		return
+	}
+	var removed []string
+	var lock sync.Lock
+	err := h.store.Batch(func(tx *sync.Tx) error {
+		tx.As(deviceID(r.Context()))
+		if l, ok := tx.Locked(p); ok {
+			lock = l
+			return errLocked
+		}
+		cur, exists := tx.Get(p)
+		if !preconditionsMet(r, cur, exists) {
+			return errPrecondition
+		}
+		removed = tx.DeleteTree(p)
+		if !exists && len(removed) == 1 && removed[0] == p {
+			return errNoFile
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errLocked):
+		respondLocked(w, r, lock)
+		return
+	case errors.Is(err, errPrecondition):
+		respondError(w, r, http.StatusPreconditionFailed, CodePrecondition, "precondition failed", nil)
+		return
+	case errors.Is(err, errNoFile):
+		respondError(w, r, http.StatusNotFound, CodeNotFound, "file not found", nil)
+		return
+	}
+	if !h.commit(w, r, removed) {
+		return
+	}
+	respondJSON(w, http.StatusOK, DeleteResponse{Deleted: removed})
+}
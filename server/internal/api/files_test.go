@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func serve(t *testing.T, h http.Handler, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFiles_getHeadList(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("Notes/a b.md", "# A", "ha")
+	store.UpsertFile("Notes/100%.md", "pct", "hp")
+	store.UpsertFile("top.md", "t", "ht")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	rec := serve(t, r, http.MethodGet, "/v1/files/Notes/a%20b.md", "", nil)
+	var f FileResponse
+	if err := json.NewDecoder(rec.Body).Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || f.Content != "# A" || rec.Header().Get("ETag") != `"ha"` {
+		t.Fatalf("GET file: %d %+v etag=%q", rec.Code, f, rec.Header().Get("ETag"))
+	}
+	if rec := serve(t, r, http.MethodGet, "/v1/files/Notes/100%25.md", "", map[string]string{"Accept": "text/markdown"}); rec.Body.String() != "pct" {
+		t.Errorf("GET raw with escaped percent: %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := serve(t, r, http.MethodGet, "/v1/files/top.md", "", map[string]string{"If-None-Match": `"ht"`}); rec.Code != http.StatusNotModified {
+		t.Errorf("GET If-None-Match: %d", rec.Code)
+	}
+	rec = serve(t, r, http.MethodHead, "/v1/files/top.md", "", nil)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("ETag") != `"ht"` {
+		t.Errorf("HEAD: %d body=%q etag=%q", rec.Code, rec.Body.String(), rec.Header().Get("ETag"))
+	}
+	if rec := serve(t, r, http.MethodHead, "/v1/files/missing.md", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("HEAD missing: %d", rec.Code)
+	}
+	if rec := serve(t, r, http.MethodGet, "/v1/files/..%2Fetc", "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET unsafe path: %d", rec.Code)
+	}
+
+	rec = serve(t, r, http.MethodGet, "/v1/files?prefix=Notes/", "", nil)
+	var list FileListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Files) != 2 || list.Files[0].Path != "Notes/100%.md" || list.Files[1].Size != 3 {
+		t.Errorf("list prefix: %+v", list.Files)
+	}
+}
+
+func TestFiles_putDelete(t *testing.T) {
+	store := sync.NewStore()
+	fake := &fakeMirror{}
+	r := NewRouter(NewHandler(store, config.Default(), fake))
+
+	rec := serve(t, r, http.MethodPut, "/v1/files/new.md", "raw body", map[string]string{"If-None-Match": "*"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("PUT create: %d %s", rec.Code, rec.Body.String())
+	}
+	f, _ := store.Get("new.md")
+	if f.Content != "raw body" || f.Hash != sync.ContentHash("raw body") || rec.Header().Get("ETag") != etag(f.Hash) {
+		t.Fatalf("PUT raw: %+v etag=%q", f, rec.Header().Get("ETag"))
+	}
+	if rec := serve(t, r, http.MethodPut, "/v1/files/new.md", "again", map[string]string{"If-None-Match": "*"}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT If-None-Match * on existing file: %d", rec.Code)
+	}
+	hdr := map[string]string{"Content-Type": "application/json", "If-Match": `"stale"`}
+	if rec := serve(t, r, http.MethodPut, "/v1/files/new.md", `{"content":"v2"}`, hdr); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT stale If-Match: %d", rec.Code)
+	}
+	hdr["If-Match"] = etag(f.Hash)
+	if rec := serve(t, r, http.MethodPut, "/v1/files/new.md", `{"content":"v2","hash":"h2"}`, hdr); rec.Code != http.StatusOK {
+		t.Errorf("PUT matching If-Match: %d %s", rec.Code, rec.Body.String())
+	}
+	if f, _ := store.Get("new.md"); f.Content != "v2" || f.Hash != "h2" || fake.calls != 2 {
+		t.Errorf("PUT JSON: %+v mirror calls=%d", f, fake.calls)
+	}
+
+	store.UpsertFile("Dir/a.md", "a", "h")
+	store.UpsertFile("Dir/b.md", "b", "h")
+	if rec := serve(t, r, http.MethodDelete, "/v1/files/new.md", "", map[string]string{"If-Match": `"nope"`}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE stale If-Match: %d", rec.Code)
+	}
+	rec = serve(t, r, http.MethodDelete, "/v1/files/Dir", "", nil)
+	var del DeleteResponse
+	if err := json.NewDecoder(rec.Body).Decode(&del); err != nil || rec.Code != http.StatusOK || len(del.Deleted) != 2 {
+		t.Errorf("DELETE folder: %d %+v", rec.Code, del)
+	}
+	if rec := serve(t, r, http.MethodDelete, "/v1/files/Dir", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE missing: %d", rec.Code)
+	}
+	if _, deleted := store.Len(); deleted != 2 {
+		t.Errorf("missing delete should not tombstone: %d", deleted)
+	}
+
+	// Unversioned routes stay as aliases.
+	if rec := serve(t, r, http.MethodGet, "/pull", "", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /pull alias: %d", rec.Code)
+	}
+	if rec := serve(t, r, http.MethodGet, "/v1/pull", "", nil); rec.Code != http.StatusOK {
+		t.Errorf("GET /v1/pull: %d", rec.Code)
+	}
+}
+
+func TestFiles_putTooLarge(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.MaxPushBytes = 8
+	store := sync.NewStore()
+	rec := serve(t, NewRouter(NewHandler(store, cfg)), http.MethodPut, "/v1/files/big.md", "far too long", nil)
+	var e ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&e)
+	if rec.Code != http.StatusRequestEntityTooLarge || e.Code != CodeBodyTooLarge {
+		t.Fatalf("oversized raw put: %d %+v", rec.Code, e)
+	}
+	if _, ok := store.Get("big.md"); ok {
+		t.Fatal("oversized file stored")
+	}
+}
+
+func TestFiles_putBinary(t *testing.T) {
+	store := sync.NewStore()
+	rec := serve(t, NewRouter(NewHandler(store, config.Default())), http.MethodPut, "/v1/files/img.png", "\x89PNG\xff\x00", nil)
+	var e ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&e)
+	if rec.Code != http.StatusBadRequest || e.Code != CodeNotText {
+		t.Fatalf("binary raw put: %d %+v", rec.Code, e)
+	}
+	if _, ok := store.Get("img.png"); ok {
+		t.Fatal("binary file stored")
+	}
+}
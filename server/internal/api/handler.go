@@ -3,31 +3,47 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
-	"os"
+	"sync/atomic"
 
-	"github.com/shaun/flux/server/internal/github"
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/mirror"
 	"github.com/shaun/flux/server/internal/sync"
 )
 
-// Syncer syncs files to GitHub. Implemented by *github.Client; inject a fake in tests.
-type Syncer interface {
-	Sync(ctx context.Context, token, owner, repo string, files []*sync.File, deleted []string) error
+type Handler struct {
+	store       *sync.Store
+	cfg         atomic.Pointer[config.Config]
+	mirrors     *mirror.Set
+	idempotency *idempotencyCache
+	rooms       *rooms
 }
 
-type Handler struct {
-	store *sync.Store
-	gh    Syncer
+// NewHandler serves the store under cfg and syncs pushes to the given mirrors. With no mirrors the server runs standalone.
+func NewHandler(store *sync.Store, cfg *config.Config, mirrors ...mirror.Mirror) *Handler {
+	h := &Handler{store: store, mirrors: mirror.NewSet(mirrors...), idempotency: newIdempotencyCache(), rooms: &rooms{open: make(map[string]*room), closing: make(map[string]chan struct{})}}
+	h.cfg.Store(cfg)
+	return h
+}
+
+// Reload swaps in a new validated config and mirrors. Requests already running finish with
+// the config they started with; mirrors that keep their name keep their status and queue.
+func (h *Handler) Reload(cfg *config.Config, mirrors ...mirror.Mirror) {
+	h.mirrors.Replace(mirrors...)
+	h.cfg.Store(cfg)
 }
 
-func NewHandler(store *sync.Store) *Handler {
-	return &Handler{store: store, gh: github.NewClient()}
+// Config returns the active configuration.
+func (h *Handler) Config() *config.Config {
+	return h.cfg.Load()
 }
 
-// NewHandlerWithSyncer builds a handler with a custom Syncer (e.g. for tests).
-func NewHandlerWithSyncer(store *sync.Store, gh Syncer) *Handler {
-	return &Handler{store: store, gh: gh}
+// Mirrors returns the mirror set, e.g. to drain or save its queue on shutdown.
+func (h *Handler) Mirrors() *mirror.Set {
+	return h.mirrors
 }
 
 func respondJSON(w http.ResponseWriter, status int, v any) {
@@ -36,44 +52,306 @@ func respondJSON(w http.ResponseWriter, status int, v any) {
 	json.NewEncoder(w).Encode(v)
 }
 
-const maxPushBodyBytes = 10 << 20 // 10 MiB
+// mode is "standalone" when no mirrors are configured, otherwise "mirrored".
+func (h *Handler) mode() string {
+	if h.mirrors.Len() == 0 {
+		return "standalone"
+	}
+	return "mirrored"
+}
 
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("ok"))
+	limits := h.Config().Limits
+	respondJSON(w, http.StatusOK, HealthResponse{
+		Status:  "ok",
+		Mode:    h.mode(),
+		Mirrors: h.mirrors.Names(),
+		Limits:  HealthLimits{MaxPushBytes: limits.MaxPushBytes, MaxPathLength: limits.MaxPathLength},
+	})
+}
+
+// Status reports store size and the last sync outcome of each mirror.
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	files, deleted := h.store.Len()
+	respondJSON(w, http.StatusOK, StatusResponse{
+		Mode:    h.mode(),
+		Files:   files,
+		Deleted: deleted,
+		Mirrors: h.mirrors.Status(),
+	})
 }
 
 func (h *Handler) Push(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		respondError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	if isNDJSON(r.Header.Get("Content-Type")) {
+		h.idempotent(w, r, key, "", func(w http.ResponseWriter) { h.pushStream(w, r) })
 		return
 	}
-	r.Body = http.MaxBytesReader(w, r.Body, maxPushBodyBytes)
+	cfg := h.Config()
 	var req PushRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	if !decodeJSON(w, r, cfg, &req) {
 		return
 	}
-	for _, f := range req.Files {
-		if safePath(f.Path) {
-			h.store.UpsertFile(f.Path, f.Content, f.Hash)
+	if key == "" {
+		key = req.IdempotencyKey
+	}
+	req.IdempotencyKey = ""
+	h.idempotent(w, r, key, fingerprint(req), func(w http.ResponseWriter) { h.push(w, r, cfg, req) })
+}
+
+func (h *Handler) push(w http.ResponseWriter, r *http.Request, cfg *config.Config, req PushRequest) {
+	var res PushResponse
+	var changed []string
+	err := h.store.Batch(func(tx *sync.Tx) error {
+		tx.As(deviceID(r.Context()))
+		res, changed = applyPush(tx, req, cfg.Limits.MaxPathLength)
+		if req.Strict && res.failed() {
+			return errRejected
 		}
+		return nil
+	})
+	if errors.Is(err, errRejected) {
+		respondError(w, r, http.StatusUnprocessableEntity, CodePushRejected, "push rejected; nothing was applied", map[string]any{"results": res.Results})
+		return
+	}
+	if !h.commit(w, r, changed) {
+		return
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// decodeJSON reads a body of at most limits.max_push_bytes into v, answering with an error itself on failure.
+func decodeJSON(w http.ResponseWriter, r *http.Request, cfg *config.Config, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.Limits.MaxPushBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, r, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "body too large", map[string]int64{"limit": cfg.Limits.MaxPushBytes})
+			return false
+		}
+		respondError(w, r, http.StatusBadRequest, CodeInvalidJSON, "invalid json", nil)
+		return false
+	}
+	return true
+}
+
+// readBody reads a raw body of at most limits.max_push_bytes, answering with an error itself
+// on failure: 413 if the body is over the limit, 400 if it couldn't be read.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			respondError(w, r, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "body too large", map[string]int64{"limit": limit})
+			return nil, false
+		}
+		respondError(w, r, http.StatusBadRequest, CodeUnreadableBody, "body could not be read", nil)
+		return nil, false
+	}
+	return data, true
+}
+
+// commit persists the store and delivers changed paths to the mirrors, answering with an
+// error itself if either fails.
+func (h *Handler) commit(w http.ResponseWriter, r *http.Request, changed []string) bool {
+	if err := h.store.Flush(); err != nil {
+		log.Printf("[Flux] Store flush failed (request %s): %v", requestID(r.Context()), err)
+		respondError(w, r, http.StatusInternalServerError, CodeStoreFailed, "store write failed", nil)
+		return false
+	}
+	h.mirrors.Enqueue(changed...)
+	if err := h.syncMirrors(r.Context()); err != nil {
+		// The raw error can hold upstream URLs or tokens; it is logged, never returned.
+		respondError(w, r, http.StatusInternalServerError, CodeMirrorFailed, "mirror sync failed; changes are saved and will be retried",
+			map[string]int{"pending": h.mirrors.Pending()})
+		return false
+	}
+	return true
+}
+
+// errRejected aborts a strict push's batch so none of it is applied.
+var errRejected = errors.New("push rejected")
+
+// applyPush applies req within tx and returns the response plus every path the mirrors need to see.
+func applyPush(tx *sync.Tx, req PushRequest, maxLen int) (PushResponse, []string) {
+	res := PushResponse{Status: "ok", Results: make([]PushResult, 0, len(req.Moved)+len(req.Files)+len(req.Deleted))}
+	var changed []string
+	for _, m := range req.Moved {
+		r, c := res.move(tx, m, maxLen)
+		res.Results = append(res.Results, r)
+		changed = append(changed, c...)
+	}
+	for _, f := range req.Files {
+		r, c := applyWrite(tx, f, maxLen, req.ConflictCopies)
+		res.Results = append(res.Results, r)
+		changed = append(changed, c...)
 	}
 	for _, path := range req.Deleted {
-		if safePath(path) {
-			h.store.DeleteFile(path)
+		r, c := res.delete(tx, path, maxLen)
+		res.Results = append(res.Results, r)
+		changed = append(changed, c...)
+	}
+	return res, changed
+}
+
+// move renames m.From to m.To, recording the files it moved in res.
+func (res *PushResponse) move(tx *sync.Tx, m Move, maxLen int) (PushResult, []string) {
+	r := PushResult{Op: "move", Path: m.To, From: m.From, Status: ResultAccepted}
+	if !safePath(m.From, maxLen) || !safePath(m.To, maxLen) {
+		r.Status, r.Reason = ResultRejected, "invalid path"
+		return r, nil
+	}
+	if rejectLocked(tx, &r, m.From, m.To) {
+		return r, nil
+	}
+	moves, err := tx.Move(m.From, m.To)
+	switch {
+	case errors.Is(err, sync.ErrNotFound):
+		r.Status, r.Reason = ResultRejected, "source not found"
+	case errors.Is(err, sync.ErrExists):
+		r.Status, r.Reason = ResultConflict, "target exists"
+	case len(moves) == 0:
+		r.Status = ResultUnchanged
+	}
+	var changed []string
+	for _, mv := range moves {
+		// Both ends go to the mirrors together so the rename lands in one commit.
+		res.Moved = append(res.Moved, Move{From: mv.From, To: mv.To})
+		changed = append(changed, mv.From, mv.To)
+	}
+	return r, changed
+}
+
+// applyWrite writes f unless its Vector or BaseHash shows the server copy has changed since,
+// or another device has locked it. With copies set a conflicting or locked write to a live
+// file is kept as a conflict copy instead; see keepCopy.
+func applyWrite(tx *sync.Tx, f PushFile, maxLen int, copies bool) (PushResult, []string) {
+	r := PushResult{Op: "write", Path: f.Path, Status: ResultAccepted}
+	if f.Patch != nil && safePath(f.Path, maxLen) {
+		cur, exists := tx.Get(f.Path)
+		// A patch only applies to the exact version it was made from.
+		switch {
+		case f.BaseHash == "":
+			r.Status, r.Reason = ResultRejected, "patch without baseHash"
+		case !exists && tx.Deleted(f.Path):
+			r.Status, r.Reason = ResultConflict, "deleted on server"
+		case !exists:
+			r.Status, r.Reason = ResultConflict, "patch base mismatch"
+		case cur.Hash != f.BaseHash:
+			r.Status, r.Reason, r.Hash = ResultConflict, "patch base mismatch", cur.Hash
 		}
+		if r.Status != ResultAccepted {
+			return r, nil
+		}
+		content, err := sync.Patch(cur.Content, f.Patch)
+		if err != nil {
+			r.Status, r.Reason = ResultRejected, "invalid patch"
+			return r, nil
+		}
+		if f.Hash != "" && f.Hash != sync.ContentHash(content) {
+			r.Status, r.Reason = ResultRejected, "patched content does not match hash"
+			return r, nil
+		}
+		f.Content = content
 	}
-	if err := h.syncToGitHub(r.Context()); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if f.Chunks != nil && safePath(f.Path, maxLen) && !joinChunks(tx, &f, &r) {
+		return r, nil
+	}
+	if f.Hash == "" {
+		f.Hash = sync.ContentHash(f.Content)
+	}
+	cur, exists := tx.Get(f.Path)
+	switch {
+	case !safePath(f.Path, maxLen):
+		r.Status, r.Reason = ResultRejected, "invalid path"
+	case rejectLocked(tx, &r, f.Path):
+	case exists && cur.Hash == f.Hash && cur.Content == f.Content:
+		r.Status, r.Vector = ResultUnchanged, cur.Vector
+	case f.Vector != nil && !f.Vector.Descends(tx.Vector(f.Path)):
+		r.Status, r.Reason, r.Vector = ResultConflict, "changed on server", tx.Vector(f.Path)
+		switch {
+		case !exists:
+			r.Reason = "deleted on server"
+		case f.Vector.Concurrent(cur.Vector):
+			r.Reason, r.Hash = "concurrent edit", cur.Hash
+		default:
+			r.Hash = cur.Hash
+		}
+	case f.Vector == nil && f.BaseHash != "" && exists && cur.Hash != f.BaseHash:
+		r.Status, r.Reason, r.Hash, r.Vector = ResultConflict, "changed on server", cur.Hash, cur.Vector
+	case f.Vector == nil && f.BaseHash != "" && !exists && tx.Deleted(f.Path):
+		r.Status, r.Reason = ResultConflict, "deleted on server"
+	default:
+		if f.Vector != nil {
+			tx.UpsertFileVector(f.Path, f.Content, f.Hash, f.Vector)
+		} else {
+			tx.UpsertFile(f.Path, f.Content, f.Hash)
+		}
+		r.Vector = tx.Vector(f.Path)
+		return r, []string{f.Path}
+	}
+	if copies && exists && (r.Status == ResultConflict || r.Lock != nil) {
+		return r, keepCopy(tx, f, &r)
+	}
+	return r, nil
+}
+
+// rejectLocked rejects r if another device holds a lock on any of paths.
+func rejectLocked(tx *sync.Tx, r *PushResult, paths ...string) bool {
+	for _, p := range paths {
+		if l, ok := tx.Locked(p); ok {
+			info := lockInfo(l)
+			r.Status, r.Reason, r.Lock = ResultRejected, "locked", &info
+			return true
+		}
 	}
-	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	return false
 }
 
-// safePath rejects path traversal and invalid paths. Paths must be relative, no "..", length capped.
-func safePath(p string) bool {
-	if p == "" || len(p) > 2048 {
+// keepCopy resolves r, a conflict on a live file, by writing f's content as a conflict copy
+// next to it. The server version stays where it is.
+func keepCopy(tx *sync.Tx, f PushFile, r *PushResult) []string {
+	c := tx.KeepConflict(f.Path, f.Content, f.Hash)
+	r.Status, r.Copy = ResultCopied, c.Copy
+	return []string{c.Copy}
+}
+
+// delete tombstones path, or every file under it when it is a folder, recording them in res.
+func (res *PushResponse) delete(tx *sync.Tx, path string, maxLen int) (PushResult, []string) {
+	r := PushResult{Op: "delete", Path: path, Status: ResultAccepted}
+	if !safePath(path, maxLen) {
+		r.Status, r.Reason = ResultRejected, "invalid path"
+		return r, nil
+	}
+	if rejectLocked(tx, &r, path) {
+		return r, nil
+	}
+	if !tx.HasTree(path) && tx.Deleted(path) {
+		r.Status = ResultUnchanged
+		return r, nil
+	}
+	// A folder path deletes everything under it; all of it mirrors in the same commit.
+	removed := tx.DeleteTree(path)
+	res.Deleted = append(res.Deleted, removed...)
+	return r, removed
+}
+
+// failed reports whether any entry was rejected or conflicted.
+func (res PushResponse) failed() bool {
+	for _, r := range res.Results {
+		if r.Status == ResultRejected || r.Status == ResultConflict {
+			return true
+		}
+	}
+	return false
+}
+
+// safePath rejects path traversal and invalid paths. Paths must be relative, no "..", length capped at maxLen.
+func safePath(p string, maxLen int) bool {
+	if p == "" || len(p) > maxLen {
 		return false
 	}
 	// Normalize: no leading/trailing slash for consistency; reject ".." and absolute
@@ -91,25 +369,115 @@ func safePath(p string) bool {
 	return true
 }
 
+// Pull returns files, moves and tombstones; see PullResponse. Its ETag follows the store
+// revision, so a poll with If-None-Match gets 304 until something changes.
+//
+// POST /v1/pull takes a PullRequest body listing the versions the client already has. An
+// unfiltered pull with since tells the server the device holds every change up to it.
 func (h *Handler) Pull(w http.ResponseWriter, r *http.Request) {
-	files, deleted := h.store.GetFiles()
-	res := PullResponse{Files: make([]PullFile, len(files)), Deleted: deleted}
-	for i, f := range files {
-		res.Files[i] = PullFile{Path: f.Path, Content: f.Content, Hash: f.Hash}
+	// Expired locks leave in a new revision, so the ETag below doesn't keep serving them.
+	h.store.ExpireLocks()
+	variant := ""
+	if isNDJSON(r.Header.Get("Accept")) {
+		variant = "-ndjson"
+	}
+	var have map[string]string
+	if r.Method == http.MethodPost {
+		var req PullRequest
+		if !decodeJSON(w, r, h.Config(), &req) {
+			return
+		}
+		have = req.Have
+	} else if notModified(w, r, revisionETag(h.store.Revision(), variant)) {
+		return
+	}
+	page, err := h.pullQuery(r.URL.Query())
+	if errors.Is(err, errResync) {
+		respondError(w, r, http.StatusGone, CodeResyncRequired, "changes since then were pruned; resync from /v1/manifest or a full pull",
+			map[string]int64{"horizon": h.store.Horizon()})
+		return
+	}
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, CodeInvalidQuery, err.Error(), nil)
+		return
+	}
+	if page.acked > 0 {
+		h.store.AckDevice(deviceID(r.Context()), page.acked)
+	}
+	w.Header().Set("ETag", revisionETag(page.stateRev, variant))
+	if variant != "" {
+		h.pullStream(w, page, have)
+		return
+	}
+	res := PullResponse{
+		Files:    make([]PullFile, 0, len(page.files)),
+		Moved:    make([]Move, len(page.moves)),
+		Deleted:  make([]string, len(page.tombstones)),
+		Revision: page.rev,
+		Next:     page.next,
+	}
+	for _, f := range page.files {
+		if pf, ok := h.pullFile(f, have[f.Path]); ok {
+			res.Files = append(res.Files, pf)
+		}
+	}
+	for i, m := range page.moves {
+		res.Moved[i] = Move{From: m.From, To: m.To}
+	}
+	for i, t := range page.tombstones {
+		res.Deleted[i] = t.Path
+	}
+	for _, l := range page.locks {
+		res.Locks = append(res.Locks, lockInfo(l))
 	}
 	respondJSON(w, http.StatusOK, res)
 }
 
-func (h *Handler) syncToGitHub(ctx context.Context) error {
-	owner := os.Getenv("FLUX_GIT_OWNER")
-	repo := os.Getenv("FLUX_GIT_REPO")
-	token := os.Getenv("FLUX_GIT_TOKEN")
-	files, deleted := h.store.GetFiles()
-	log.Printf("[Flux] Syncing %d files, %d deletes to %s/%s", len(files), len(deleted), owner, repo)
-	if err := h.gh.Sync(ctx, token, owner, repo, files, deleted); err != nil {
-		log.Printf("[Flux] GitHub sync failed: %v", err)
+// pullFile returns f for a client holding the version hashed known: nothing if that is still
+// f, a patch if known is in f's history and the patch is the smaller, else the full content.
+func (h *Handler) pullFile(f *sync.File, known string) (PullFile, bool) {
+	pf := PullFile{Path: f.Path, Content: f.Content, Hash: f.Hash, Vector: f.Vector}
+	if known == f.Hash {
+		return pf, false
+	}
+	if known == "" {
+		return pf, true
+	}
+	for _, v := range h.store.History(f.Path) {
+		if v.Hash != known {
+			continue
+		}
+		base, err := h.store.VersionContent(v)
+		if err != nil {
+			break
+		}
+		edits := sync.Diff(base, f.Content)
+		size := 0
+		for _, e := range edits {
+			size += 32 // at and delete, roughly, as JSON
+			for _, l := range e.Insert {
+				size += len(l)
+			}
+		}
+		if len(edits) > 0 && size < len(f.Content) {
+			pf.Content, pf.BaseHash, pf.Patch = "", known, edits
+		}
+		break
+	}
+	return pf, true
+}
+
+// syncMirrors delivers every queued change, including ones left over from earlier failed pushes.
+func (h *Handler) syncMirrors(ctx context.Context) error {
+	pending := h.mirrors.Pending()
+	if pending == 0 {
+		return nil
+	}
+	log.Printf("[Flux] Syncing %d queued change(s) to %d mirror(s)", pending, h.mirrors.Len())
+	if err := h.mirrors.Flush(ctx, h.store); err != nil {
+		log.Printf("[Flux] Mirror sync failed (request %s): %v", requestID(ctx), err)
 		return err
 	}
-	log.Print("[Flux] GitHub sync done")
+	log.Print("[Flux] Mirror sync done")
 	return nil
 }
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strings"
 	"testing"
 
+	"github.com/shaun/flux/server/internal/config"
 	"github.com/shaun/flux/server/internal/sync"
 )
 
@@ -20,30 +23,73 @@ func init() {
 
 func TestHandler_Health(t *testing.T) {
 	store := sync.NewStore()
-	h := NewHandler(store)
+	h := NewHandler(store, config.Default())
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
 	rec := httptest.NewRecorder()
 	h.Health(rec, req)
 	if rec.Code != http.StatusOK {
 		t.Errorf("Health: code %d", rec.Code)
 	}
-	if body := rec.Body.String(); body != "ok" {
-		t.Errorf("Health: body %q", body)
+	var res HealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("Health: decode %v", err)
+	}
+	if res.Status != "ok" || res.Mode != "standalone" || len(res.Mirrors) != 0 {
+		t.Errorf("Health: %+v", res)
+	}
+}
+
+func TestHandler_Health_mirrored(t *testing.T) {
+	h := NewHandler(sync.NewStore(), config.Default(), &fakeMirror{})
+	rec := httptest.NewRecorder()
+	h.Health(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var res HealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("Health: decode %v", err)
+	}
+	if res.Mode != "mirrored" || len(res.Mirrors) != 1 || res.Mirrors[0] != "fake" {
+		t.Errorf("Health: %+v", res)
+	}
+}
+
+func TestHandler_Status(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("a.md", "x", "h")
+	store.DeleteFile("b.md")
+	fake := &fakeMirror{}
+	h := NewHandler(store, config.Default(), fake)
+	rec := httptest.NewRecorder()
+	h.Status(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var res StatusResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("Status: decode %v", err)
+	}
+	if res.Mode != "mirrored" || res.Files != 1 || res.Deleted != 1 {
+		t.Fatalf("Status: %+v", res)
+	}
+	if len(res.Mirrors) != 1 || res.Mirrors[0].Name != "fake" || !res.Mirrors[0].OK {
+		t.Fatalf("Status mirrors: %+v", res.Mirrors)
+	}
+}
+
+func TestHandler_Push_standalone(t *testing.T) {
+	store := sync.NewStore()
+	h := NewHandler(store, config.Default())
+	body, _ := json.Marshal(PushRequest{Files: []PushFile{{Path: "a.md", Content: "hi", Hash: "h1"}}})
+	rec := httptest.NewRecorder()
+	h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Push standalone: code %d %s", rec.Code, rec.Body.String())
+	}
+	if files, _ := store.Len(); files != 1 {
+		t.Fatalf("Push standalone: %d files", files)
 	}
 }
 
 func TestHandler_Push(t *testing.T) {
 	store := sync.NewStore()
-	fake := &fakeSyncer{}
-	h := NewHandlerWithSyncer(store, fake)
-	os.Setenv("FLUX_GIT_OWNER", "o")
-	os.Setenv("FLUX_GIT_REPO", "r")
-	os.Setenv("FLUX_GIT_TOKEN", "tk")
-	defer func() {
-		os.Unsetenv("FLUX_GIT_OWNER")
-		os.Unsetenv("FLUX_GIT_REPO")
-		os.Unsetenv("FLUX_GIT_TOKEN")
-	}()
+	fake := &fakeMirror{}
+	h := NewHandler(store, config.Default(), fake)
 	reqBody := PushRequest{
 		Files:   []PushFile{{Path: "a.md", Content: "hi", Hash: "h1"}},
 		Deleted: []string{},
@@ -65,15 +111,7 @@ func TestHandler_Push(t *testing.T) {
 
 func TestHandler_Push_withDelete(t *testing.T) {
 	store := sync.NewStore()
-	h := NewHandlerWithSyncer(store, &fakeSyncer{})
-	os.Setenv("FLUX_GIT_OWNER", "o")
-	os.Setenv("FLUX_GIT_REPO", "r")
-	os.Setenv("FLUX_GIT_TOKEN", "tk")
-	defer func() {
-		os.Unsetenv("FLUX_GIT_OWNER")
-		os.Unsetenv("FLUX_GIT_REPO")
-		os.Unsetenv("FLUX_GIT_TOKEN")
-	}()
+	h := NewHandler(store, config.Default(), &fakeMirror{})
 	store.UpsertFile("old.md", "x", "h")
 	reqBody := PushRequest{Files: []PushFile{}, Deleted: []string{"old.md"}}
 	body, _ := json.Marshal(reqBody)
@@ -92,7 +130,7 @@ func TestHandler_Push_withDelete(t *testing.T) {
 
 func TestHandler_Push_rejectGet(t *testing.T) {
 	store := sync.NewStore()
-	h := NewHandler(store)
+	h := NewHandler(store, config.Default())
 	req := httptest.NewRequest(http.MethodGet, "/push", nil)
 	rec := httptest.NewRecorder()
 	h.Push(rec, req)
@@ -103,7 +141,7 @@ func TestHandler_Push_rejectGet(t *testing.T) {
 
 func TestHandler_Push_badJSON(t *testing.T) {
 	store := sync.NewStore()
-	h := NewHandler(store)
+	h := NewHandler(store, config.Default())
 	req := httptest.NewRequest(http.MethodPost, "/push", bytes.NewBufferString("not json"))
 	req.Header.Set("Content-Type", "application/json")
 	rec := httptest.NewRecorder()
@@ -115,16 +153,8 @@ func TestHandler_Push_badJSON(t *testing.T) {
 
 func TestHandler_Push_withSyncer(t *testing.T) {
 	store := sync.NewStore()
-	fake := &fakeSyncer{}
-	h := NewHandlerWithSyncer(store, fake)
-	os.Setenv("FLUX_GIT_OWNER", "o")
-	os.Setenv("FLUX_GIT_REPO", "r")
-	os.Setenv("FLUX_GIT_TOKEN", "tk")
-	defer func() {
-		os.Unsetenv("FLUX_GIT_OWNER")
-		os.Unsetenv("FLUX_GIT_REPO")
-		os.Unsetenv("FLUX_GIT_TOKEN")
-	}()
+	fake := &fakeMirror{}
+	h := NewHandler(store, config.Default(), fake)
 	reqBody := PushRequest{Files: []PushFile{{Path: "x.md", Content: "c", Hash: "h"}}, Deleted: nil}
 	body, _ := json.Marshal(reqBody)
 	req := httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body))
@@ -141,15 +171,7 @@ func TestHandler_Push_withSyncer(t *testing.T) {
 
 func TestHandler_Push_syncerError(t *testing.T) {
 	store := sync.NewStore()
-	h := NewHandlerWithSyncer(store, &fakeSyncer{err: http.ErrAbortHandler})
-	os.Setenv("FLUX_GIT_OWNER", "o")
-	os.Setenv("FLUX_GIT_REPO", "r")
-	os.Setenv("FLUX_GIT_TOKEN", "tk")
-	defer func() {
-		os.Unsetenv("FLUX_GIT_OWNER")
-		os.Unsetenv("FLUX_GIT_REPO")
-		os.Unsetenv("FLUX_GIT_TOKEN")
-	}()
+	h := NewHandler(store, config.Default(), &fakeMirror{err: errors.New("PATCH https://api.github.com/repos/o/r?token=secret: 401")})
 	reqBody := PushRequest{Files: []PushFile{{Path: "x.md", Content: "c", Hash: "h"}}, Deleted: nil}
 	body, _ := json.Marshal(reqBody)
 	req := httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body))
@@ -159,19 +181,21 @@ func TestHandler_Push_syncerError(t *testing.T) {
 	if rec.Code != http.StatusInternalServerError {
 		t.Errorf("Push syncer error: code %d", rec.Code)
 	}
+	if strings.Contains(rec.Body.String(), "secret") || strings.Contains(rec.Body.String(), "api.github.com") {
+		t.Errorf("mirror error leaked to client: %s", rec.Body.String())
+	}
+	var e ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != CodeMirrorFailed || e.Error == "" || e.Error != e.Message {
+		t.Errorf("Push syncer error envelope: %+v", e)
+	}
 }
 
 func TestHandler_Push_rejectsUnsafePaths(t *testing.T) {
 	store := sync.NewStore()
-	h := NewHandlerWithSyncer(store, &fakeSyncer{})
-	os.Setenv("FLUX_GIT_OWNER", "o")
-	os.Setenv("FLUX_GIT_REPO", "r")
-	os.Setenv("FLUX_GIT_TOKEN", "tk")
-	defer func() {
-		os.Unsetenv("FLUX_GIT_OWNER")
-		os.Unsetenv("FLUX_GIT_REPO")
-		os.Unsetenv("FLUX_GIT_TOKEN")
-	}()
+	h := NewHandler(store, config.Default(), &fakeMirror{})
 	reqBody := PushRequest{
 		Files: []PushFile{
 			{Path: "a.md", Content: "ok", Hash: "h"},
@@ -196,15 +220,144 @@ func TestHandler_Push_rejectsUnsafePaths(t *testing.T) {
 	if len(deleted) != 1 || deleted[0] != "good.md" {
 		t.Fatalf("only safe deleted: %+v", deleted)
 	}
+	var res PushResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ResultAccepted, ResultRejected, ResultRejected, ResultRejected, ResultAccepted, ResultRejected, ResultRejected}
+	if len(res.Results) != len(want) {
+		t.Fatalf("one result per entry: %+v", res.Results)
+	}
+	for i, r := range res.Results {
+		if r.Status != want[i] {
+			t.Errorf("result %d (%s %q): %s, want %s", i, r.Op, r.Path, r.Status, want[i])
+		}
+		if r.Status == ResultRejected && r.Reason == "" {
+			t.Errorf("result %d: rejection without reason", i)
+		}
+	}
 }
 
-type fakeSyncer struct {
-	called bool
-	err    error
+func TestHandler_Push_results(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("same.md", "s", "hs")
+	store.UpsertFile("edited.md", "theirs", "h2")
+	store.UpsertFile("taken.md", "t", "ht")
+	store.UpsertFile("src.md", "x", "hx")
+	store.DeleteFile("removed.md")
+	store.DeleteFile("old.md")
+	fake := &fakeMirror{}
+	h := NewHandler(store, config.Default(), fake)
+	body, _ := json.Marshal(PushRequest{
+		Moved: []Move{{From: "src.md", To: "taken.md"}, {From: "nowhere.md", To: "x.md"}},
+		Files: []PushFile{
+			{Path: "same.md", Content: "s", Hash: "hs"},
+			{Path: "edited.md", Content: "mine", Hash: "h3", BaseHash: "h1"},
+			{Path: "removed.md", Content: "r", Hash: "hr", BaseHash: "h0"},
+			{Path: "new.md", Content: "n", Hash: "hn"},
+		},
+		Deleted: []string{"old.md"},
+	})
+	rec := httptest.NewRecorder()
+	h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Push: code %d", rec.Code)
+	}
+	var res PushResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{ResultConflict, ResultRejected, ResultUnchanged, ResultConflict, ResultConflict, ResultAccepted, ResultUnchanged}
+	for i, r := range res.Results {
+		if r.Status != want[i] {
+			t.Errorf("result %d (%s %q): %s, want %s", i, r.Op, r.Path, r.Status, want[i])
+		}
+	}
+	if r := res.Results[3]; r.Hash != "h2" || r.Reason == "" {
+		t.Errorf("conflict should report the server hash: %+v", r)
+	}
+	if f, _ := store.Get("edited.md"); f.Content != "theirs" {
+		t.Error("conflicting write must not overwrite the server copy")
+	}
+	if len(res.Deleted) != 0 {
+		t.Errorf("already-deleted path reported as deleted: %v", res.Deleted)
+	}
+	if fake.calls != 1 || len(fake.deleted) != 0 {
+		t.Errorf("only new.md should reach the mirror: calls=%d deleted=%v", fake.calls, fake.deleted)
+	}
+
+	// Deleting it again changes nothing, not even the revision.
+	rev := store.Revision()
+	rec = httptest.NewRecorder()
+	h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", strings.NewReader(`{"deleted":["old.md"]}`)))
+	res = PushResponse{}
+	json.NewDecoder(rec.Body).Decode(&res)
+	if len(res.Results) != 1 || res.Results[0].Status != ResultUnchanged || store.Revision() != rev {
+		t.Errorf("repeated delete: %+v, revision %d -> %d", res.Results, rev, store.Revision())
+	}
 }
 
-func (f *fakeSyncer) Sync(_ context.Context, _, _, _ string, _ []*sync.File, _ []string) error {
+func TestHandler_Push_strict(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("a.md", "a", "ha")
+	fake := &fakeMirror{}
+	h := NewHandler(store, config.Default(), fake)
+	push := func(req PushRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body)))
+		return rec
+	}
+	rec := push(PushRequest{
+		Strict:  true,
+		Moved:   []Move{{From: "a.md", To: "b.md"}},
+		Files:   []PushFile{{Path: "b.md", Content: "b", Hash: "hb"}, {Path: "../x", Content: "x", Hash: "hx"}},
+		Deleted: []string{"c.md"},
+	})
+	var e struct {
+		Code    string
+		Details struct{ Results []PushResult }
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusUnprocessableEntity || e.Code != CodePushRejected {
+		t.Fatalf("strict push with a bad path: code %d %q", rec.Code, e.Code)
+	}
+	if len(e.Details.Results) != 4 || e.Details.Results[2].Status != ResultRejected {
+		t.Fatalf("strict results: %+v", e.Details.Results)
+	}
+	files, deleted := store.Len()
+	if _, ok := store.Get("a.md"); !ok || files != 1 || deleted != 0 || len(store.Moves()) != 0 {
+		t.Fatalf("rejected strict push must leave the store untouched: files=%d deleted=%d", files, deleted)
+	}
+	if fake.calls != 0 {
+		t.Fatal("rejected strict push must not reach the mirrors")
+	}
+
+	rec = push(PushRequest{Strict: true, Files: []PushFile{{Path: "a.md", Content: "a2", Hash: "ha2", BaseHash: "ha"}}})
+	var res PushResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || res.Status != "ok" || res.Results[0].Status != ResultAccepted {
+		t.Fatalf("clean strict push: code %d %+v", rec.Code, res)
+	}
+}
+
+type fakeMirror struct {
+	called  bool
+	calls   int
+	deleted []string
+	err     error
+}
+
+func (f *fakeMirror) Name() string { return "fake" }
+
+func (f *fakeMirror) Sync(_ context.Context, _ []*sync.File, deleted []string) error {
 	f.called = true
+	f.calls++
+	f.deleted = deleted
 	return f.err
 }
 
@@ -212,7 +365,7 @@ func TestHandler_Pull(t *testing.T) {
 	store := sync.NewStore()
 	store.UpsertFile("f.md", "content", "hash")
 	store.DeleteFile("gone.md")
-	h := NewHandler(store)
+	h := NewHandler(store, config.Default())
 	req := httptest.NewRequest(http.MethodGet, "/pull", nil)
 	rec := httptest.NewRecorder()
 	h.Pull(rec, req)
@@ -230,3 +383,252 @@ func TestHandler_Pull(t *testing.T) {
 		t.Fatalf("Pull: deleted %+v", res.Deleted)
 	}
 }
+
+func TestHandler_Push_bodyLimit(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.MaxPushBytes = 16
+	h := NewHandler(sync.NewStore(), cfg)
+	body, _ := json.Marshal(PushRequest{Files: []PushFile{{Path: "a.md", Content: "more than sixteen bytes", Hash: "h"}}})
+	rec := httptest.NewRecorder()
+	h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Push over limit: code %d", rec.Code)
+	}
+	var e ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil || e.Code != CodeBodyTooLarge {
+		t.Errorf("Push over limit: %+v %v", e, err)
+	}
+}
+
+func TestHandler_Reload(t *testing.T) {
+	store := sync.NewStore()
+	h := NewHandler(store, config.Default())
+	router := NewRouter(h)
+
+	next := config.Default()
+	next.Auth.Users = []config.User{{Name: "u", Password: "p"}}
+	next.Limits.MaxPathLength = 4
+	h.Reload(next, &fakeMirror{})
+	if h.Config() != next {
+		t.Fatal("Config() should return the reloaded config")
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pull", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("new users not enforced: code %d", rec.Code)
+	}
+
+	body, _ := json.Marshal(PushRequest{Files: []PushFile{{Path: "long.md", Content: "x", Hash: "h"}, {Path: "a.md", Content: "x", Hash: "h"}}})
+	req := httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body))
+	req.SetBasicAuth("u", "p")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("push after reload: code %d", rec.Code)
+	}
+	if files, _ := store.GetFiles(); len(files) != 1 || files[0].Path != "a.md" {
+		t.Errorf("new path limit not applied: %+v", files)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Health(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if !bytes.Contains(rec.Body.Bytes(), []byte(`"mode":"mirrored"`)) {
+		t.Errorf("new mirrors not reported: %s", rec.Body.String())
+	}
+}
+
+func TestHandler_Push_retriesQueuedChanges(t *testing.T) {
+	store := sync.NewStore()
+	fake := &fakeMirror{err: http.ErrAbortHandler}
+	h := NewHandler(store, config.Default(), fake)
+	push := func(path string) int {
+		body, _ := json.Marshal(PushRequest{Files: []PushFile{{Path: path, Content: "c", Hash: "h"}}})
+		rec := httptest.NewRecorder()
+		h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body)))
+		return rec.Code
+	}
+	if code := push("a.md"); code != http.StatusInternalServerError {
+		t.Fatalf("first push: code %d", code)
+	}
+	if h.Mirrors().Pending() != 1 {
+		t.Fatalf("failed change should stay queued, pending=%d", h.Mirrors().Pending())
+	}
+	fake.err = nil
+	if code := push("b.md"); code != http.StatusOK {
+		t.Fatalf("second push: code %d", code)
+	}
+	if h.Mirrors().Pending() != 0 {
+		t.Fatalf("queue should be drained, pending=%d", h.Mirrors().Pending())
+	}
+}
+
+func TestHandler_Push_folderDelete(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("Folder/a.md", "a", "h")
+	store.UpsertFile("Folder/sub/b.md", "b", "h")
+	store.UpsertFile("keep.md", "k", "h")
+	fake := &fakeMirror{}
+	h := NewHandler(store, config.Default(), fake)
+	body, _ := json.Marshal(PushRequest{Deleted: []string{"Folder"}})
+	rec := httptest.NewRecorder()
+	h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Push folder delete: code %d", rec.Code)
+	}
+	var res PushResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 2 || res.Deleted[0] != "Folder/a.md" || res.Deleted[1] != "Folder/sub/b.md" {
+		t.Fatalf("response deleted: %+v", res.Deleted)
+	}
+	files, deleted := store.GetFiles()
+	if len(files) != 1 || files[0].Path != "keep.md" || len(deleted) != 2 {
+		t.Fatalf("store: files=%+v deleted=%v", files, deleted)
+	}
+	if fake.calls != 1 || len(fake.deleted) != 2 {
+		t.Fatalf("mirror should get both removals in one sync: calls=%d deleted=%v", fake.calls, fake.deleted)
+	}
+}
+
+func TestHandler_Push_move(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("Old/a.md", "a", "h")
+	store.UpsertFile("Old/b.md", "b", "h")
+	store.UpsertFile("note.md", "n", "h")
+	fake := &fakeMirror{}
+	h := NewHandler(store, config.Default(), fake)
+	body, _ := json.Marshal(PushRequest{
+		Moved: []Move{{From: "Old", To: "New"}, {From: "note.md", To: "New/note.md"}, {From: "../x", To: "y"}, {From: "missing.md", To: "z.md"}},
+		Files: []PushFile{{Path: "New/note.md", Content: "edited", Hash: "h2"}},
+	})
+	rec := httptest.NewRecorder()
+	h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Push move: code %d", rec.Code)
+	}
+	var res PushResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Moved) != 3 {
+		t.Fatalf("response moved: %+v", res.Moved)
+	}
+	if f, ok := store.Get("New/note.md"); !ok || f.Content != "edited" {
+		t.Fatalf("edit after move should apply to the new path: %+v", f)
+	}
+	if h := store.History("New/note.md"); len(h) != 1 || h[0].Content != "n" {
+		t.Fatalf("history should survive the move: %+v", h)
+	}
+	if fake.calls != 1 || len(fake.deleted) != 3 {
+		t.Fatalf("mirror should get the rename in one sync: calls=%d deleted=%v", fake.calls, fake.deleted)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Pull(rec, httptest.NewRequest(http.MethodGet, "/pull", nil))
+	var pull PullResponse
+	if err := json.NewDecoder(rec.Body).Decode(&pull); err != nil {
+		t.Fatal(err)
+	}
+	if len(pull.Moved) != 3 || len(pull.Deleted) != 3 {
+		t.Fatalf("pull should replay moves and keep sources as tombstones: moved=%+v deleted=%v", pull.Moved, pull.Deleted)
+	}
+}
+
+func TestHandler_Push_patch(t *testing.T) {
+	base := strings.Repeat("line\n", 100)
+	store := sync.NewStore()
+	store.UpsertFile("big.md", base, sync.ContentHash(base))
+	h := NewHandler(store, config.Default(), &fakeMirror{})
+	edited := strings.Replace(base, "line\n", "first\n", 1)
+
+	push := func(files string) PushResult {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.Push(rec, httptest.NewRequest(http.MethodPost, "/push", strings.NewReader(`{"files":[`+files+`]}`)))
+		var res PushResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || len(res.Results) != 1 {
+			t.Fatalf("push: %d %v", rec.Code, err)
+		}
+		return res.Results[0]
+	}
+	patch := `[{"at":0,"delete":1,"insert":["first\n"]}]`
+	if r := push(`{"path":"big.md","baseHash":"` + sync.ContentHash(base) + `","hash":"` + sync.ContentHash(edited) + `","patch":` + patch + `}`); r.Status != ResultAccepted {
+		t.Fatalf("patch: %+v", r)
+	}
+	if f, _ := store.Get("big.md"); f.Content != edited {
+		t.Fatalf("patched content: %q", f.Content[:20])
+	}
+
+	for _, c := range []struct{ file, status, reason string }{
+		{`{"path":"big.md","baseHash":"` + sync.ContentHash(base) + `","patch":` + patch + `}`, ResultConflict, "patch base mismatch"},
+		{`{"path":"new.md","baseHash":"x","patch":` + patch + `}`, ResultConflict, "patch base mismatch"},
+		{`{"path":"big.md","patch":` + patch + `}`, ResultRejected, "patch without baseHash"},
+		{`{"path":"big.md","baseHash":"` + sync.ContentHash(edited) + `","patch":[{"at":500}]}`, ResultRejected, "invalid patch"},
+		{`{"path":"big.md","baseHash":"` + sync.ContentHash(edited) + `","hash":"wrong","patch":` + patch + `}`, ResultRejected, "patched content does not match hash"},
+	} {
+		if r := push(c.file); r.Status != c.status || r.Reason != c.reason {
+			t.Errorf("%s: got %+v, want %s %q", c.file, r, c.status, c.reason)
+		}
+	}
+	store.DeleteFile("big.md")
+	if r := push(`{"path":"big.md","baseHash":"` + sync.ContentHash(edited) + `","patch":` + patch + `}`); r.Reason != "deleted on server" {
+		t.Fatalf("patch onto a deleted file: %+v", r)
+	}
+}
+
+func TestHandler_Push_vectors(t *testing.T) {
+	store := sync.NewStore()
+	r := NewRouter(NewHandler(store, config.Default(), &fakeMirror{}))
+	push := func(device, file string) PushResult {
+		t.Helper()
+		rec := serve(t, r, http.MethodPost, "/v1/push", `{"files":[`+file+`]}`, map[string]string{headerDeviceID: device})
+		var res PushResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || len(res.Results) != 1 {
+			t.Fatalf("push: %d %v", rec.Code, err)
+		}
+		return res.Results[0]
+	}
+	vec := func(v sync.VersionVector) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+
+	if res := push("laptop", `{"path":"a.md","content":"1"}`); res.Status != ResultAccepted || !maps.Equal(res.Vector, sync.VersionVector{"laptop": 1}) {
+		t.Fatalf("a write without a vector ticks the device: %+v", res)
+	}
+	// The laptop edits again without having seen its first push acknowledged: still a fast-forward.
+	if res := push("laptop", `{"path":"a.md","content":"2","baseHash":"stale","vector":{"laptop":2}}`); res.Status != ResultAccepted {
+		t.Fatalf("fast-forward: %+v", res)
+	}
+	for _, c := range []struct{ file, status, reason string }{
+		{`{"path":"a.md","content":"3","vector":{"laptop":1,"phone":1}}`, ResultConflict, "concurrent edit"},
+		{`{"path":"a.md","content":"0","vector":{"laptop":1}}`, ResultConflict, "changed on server"},
+		{`{"path":"a.md","content":"2","vector":{"laptop":1,"phone":1}}`, ResultUnchanged, ""},
+	} {
+		res := push("phone", c.file)
+		if res.Status != c.status || res.Reason != c.reason || !maps.Equal(res.Vector, sync.VersionVector{"laptop": 2}) {
+			t.Errorf("%s: %+v", c.file, res)
+		}
+	}
+	if f, _ := store.Get("a.md"); f.Content != "2" {
+		t.Fatalf("conflicts must not overwrite: %q", f.Content)
+	}
+
+	serve(t, r, http.MethodDelete, "/v1/files/a.md", "", map[string]string{headerDeviceID: "laptop"})
+	tomb := sync.VersionVector{"laptop": 3}
+	if res := push("phone", `{"path":"a.md","content":"3","vector":{"laptop":2,"phone":1}}`); res.Reason != "deleted on server" || !maps.Equal(res.Vector, tomb) {
+		t.Fatalf("edit of a deleted file: %+v", res)
+	}
+	if res := push("phone", `{"path":"a.md","content":"3","vector":`+vec(tomb.Merge(sync.VersionVector{"phone": 1}))+`}`); res.Status != ResultAccepted {
+		t.Fatalf("recreate after seeing the delete: %+v", res)
+	}
+
+	rec := serve(t, r, http.MethodPost, "/v1/replay", `{"ops":[{"op":"modify","path":"a.md","content":"4","vector":{"laptop":4}},{"op":"delete","path":"a.md","vector":{"laptop":3}}]}`, nil)
+	var res PushResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if len(res.Results) != 2 || res.Results[0].Reason != "concurrent edit" || res.Results[1].Reason != "changed on server" {
+		t.Fatalf("replay with vectors: %+v", res.Results)
+	}
+}
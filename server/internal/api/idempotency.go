@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	gosync "sync"
+	"time"
+)
+
+const (
+	// idempotencyTTL is how long a push result is remembered for replay.
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyMax bounds the remembered results; the oldest go first.
+	idempotencyMax = 10000
+)
+
+// idempotencyCache remembers recent push responses by key, so a client retrying a push whose
+// response was lost gets the original answer instead of applying the changes again.
+// Results live in memory and are forgotten on restart.
+type idempotencyCache struct {
+	mu      gosync.Mutex
+	entries map[string]*idempotentResult
+	now     func() time.Time
+}
+
+type idempotentResult struct {
+	fingerprint string
+	at          time.Time
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[string]*idempotentResult), now: time.Now}
+}
+
+// begin claims key for a new push, or returns the existing entry for it. A claimed entry
+// must be completed with finish.
+func (c *idempotencyCache) begin(key, fingerprint string) (*idempotentResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if e, ok := c.entries[key]; ok && now.Sub(e.at) < idempotencyTTL {
+		return e, false
+	}
+	if len(c.entries) >= idempotencyMax {
+		c.evict(now)
+	}
+	c.entries[key] = &idempotentResult{fingerprint: fingerprint, at: now}
+	return nil, true
+}
+
+// evict drops expired results, then the oldest if the cache is still full. Callers hold c.mu.
+func (c *idempotencyCache) evict(now time.Time) {
+	var oldest string
+	for k, e := range c.entries {
+		if now.Sub(e.at) >= idempotencyTTL {
+			delete(c.entries, k)
+		} else if oldest == "" || e.at.Before(c.entries[oldest].at) {
+			oldest = k
+		}
+	}
+	if len(c.entries) >= idempotencyMax {
+		delete(c.entries, oldest)
+	}
+}
+
+// finish records the response for key. Requests rejected before anything was applied
+// (malformed or oversized bodies) are forgotten so the client can fix them and retry; an
+// error after part of the push was applied is kept, since a retry would apply that part again.
+// Server errors (e.g. mirror_failed, whose changes are saved and retried anyway) and pushes
+// that never answered, such as after a panic, aren't final either: they are forgotten so a
+// retry gets a current answer instead of the failure for a day.
+func (c *idempotencyCache) finish(key string, rec *captureWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch rec.status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType:
+		if !rec.applied {
+			delete(c.entries, key)
+			return
+		}
+	}
+	if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+		delete(c.entries, key)
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		e.done, e.status, e.contentType, e.body = true, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes()
+	}
+}
+
+// captureWriter passes a response through while keeping a copy for the cache.
+type captureWriter struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	applied bool // part of the push took effect, so even an error response is final
+}
+
+// markApplied records that the push behind w changed something before it failed.
+func markApplied(w http.ResponseWriter) {
+	if cw, ok := w.(*captureWriter); ok {
+		cw.applied = true
+	}
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+// fingerprint identifies a request body, so a key reused for a different push is caught.
+// Callers clear the body's own key first.
+func fingerprint(req any) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotent runs push at most once per key. Keys are scoped to the authenticated user.
+// fingerprint is empty for streamed pushes, whose body isn't read up front; the key alone
+// identifies them.
+func (h *Handler) idempotent(w http.ResponseWriter, r *http.Request, key, fingerprint string, push func(http.ResponseWriter)) {
+	if key == "" {
+		push(w)
+		return
+	}
+	if len(key) > 255 {
+		respondError(w, r, http.StatusBadRequest, CodeInvalidQuery, "idempotency key longer than 255 bytes", nil)
+		return
+	}
+	user, _, _ := r.BasicAuth()
+	scoped := user + "\x00" + key
+	prev, fresh := h.idempotency.begin(scoped, fingerprint)
+	if !fresh {
+		h.idempotency.mu.Lock()
+		e := *prev
+		h.idempotency.mu.Unlock()
+		switch {
+		case e.fingerprint != fingerprint:
+			respondError(w, r, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "idempotency key was used for a different push", nil)
+		case !e.done:
+			respondError(w, r, http.StatusConflict, CodeIdempotencyInProgress, "a push with this idempotency key is still running", nil)
+		default:
+			w.Header().Set("Content-Type", e.contentType)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.status)
+			w.Write(e.body)
+		}
+		return
+	}
+	rec := &captureWriter{ResponseWriter: w}
+	defer h.idempotency.finish(scoped, rec)
+	push(rec)
+}
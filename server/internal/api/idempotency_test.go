@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestPush_idempotencyKey(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("a.md", "a", "ha")
+	fake := &fakeMirror{}
+	r := NewRouter(NewHandler(store, config.Default(), fake))
+
+	rename := `{"moved":[{"from":"a.md","to":"b.md"}],"files":[]}`
+	first := serve(t, r, http.MethodPost, "/v1/push", rename, map[string]string{"Idempotency-Key": "k1"})
+	if first.Code != http.StatusOK {
+		t.Fatalf("first push: %d %s", first.Code, first.Body.String())
+	}
+	// A newer edit lands between the lost response and the retry.
+	store.UpsertFile("a.md", "recreated", "hr")
+
+	retry := serve(t, r, http.MethodPost, "/v1/push", rename, map[string]string{"Idempotency-Key": "k1"})
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry should replay the original response: %d %s", retry.Code, retry.Body.String())
+	}
+	if f, ok := store.Get("a.md"); !ok || f.Content != "recreated" || fake.calls != 1 {
+		t.Fatalf("retry must not re-apply the rename: %+v calls=%d", f, fake.calls)
+	}
+
+	other := serve(t, r, http.MethodPost, "/v1/push", `{"deleted":["b.md"]}`, map[string]string{"Idempotency-Key": "k1"})
+	var e ErrorResponse
+	json.NewDecoder(other.Body).Decode(&e)
+	if other.Code != http.StatusUnprocessableEntity || e.Code != CodeIdempotencyKeyReused {
+		t.Fatalf("key reused for another push: %d %+v", other.Code, e)
+	}
+
+	// The key may also travel in the body, and is scoped per user.
+	body := `{"deleted":["b.md"],"idempotencyKey":"k2"}`
+	if rec := serve(t, r, http.MethodPost, "/v1/push", body, nil); rec.Code != http.StatusOK {
+		t.Fatalf("body key: %d", rec.Code)
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/push", body, nil); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("body key retry should replay")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/push", jsonBody(body))
+	req.SetBasicAuth("someone-else", "")
+	rec := httptest.NewRecorder()
+	NewHandler(store, config.Default()).Push(rec, req)
+	if rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatal("keys are scoped per user")
+	}
+
+	// Malformed pushes aren't remembered, so the fixed push can reuse the key.
+	if rec := serve(t, r, http.MethodPost, "/v1/push", `{`, map[string]string{"Idempotency-Key": "k3"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad json: %d", rec.Code)
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/push", `{"deleted":["x.md"]}`, map[string]string{"Idempotency-Key": "k3"}); rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("fixed push after bad json: %d", rec.Code)
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/push", `{"op":"delete","path":"y.md"}`, map[string]string{"Idempotency-Key": "k4", "Content-Type": ndjson}); rec.Code != http.StatusOK {
+		t.Fatalf("ndjson with key: %d", rec.Code)
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/push", `{"op":"delete","path":"y.md"}`, map[string]string{"Idempotency-Key": "k4", "Content-Type": ndjson}); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("ndjson retry should replay")
+	}
+
+	// A stream that fails part way has applied its earlier lines; the retry must not repeat them.
+	stream := `{"op":"write","path":"s.md","content":"one"}` + "\n" + `{"op":"rename"}`
+	headers := map[string]string{"Idempotency-Key": "k5", "Content-Type": ndjson}
+	first = serve(t, r, http.MethodPost, "/v1/push", stream, headers)
+	if first.Code != http.StatusBadRequest {
+		t.Fatalf("bad line 2: %d %s", first.Code, first.Body.String())
+	}
+	store.UpsertFile("s.md", "edited since", "hs")
+	retry = serve(t, r, http.MethodPost, "/v1/push", stream, headers)
+	if retry.Code != http.StatusBadRequest || retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Fatalf("retry of a partly applied stream should replay: %d %s", retry.Code, retry.Body.String())
+	}
+	if f, _ := store.Get("s.md"); f.Content != "edited since" {
+		t.Fatalf("retry re-applied line 1: %q", f.Content)
+	}
+
+	// A mirror failure isn't final: once the mirror recovers, the retry gets a fresh answer.
+	fake.err = errors.New("github down")
+	headers = map[string]string{"Idempotency-Key": "k6"}
+	if rec := serve(t, r, http.MethodPost, "/v1/push", `{"files":[{"path":"m.md","content":"m"}]}`, headers); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("push with the mirror down: %d", rec.Code)
+	}
+	fake.err = nil
+	retry = serve(t, r, http.MethodPost, "/v1/push", `{"files":[{"path":"m.md","content":"m"}]}`, headers)
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry after the mirror recovered: %d %s", retry.Code, retry.Body.String())
+	}
+}
+
+func TestIdempotent_panic(t *testing.T) {
+	h := NewHandler(sync.NewStore(), config.Default())
+	req := httptest.NewRequest(http.MethodPost, "/v1/push", nil)
+	func() {
+		defer func() { recover() }()
+		h.idempotent(httptest.NewRecorder(), req, "k", "fp", func(http.ResponseWriter) { panic("boom") })
+	}()
+	if _, fresh := h.idempotency.begin("\x00k", "fp"); !fresh {
+		t.Fatal("a push that panicked must not be cached")
+	}
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	c := newIdempotencyCache()
+	now := time.Unix(0, 0)
+	c.now = func() time.Time { return now }
+	if _, fresh := c.begin("k", "fp"); !fresh {
+		t.Fatal("first begin should claim the key")
+	}
+	if e, fresh := c.begin("k", "fp"); fresh || e.done {
+		t.Fatal("in-flight key should be reported")
+	}
+	now = now.Add(idempotencyTTL)
+	if _, fresh := c.begin("k", "fp"); !fresh {
+		t.Fatal("expired key should be claimable again")
+	}
+	for i := range idempotencyMax + 5 {
+		now = now.Add(time.Millisecond)
+		c.begin(string(rune(i)), "fp")
+	}
+	if len(c.entries) > idempotencyMax {
+		t.Fatalf("cache should stay bounded: %d", len(c.entries))
+	}
+}
+
+func jsonBody(s string) *bytes.Reader {
+	return bytes.NewReader([]byte(s))
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// Locks are advisory and short-lived: a device takes one with PUT, renews it by repeating
+// the PUT as a heartbeat before it expires, and releases it with DELETE. While it is held,
+// writes, moves and deletes of the path by other devices are refused, as are those of
+// requests without X-Device-ID, which could come from anyone.
+const (
+	defaultLockTTL = 2 * time.Minute
+	maxLockTTL     = time.Hour
+)
+
+// errLocked aborts a single-file batch on a path another device has locked.
+var errLocked = errors.New("locked")
+
+// ListLocks returns the locks currently held, sorted by path.
+func (h *Handler) ListLocks(w http.ResponseWriter, r *http.Request) {
+	res := LocksResponse{Locks: []LockInfo{}}
+	for _, l := range h.store.Locks() {
+		res.Locks = append(res.Locks, lockInfo(l))
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// AcquireLock takes or renews the calling device's lock on a path for the ttl query
+// parameter, in seconds.
+func (h *Handler) AcquireLock(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.filePath(w, r)
+	if !ok {
+		return
+	}
+	ttl := defaultLockTTL
+	if s := r.URL.Query().Get("ttl"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || time.Duration(n)*time.Second > maxLockTTL {
+			respondError(w, r, http.StatusBadRequest, CodeInvalidQuery, "ttl must be between 1 and 3600 seconds", nil)
+			return
+		}
+		ttl = time.Duration(n) * time.Second
+	}
+	device, ok := requireDevice(w, r)
+	if !ok {
+		return
+	}
+	l, err := h.store.Lock(p, device, ttl)
+	if errors.Is(err, sync.ErrLocked) {
+		respondLocked(w, r, l)
+		return
+	}
+	respondJSON(w, http.StatusOK, lockInfo(l))
+}
+
+// ReleaseLock gives up the calling device's lock on a path.
+func (h *Handler) ReleaseLock(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.filePath(w, r)
+	if !ok {
+		return
+	}
+	device, ok := requireDevice(w, r)
+	if !ok {
+		return
+	}
+	l, err := h.store.Unlock(p, device)
+	switch {
+	case errors.Is(err, sync.ErrNotFound):
+		respondError(w, r, http.StatusNotFound, CodeNotFound, "lock not found", nil)
+	case errors.Is(err, sync.ErrLocked):
+		respondLocked(w, r, l)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func requireDevice(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := deviceID(r.Context())
+	if id == "" {
+		respondError(w, r, http.StatusBadRequest, CodeDeviceRequired, "locks need an X-Device-ID", nil)
+	}
+	return id, id != ""
+}
+
+func respondLocked(w http.ResponseWriter, r *http.Request, l sync.Lock) {
+	respondError(w, r, http.StatusLocked, CodeLocked, "locked by another device", map[string]any{"lock": lockInfo(l)})
+}
+
+func lockInfo(l sync.Lock) LockInfo {
+	return LockInfo{Path: l.Path, Device: l.Device, AcquiredAt: l.AcquiredAt, ExpiresAt: l.ExpiresAt}
+}
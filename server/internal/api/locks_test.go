@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestLocks(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("Team/minutes.md", "m", "hm")
+	r := NewRouter(NewHandler(store, config.Default(), &fakeMirror{}))
+	laptop := map[string]string{headerDeviceID: "laptop", "Content-Type": "application/json"}
+	phone := map[string]string{headerDeviceID: "phone", "Content-Type": "application/json"}
+	errCode := func(method, path, body string, header map[string]string) (int, ErrorResponse) {
+		t.Helper()
+		rec := serve(t, r, method, path, body, header)
+		var e ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&e)
+		return rec.Code, e
+	}
+
+	rec := serve(t, r, http.MethodPut, "/v1/locks/Team/minutes.md?ttl=60", "", laptop)
+	var l LockInfo
+	if json.NewDecoder(rec.Body).Decode(&l); rec.Code != http.StatusOK || l.Device != "laptop" || l.ExpiresAt-l.AcquiredAt != 60000 {
+		t.Fatalf("acquire: %d %+v", rec.Code, l)
+	}
+	if rec := serve(t, r, http.MethodPut, "/v1/locks/Team/minutes.md", "", laptop); rec.Code != http.StatusOK {
+		t.Fatalf("heartbeat: %d", rec.Code)
+	}
+	for _, c := range []struct {
+		method, path string
+		header       map[string]string
+		status       int
+		code         string
+	}{
+		{http.MethodPut, "/v1/locks/Team/minutes.md", phone, http.StatusLocked, CodeLocked},
+		{http.MethodPut, "/v1/locks/Team/minutes.md", nil, http.StatusBadRequest, CodeDeviceRequired},
+		{http.MethodPut, "/v1/locks/Team/minutes.md?ttl=0", laptop, http.StatusBadRequest, CodeInvalidQuery},
+		{http.MethodPut, "/v1/locks/../x", laptop, http.StatusBadRequest, CodeInvalidPath},
+		{http.MethodDelete, "/v1/locks/Team/minutes.md", phone, http.StatusLocked, CodeLocked},
+		{http.MethodDelete, "/v1/locks/other.md", phone, http.StatusNotFound, CodeNotFound},
+		{http.MethodPut, "/v1/files/Team/minutes.md", phone, http.StatusLocked, CodeLocked},
+		{http.MethodPut, "/v1/files/Team/minutes.md", map[string]string{"Content-Type": "application/json"}, http.StatusLocked, CodeLocked},
+		{http.MethodDelete, "/v1/files/Team", phone, http.StatusLocked, CodeLocked},
+	} {
+		if status, e := errCode(c.method, c.path, `{"content":"x"}`, c.header); status != c.status || e.Code != c.code {
+			t.Errorf("%s %s: %d %+v", c.method, c.path, status, e)
+		}
+	}
+
+	// Pushes from other devices are refused entry by entry, or kept as conflict copies.
+	rec = serve(t, r, http.MethodPost, "/v1/push", `{"moved":[{"from":"Team/minutes.md","to":"x.md"}],"files":[{"path":"Team/minutes.md","content":"p"}],"deleted":["Team"]}`, phone)
+	var res PushResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	for _, pr := range res.Results {
+		if pr.Status != ResultRejected || pr.Reason != "locked" || pr.Lock == nil || pr.Lock.Device != "laptop" {
+			t.Errorf("locked %s: %+v", pr.Op, pr)
+		}
+	}
+	json.NewDecoder(serve(t, r, http.MethodPost, "/v1/push", `{"conflictCopies":true,"files":[{"path":"Team/minutes.md","content":"p"}]}`, phone).Body).Decode(&res)
+	if pr := res.Results[0]; pr.Status != ResultCopied || pr.Reason != "locked" {
+		t.Errorf("locked write with copies: %+v", pr)
+	}
+	json.NewDecoder(serve(t, r, http.MethodPost, "/v1/push", `{"files":[{"path":"Team/minutes.md","content":"l"}]}`, laptop).Body).Decode(&res)
+	if pr := res.Results[0]; pr.Status != ResultAccepted {
+		t.Errorf("the holder writes freely: %+v", pr)
+	}
+
+	// Locks show in pull, manifest and the lock list.
+	var pull PullResponse
+	json.NewDecoder(serve(t, r, http.MethodGet, "/v1/pull?prefix=Team/", "", nil).Body).Decode(&pull)
+	var manifest ManifestResponse
+	json.NewDecoder(serve(t, r, http.MethodGet, "/v1/manifest?prefix=Other/", "", nil).Body).Decode(&manifest)
+	var list LocksResponse
+	json.NewDecoder(serve(t, r, http.MethodGet, "/v1/locks", "", nil).Body).Decode(&list)
+	if len(pull.Locks) != 1 || pull.Locks[0].Path != "Team/minutes.md" || len(manifest.Locks) != 0 || len(list.Locks) != 1 {
+		t.Fatalf("lock state: pull %+v, manifest %+v, list %+v", pull.Locks, manifest.Locks, list.Locks)
+	}
+	stream := serve(t, r, http.MethodGet, "/v1/pull", "", map[string]string{"Accept": ndjson})
+	var events []PullEvent
+	for dec := json.NewDecoder(stream.Body); ; {
+		var ev PullEvent
+		if dec.Decode(&ev) != nil {
+			break
+		}
+		if ev.Type == "lock" {
+			events = append(events, ev)
+		}
+	}
+	if len(events) != 1 || events[0].Device != "laptop" || events[0].ExpiresAt == 0 {
+		t.Fatalf("NDJSON lock events: %+v", events)
+	}
+
+	if rec := serve(t, r, http.MethodDelete, "/v1/locks/Team/minutes.md", "", laptop); rec.Code != http.StatusNoContent {
+		t.Fatalf("release: %d", rec.Code)
+	}
+	if status, _ := errCode(http.MethodPut, "/v1/files/Team/minutes.md", `{"content":"x"}`, phone); status != http.StatusOK {
+		t.Fatalf("write after release: %d", status)
+	}
+
+	// A lock that expires changes the ETag, so pollers don't keep a stale lock.
+	store.Lock("Team/minutes.md", "laptop", time.Millisecond)
+	tag := serve(t, r, http.MethodGet, "/v1/pull", "", nil).Header().Get("ETag")
+	time.Sleep(5 * time.Millisecond)
+	rec = serve(t, r, http.MethodGet, "/v1/pull", "", map[string]string{"If-None-Match": tag})
+	pull = PullResponse{}
+	if json.NewDecoder(rec.Body).Decode(&pull); rec.Code != http.StatusOK || len(pull.Locks) != 0 {
+		t.Fatalf("pull after expiry: %d %+v", rec.Code, pull.Locks)
+	}
+}
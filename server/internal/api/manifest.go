@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Manifest lists every file, tombstone and lock under the optional prefix query parameter,
+// with hashes and sizes but no content, so clients can work out what changed and fetch only
+// that. Like Pull, it answers If-None-Match with 304 while the store revision is unchanged.
+func (h *Handler) Manifest(w http.ResponseWriter, r *http.Request) {
+	h.store.ExpireLocks()
+	if notModified(w, r, revisionETag(h.store.Revision(), "")) {
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	st := h.store.State()
+	w.Header().Set("ETag", revisionETag(st.Rev, ""))
+	res := ManifestResponse{Revision: st.Rev, Files: []FileInfo{}, Deleted: []TombstoneInfo{}}
+	for _, f := range st.Files {
+		if strings.HasPrefix(f.Path, prefix) {
+			res.Files = append(res.Files, fileInfo(f))
+		}
+	}
+	for _, t := range st.Tombstones {
+		if strings.HasPrefix(t.Path, prefix) {
+			res.Deleted = append(res.Deleted, TombstoneInfo{Path: t.Path, Revision: t.Rev, DeletedAt: t.DeletedAt, Device: t.Device, Vector: t.Vector})
+		}
+	}
+	for _, l := range h.store.Locks() {
+		if strings.HasPrefix(l.Path, prefix) {
+			res.Locks = append(res.Locks, lockInfo(l))
+		}
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// Fetch returns the content of the requested files in one round trip.
+func (h *Handler) Fetch(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config()
+	var req FetchRequest
+	if !decodeJSON(w, r, cfg, &req) {
+		return
+	}
+	res := FetchResponse{Files: []FileResponse{}, Missing: []string{}}
+	for _, p := range req.Paths {
+		if !safePath(p, cfg.Limits.MaxPathLength) {
+			respondError(w, r, http.StatusBadRequest, CodeInvalidPath, "invalid path", map[string]string{"path": p})
+			return
+		}
+		if f, ok := h.store.Get(p); ok {
+			res.Files = append(res.Files, fileResponse(f))
+		} else {
+			res.Missing = append(res.Missing, p)
+		}
+	}
+	respondJSON(w, http.StatusOK, res)
+}
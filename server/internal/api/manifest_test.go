@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestManifest(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("Notes/a.md", "aaa", "ha")
+	store.UpsertFile("Notes/b.md", "b", "hb")
+	store.UpsertFile("top.md", "t", "ht")
+	store.DeleteFile("Notes/b.md")
+	store.DeleteFile("gone.md")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	rec := serve(t, r, http.MethodGet, "/v1/manifest?prefix=Notes/", "", nil)
+	var m ManifestResponse
+	if err := json.NewDecoder(rec.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Revision != 5 || len(m.Files) != 1 || len(m.Deleted) != 1 {
+		t.Fatalf("manifest: %+v", m)
+	}
+	if f := m.Files[0]; f.Path != "Notes/a.md" || f.Hash != "ha" || f.Size != 3 || f.Revision != 1 || f.UpdatedAt == 0 {
+		t.Errorf("manifest file: %+v", f)
+	}
+	if d := m.Deleted[0]; d.Path != "Notes/b.md" || d.Revision != 4 || d.DeletedAt == 0 {
+		t.Errorf("manifest tombstone: %+v", d)
+	}
+	if rec := serve(t, r, http.MethodGet, "/v1/manifest", "", nil); !json.Valid(rec.Body.Bytes()) || rec.Code != http.StatusOK {
+		t.Errorf("full manifest: %d", rec.Code)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("a.md", "a", "ha")
+	store.UpsertFile("b.md", "b", "hb")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	rec := serve(t, r, http.MethodPost, "/v1/fetch", `{"paths":["b.md","missing.md","a.md"]}`, nil)
+	var res FetchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 2 || res.Files[0].Path != "b.md" || res.Files[0].Content != "b" || len(res.Missing) != 1 {
+		t.Fatalf("fetch: %+v", res)
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/fetch", `{"paths":["../etc/passwd"]}`, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("fetch unsafe path: %d", rec.Code)
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/fetch", `nope`, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("fetch bad json: %d", rec.Code)
+	}
+}
+
+func TestPullManifest_notModified(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("a.md", "a", "ha")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	for _, path := range []string{"/pull", "/v1/pull?limit=1", "/v1/manifest"} {
+		rec := serve(t, r, http.MethodGet, path, "", nil)
+		tag := rec.Header().Get("ETag")
+		if rec.Code != http.StatusOK || tag == "" || rec.Header().Get("Cache-Control") != "no-cache" {
+			t.Fatalf("%s: %d etag=%q", path, rec.Code, tag)
+		}
+		rec = serve(t, r, http.MethodGet, path, "", map[string]string{"If-None-Match": tag, "Accept-Encoding": "gzip"})
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != tag {
+			t.Fatalf("%s unchanged: %d body=%q", path, rec.Code, rec.Body.String())
+		}
+		if rec := serve(t, r, http.MethodGet, path, "", map[string]string{"If-None-Match": tag, "Accept": ndjson}); path != "/v1/manifest" && rec.Code != http.StatusOK {
+			t.Errorf("%s: NDJSON is a different representation: %d", path, rec.Code)
+		}
+	}
+
+	rec := serve(t, r, http.MethodGet, "/pull", "", nil)
+	tag := rec.Header().Get("ETag")
+	store.UpsertFile("b.md", "b", "hb")
+	rec = serve(t, r, http.MethodGet, "/pull", "", map[string]string{"If-None-Match": tag})
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == tag {
+		t.Fatalf("changed store: %d etag=%q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	gosync "sync"
+)
+
+// operation describes one route for the OpenAPI document. TestOpenAPI checks this table
+// against the routes NewRouter registers, so a route can't be added without documenting it.
+type operation struct {
+	Method  string
+	Path    string // OpenAPI template, e.g. /v1/files/{path}
+	ID      string
+	Summary string
+	Public  bool     // no authentication
+	Query   []string // "name: description"
+	Headers []string // "Name: description"
+	Request any      // JSON body type; nil for none
+	RawBody bool     // also accepts raw text
+	Binary  bool     // sends (PUT) or returns (GET) raw bytes instead of JSON
+	Stream  any      // NDJSON line type for the request, if it also accepts application/x-ndjson
+	Success []int
+	Body    any // success body type; nil for none
+	Events  any // NDJSON line type of the response when the client accepts application/x-ndjson
+	Errors  []int
+	Alias   bool // also served without the /v1 prefix
+}
+
+// contentHashDoc defines the content hash, sync.ContentHash, for clients.
+const contentHashDoc = "\"sha256:\" followed by the lowercase hex SHA-256 of the content's UTF-8 bytes, " +
+	"e.g. sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 for empty content. " +
+	"Hashes a client pushes are stored as given, but patch and chunk checks and server-side writes use this form."
+
+var pullParams = []string{
+	"limit: Files plus tombstones per page; all when unset.",
+	"cursor: The next value of the previous page.",
+	"since: Only changes after this revision, e.g. the revision of the last complete pull.",
+	"prefix: Only paths starting with this prefix.",
+	"glob: Only paths matching this glob; * and ? stay within a folder, ** spans folders.",
+}
+
+var operations = []operation{
+	{Method: http.MethodGet, Path: "/v1/health", ID: "health", Summary: "Liveness, mode and configured mirrors.",
+		Public: true, Success: []int{200}, Body: HealthResponse{}, Alias: true},
+	{Method: http.MethodGet, Path: "/v1/status", ID: "status", Summary: "File and tombstone counts and mirror sync state.",
+		Success: []int{200}, Body: StatusResponse{}, Alias: true},
+	{Method: http.MethodPost, Path: "/v1/push", ID: "push", Summary: "Apply moves, writes and deletes in one batch.",
+		Query:   []string{"conflictCopies: For NDJSON pushes, \"true\" keeps conflicting writes as conflict copies, like conflictCopies in a JSON push."},
+		Headers: []string{"Idempotency-Key: Replays the original response if this key was pushed recently."},
+		Request: PushRequest{}, Stream: PushOp{}, Success: []int{200}, Body: PushResponse{}, Errors: []int{400, 409, 422, 500}, Alias: true},
+	{Method: http.MethodPost, Path: "/v1/replay", ID: "replay", Summary: "Apply an offline change journal in order.",
+		Headers: []string{"Idempotency-Key: Replays the original response if this key was pushed recently."},
+		Request: ReplayRequest{}, Success: []int{200}, Body: PushResponse{}, Errors: []int{400, 409, 422, 500}},
+	{Method: http.MethodGet, Path: "/v1/pull", ID: "pull", Summary: "Files, moves and tombstones, optionally filtered and paginated.",
+		Query: pullParams, Success: []int{200, 304}, Body: PullResponse{}, Events: PullEvent{}, Errors: []int{400, 410}, Alias: true},
+	{Method: http.MethodPost, Path: "/v1/pull", ID: "pullDelta", Summary: "Pull, skipping files the client has and sending patches for ones it has an older version of.",
+		Query: pullParams, Request: PullRequest{}, Success: []int{200}, Body: PullResponse{}, Events: PullEvent{}, Errors: []int{400, 410}},
+	{Method: http.MethodGet, Path: "/v1/manifest", ID: "manifest", Summary: "Hash, size and revision of every file and tombstone, without content.",
+		Query: []string{"prefix: Only paths starting with this prefix."}, Success: []int{200, 304}, Body: ManifestResponse{}},
+	{Method: http.MethodPost, Path: "/v1/fetch", ID: "fetch", Summary: "Content of several files in one request.",
+		Request: FetchRequest{}, Success: []int{200}, Body: FetchResponse{}, Errors: []int{400}},
+	{Method: http.MethodGet, Path: "/v1/files", ID: "listFiles", Summary: "File metadata, sorted by path.",
+		Query: []string{"prefix: Only paths starting with this prefix."}, Success: []int{200}, Body: FileListResponse{}},
+	{Method: http.MethodGet, Path: "/v1/files/{path}", ID: "getFile", Summary: "One file; raw content with Accept: text/markdown.",
+		Query:   []string{"chunks: If set, return the hashes of the file's chunks instead of its content when it is stored as chunks (32 KiB and up, once saved)."},
+		Success: []int{200, 304}, Body: FileResponse{}, Errors: []int{400, 404}},
+	{Method: http.MethodHead, Path: "/v1/files/{path}", ID: "headFile", Summary: "Existence and ETag of one file.",
+		Success: []int{200, 304}, Errors: []int{400, 404}},
+	{Method: http.MethodPut, Path: "/v1/files/{path}", ID: "putFile", Summary: "Create or replace one file; honours If-Match and If-None-Match.",
+		Request: PutFileRequest{}, RawBody: true, Success: []int{200, 201}, Body: FileResponse{}, Errors: []int{400, 412, 423, 500}},
+	{Method: http.MethodDelete, Path: "/v1/files/{path}", ID: "deleteFile", Summary: "Delete a file or every file under a folder; honours If-Match.",
+		Success: []int{200}, Body: DeleteResponse{}, Errors: []int{400, 404, 412, 423, 500}},
+	{Method: http.MethodPost, Path: "/v1/chunks/missing", ID: "missingChunks", Summary: "Which of the listed chunks the server lacks.",
+		Request: ChunksRequest{}, Success: []int{200}, Body: ChunksResponse{}, Errors: []int{400}},
+	{Method: http.MethodGet, Path: "/v1/chunks/{hash}", ID: "getChunk", Summary: "One chunk's bytes.",
+		Binary: true, Success: []int{200}, Errors: []int{404}},
+	{Method: http.MethodPut, Path: "/v1/chunks/{hash}", ID: "putChunk", Summary: "Upload one chunk ahead of a push.",
+		Binary: true, Success: []int{204}, Errors: []int{400, 413, 500}},
+	{Method: http.MethodGet, Path: "/v1/devices", ID: "listDevices", Summary: "Devices that identified themselves, with last-seen time and acknowledged revision.",
+		Success: []int{200}, Body: DevicesResponse{}},
+	{Method: http.MethodDelete, Path: "/v1/devices/{id}", ID: "revokeDevice", Summary: "Refuse further requests sending this device ID. Advisory: clients choose their ID, so this is not access control.",
+		Success: []int{200}, Body: DeviceInfo{}, Errors: []int{404, 500}},
+	{Method: http.MethodGet, Path: "/v1/conflicts", ID: "listConflicts", Summary: "Unresolved conflict copies and the files they conflict with.",
+		Success: []int{200}, Body: ConflictsResponse{}},
+	{Method: http.MethodDelete, Path: "/v1/conflicts/{path}", ID: "resolveConflict", Summary: "Resolve a conflict by deleting its copy.",
+		Success: []int{200}, Body: DeleteResponse{}, Errors: []int{400, 404, 500}},
+	{Method: http.MethodGet, Path: "/v1/locks", ID: "listLocks", Summary: "Advisory locks currently held.",
+		Success: []int{200}, Body: LocksResponse{}},
+	{Method: http.MethodPut, Path: "/v1/locks/{path}", ID: "acquireLock", Summary: "Take or renew (heartbeat) the calling device's lock on a path.",
+		Query:   []string{"ttl: Seconds until the lock expires unless renewed, 1 to 3600; default 120."},
+		Success: []int{200}, Body: LockInfo{}, Errors: []int{400, 423}},
+	{Method: http.MethodDelete, Path: "/v1/locks/{path}", ID: "releaseLock", Summary: "Release the calling device's lock on a path.",
+		Success: []int{204}, Errors: []int{400, 404, 423}},
+	{Method: http.MethodGet, Path: "/v1/collab/{path}", ID: "collab", Summary: "Upgrade to a WebSocket for editing one document together; the body schema is that of each message.",
+		Success: []int{101}, Body: CollabMessage{}, Errors: []int{400, 426}},
+	{Method: http.MethodGet, Path: "/openapi.json", ID: "openapi", Summary: "This document.",
+		Public: true, Success: []int{200}, Body: map[string]any{}},
+}
+
+// OpenAPI serves the OpenAPI 3 document describing every route, type and error code.
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument())
+}
+
+var openAPIDocument = gosync.OnceValue(func() []byte {
+	data, err := json.Marshal(buildOpenAPI())
+	if err != nil {
+		panic(err)
+	}
+	return data
+})
+
+func buildOpenAPI() map[string]any {
+	s := schemas{defs: map[string]any{}, types: map[string]reflect.Type{}}
+	errRef := s.of(reflect.TypeFor[ErrorResponse]())
+	var codes []string
+	var table strings.Builder
+	for _, c := range errorCodes {
+		codes = append(codes, c.Code)
+		fmt.Fprintf(&table, "- `%s`: %s\n", c.Code, c.Description)
+	}
+	code := s.defs["ErrorResponse"].(map[string]any)["properties"].(map[string]any)["code"].(map[string]any)
+	code["enum"] = codes
+	code["description"] = "Stable error code:\n" + table.String()
+
+	paths := map[string]map[string]any{}
+	for _, op := range operations {
+		add := func(path, id string, deprecated bool) {
+			if paths[path] == nil {
+				paths[path] = map[string]any{}
+			}
+			o := s.operation(op, errRef)
+			o["operationId"] = id
+			if deprecated {
+				o["deprecated"] = true
+				o["description"] = "Unversioned alias of " + op.Path + "."
+			}
+			paths[path][strings.ToLower(op.Method)] = o
+		}
+		add(op.Path, op.ID, false)
+		if op.Alias {
+			add(strings.TrimPrefix(op.Path, "/v1"), op.ID+"Unversioned", true)
+		}
+	}
+	for _, def := range s.defs {
+		props, _ := def.(map[string]any)["properties"].(map[string]any)
+		for _, name := range []string{"hash", "baseHash", "winner"} {
+			if p, ok := props[name].(map[string]any); ok && p["type"] == "string" {
+				p["description"] = contentHashDoc
+			}
+		}
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Flux sync API",
+			"version":     "1",
+			"description": "Content hashes (`hash`, `baseHash`, file ETags): " + contentHashDoc,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": s.defs,
+			"securitySchemes": map[string]any{
+				"basicAuth": map[string]any{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+}
+
+// schemas collects component schemas for Go struct types, named after the type.
+type schemas struct {
+	defs  map[string]any
+	types map[string]reflect.Type
+}
+
+func (s schemas) operation(op operation, errRef map[string]any) map[string]any {
+	o := map[string]any{"summary": op.Summary}
+	var params []any
+	if strings.Contains(op.Path, "{path}") {
+		params = append(params, map[string]any{
+			"name": "path", "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			"description": "File or folder path, URL-escaped; may contain slashes.",
+		})
+	}
+	if strings.Contains(op.Path, "{hash}") {
+		params = append(params, map[string]any{
+			"name": "hash", "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			"description": "Chunk address: the hex sha256 of its bytes.",
+		})
+	}
+	if strings.Contains(op.Path, "{id}") {
+		params = append(params, map[string]any{
+			"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"},
+			"description": "Device ID, as sent in X-Device-ID.",
+		})
+	}
+	for _, q := range op.Query {
+		name, desc, _ := strings.Cut(q, ": ")
+		params = append(params, map[string]any{"name": name, "in": "query", "schema": map[string]any{"type": "string"}, "description": desc})
+	}
+	headers := op.Headers
+	if !op.Public {
+		headers = append(slices.Clip(headers), deviceHeaders...)
+	}
+	for _, hdr := range headers {
+		name, desc, _ := strings.Cut(hdr, ": ")
+		params = append(params, map[string]any{"name": name, "in": "header", "schema": map[string]any{"type": "string"}, "description": desc})
+	}
+	if params != nil {
+		o["parameters"] = params
+	}
+	if op.Request != nil {
+		content := map[string]any{"application/json": map[string]any{"schema": s.of(reflect.TypeOf(op.Request))}}
+		if op.RawBody {
+			content["text/plain"] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+		if op.Stream != nil {
+			content[ndjson] = map[string]any{"schema": s.of(reflect.TypeOf(op.Stream))}
+		}
+		o["requestBody"] = map[string]any{"required": true, "content": content}
+	}
+	binary := map[string]any{"application/octet-stream": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	if op.Binary && op.Method == http.MethodPut {
+		o["requestBody"] = map[string]any{"required": true, "content": binary}
+	}
+	responses := map[string]any{}
+	for _, st := range op.Success {
+		res := map[string]any{"description": http.StatusText(st)}
+		if op.Body != nil && st != http.StatusNotModified {
+			content := map[string]any{"application/json": map[string]any{"schema": s.of(reflect.TypeOf(op.Body))}}
+			if op.Events != nil {
+				content[ndjson] = map[string]any{"schema": s.of(reflect.TypeOf(op.Events))}
+			}
+			res["content"] = content
+		}
+		if op.Binary && op.Method == http.MethodGet {
+			res["content"] = binary
+		}
+		responses[strconv.Itoa(st)] = res
+	}
+	errs := op.Errors
+	if !op.Public {
+		errs = append([]int{http.StatusUnauthorized, http.StatusForbidden}, errs...)
+	}
+	if op.Request != nil {
+		errs = append(errs, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType)
+	}
+	for _, st := range errs {
+		responses[strconv.Itoa(st)] = map[string]any{
+			"description": http.StatusText(st),
+			"content":     map[string]any{"application/json": map[string]any{"schema": errRef}},
+		}
+	}
+	o["responses"] = responses
+	if op.Public {
+		o["security"] = []any{}
+	} else {
+		o["security"] = []any{map[string]any{"basicAuth": []string{}}}
+	}
+	return o
+}
+
+// of returns the schema for t, registering struct types as components.
+func (s schemas) of(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.of(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Slice:
+		// Empty Go slices and maps encode as null.
+		return map[string]any{"type": "array", "items": s.of(t.Elem()), "nullable": true}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.of(t.Elem()), "nullable": true}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Struct:
+		name := t.Name()
+		if prev, ok := s.types[name]; ok && prev != t {
+			panic("openapi: two types named " + name)
+		}
+		if _, ok := s.types[name]; !ok {
+			s.types[name] = t
+			props := map[string]any{}
+			var required []string
+			for i := range t.NumField() {
+				f := t.Field(i)
+				tag, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+				if !f.IsExported() || tag == "-" {
+					continue
+				}
+				if tag == "" {
+					tag = f.Name
+				}
+				props[tag] = s.of(f.Type)
+				if !strings.Contains(opts, "omitempty") {
+					required = append(required, tag)
+				}
+			}
+			def := map[string]any{"type": "object", "properties": props}
+			if required != nil {
+				def["required"] = required
+			}
+			s.defs[name] = def
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	panic("openapi: unsupported type " + t.String())
+}
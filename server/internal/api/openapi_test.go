@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func loadSpec(t *testing.T, r http.Handler) map[string]any {
+	t.Helper()
+	rec := serve(t, r, http.MethodGet, "/openapi.json", "", nil)
+	var doc map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("decode /openapi.json: %v", err)
+	}
+	return doc
+}
+
+func TestOpenAPI_routes(t *testing.T) {
+	r := NewRouter(NewHandler(sync.NewStore(), config.Default()))
+	doc := loadSpec(t, r)
+	var spec []string
+	for path, ops := range doc["paths"].(map[string]any) {
+		for method := range ops.(map[string]any) {
+			spec = append(spec, strings.ToUpper(method)+" "+path)
+		}
+	}
+	var routes []string
+	chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes = append(routes, method+" "+strings.Replace(route, "/*", "/{path}", 1))
+		return nil
+	})
+	slices.Sort(spec)
+	slices.Sort(routes)
+	if !slices.Equal(spec, routes) {
+		t.Fatalf("OpenAPI paths and router differ:\nspec:   %v\nrouter: %v", spec, routes)
+	}
+}
+
+func TestOpenAPI_errorCodes(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var consts []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		if vs, ok := n.(*ast.ValueSpec); ok && strings.HasPrefix(vs.Names[0].Name, "Code") {
+			v, _ := strconv.Unquote(vs.Values[0].(*ast.BasicLit).Value)
+			consts = append(consts, v)
+		}
+		return true
+	})
+	doc := buildOpenAPI()
+	enum := doc["components"].(map[string]any)["schemas"].(map[string]any)["ErrorResponse"].(map[string]any)["properties"].(map[string]any)["code"].(map[string]any)["enum"].([]string)
+	slices.Sort(consts)
+	enum = slices.Sorted(slices.Values(enum))
+	if !slices.Equal(consts, enum) {
+		t.Fatalf("error code constants and OpenAPI catalogue differ:\nconsts: %v\nspec:   %v", consts, enum)
+	}
+}
+
+func TestOpenAPI_contentHash(t *testing.T) {
+	if !strings.Contains(contentHashDoc, sync.ContentHash("")+" for empty content") {
+		t.Fatalf("documented example differs from sync.ContentHash: %s", contentHashDoc)
+	}
+	hash := buildOpenAPI()["components"].(map[string]any)["schemas"].(map[string]any)["PushFile"].(map[string]any)["properties"].(map[string]any)["hash"].(map[string]any)
+	if hash["description"] != contentHashDoc {
+		t.Fatalf("PushFile.hash: %v", hash)
+	}
+}
+
+// TestOpenAPI_responses calls every documented operation and checks the body against its schema.
+func TestOpenAPI_responses(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("note.md", "n", "hn")
+	store.UpsertFile("old.md", "o", "ho")
+	store.UpsertFile("Dir/a.md", "a", "ha")
+	store.DeleteFile("gone.md")
+	store.Blobs().Put([]byte("x"))
+	store.SeeDevice(sync.Device{ID: "laptop"})
+	var conflict sync.Conflict
+	store.Batch(func(tx *sync.Tx) error {
+		conflict = tx.KeepConflict("note.md", "mine", "hm")
+		return nil
+	})
+	r := NewRouter(NewHandler(store, config.Default(), &fakeMirror{}))
+	doc := loadSpec(t, r)
+
+	samples := map[string]struct{ path, body string }{
+		"health":          {"/v1/health", ""},
+		"status":          {"/v1/status", ""},
+		"push":            {"/v1/push", `{"moved":[{"from":"old.md","to":"new.md"}],"files":[{"path":"b.md","content":"b","hash":"hb"},{"path":"../x","content":"","hash":""}],"deleted":["gone2.md"]}`},
+		"replay":          {"/v1/replay", `{"ops":[{"op":"create","path":"j.md","content":"j","at":1},{"op":"rename","from":"j.md","path":"k.md","at":2},{"op":"bogus","path":"x.md"}]}`},
+		"pull":            {"/v1/pull", ""},
+		"pullDelta":       {"/v1/pull", `{"have":{"note.md":"hn"}}`},
+		"manifest":        {"/v1/manifest", ""},
+		"fetch":           {"/v1/fetch", `{"paths":["note.md","nope.md"]}`},
+		"listFiles":       {"/v1/files?prefix=Dir/", ""},
+		"getFile":         {"/v1/files/note.md", ""},
+		"headFile":        {"/v1/files/note.md", ""},
+		"putFile":         {"/v1/files/put.md", `{"content":"p"}`},
+		"deleteFile":      {"/v1/files/Dir", ""},
+		"missingChunks":   {"/v1/chunks/missing", `{"hashes":["` + sync.ChunkHash([]byte("x")) + `","nope"]}`},
+		"getChunk":        {"/v1/chunks/" + sync.ChunkHash([]byte("x")), ""},
+		"putChunk":        {"/v1/chunks/" + sync.ChunkHash([]byte("y")), "y"},
+		"listDevices":     {"/v1/devices", ""},
+		"revokeDevice":    {"/v1/devices/laptop", ""},
+		"listConflicts":   {"/v1/conflicts", ""},
+		"resolveConflict": {"/v1/conflicts/" + url.PathEscape(conflict.Copy), ""},
+		"listLocks":       {"/v1/locks", ""},
+		"acquireLock":     {"/v1/locks/note.md", ""},
+		"releaseLock":     {"/v1/locks/note.md", ""},
+		"collab":          {"/v1/collab/note.md", ""},
+		"openapi":         {"/openapi.json", ""},
+	}
+	for _, op := range operations {
+		sample, ok := samples[op.ID]
+		if !ok {
+			t.Errorf("no sample request for operation %s", op.ID)
+			continue
+		}
+		rec := serve(t, r, op.Method, sample.path, sample.body, map[string]string{"Content-Type": "application/json", headerDeviceID: "sampler"})
+		res, ok := doc["paths"].(map[string]any)[op.Path].(map[string]any)[strings.ToLower(op.Method)].(map[string]any)["responses"].(map[string]any)[strconv.Itoa(rec.Code)].(map[string]any)
+		if !ok {
+			t.Errorf("%s %s: status %d is not documented", op.Method, sample.path, rec.Code)
+			continue
+		}
+		content, _ := res["content"].(map[string]any)
+		if content["application/json"] == nil || op.Method == http.MethodHead {
+			continue
+		}
+		var body any
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Errorf("%s %s: %v", op.Method, sample.path, err)
+			continue
+		}
+		schema := content["application/json"].(map[string]any)["schema"].(map[string]any)
+		conform(t, doc, schema, body, op.ID)
+	}
+
+	rec := serve(t, r, http.MethodGet, "/v1/files/missing.md", "", nil)
+	var body any
+	json.NewDecoder(rec.Body).Decode(&body)
+	conform(t, doc, map[string]any{"$ref": "#/components/schemas/ErrorResponse"}, body, "error")
+}
+
+// conform reports where v doesn't match schema: undeclared or missing fields and wrong types.
+func conform(t *testing.T, doc, schema map[string]any, v any, at string) {
+	t.Helper()
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		schema = doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+	}
+	if v == nil {
+		if schema["nullable"] != true && schema["type"] != nil {
+			t.Errorf("%s: null for non-nullable %v", at, schema["type"])
+		}
+		return
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			t.Errorf("%s: want object, got %T", at, v)
+			return
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for k, fv := range obj {
+			if ap, ok := schema["additionalProperties"].(map[string]any); ok {
+				conform(t, doc, ap, fv, at+"."+k)
+				continue
+			}
+			if props == nil {
+				continue
+			}
+			ps, ok := props[k].(map[string]any)
+			if !ok {
+				t.Errorf("%s: undocumented field %q", at, k)
+				continue
+			}
+			conform(t, doc, ps, fv, at+"."+k)
+		}
+		required, _ := schema["required"].([]any)
+		for _, k := range required {
+			if _, ok := obj[k.(string)]; !ok {
+				t.Errorf("%s: missing required field %q", at, k)
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			t.Errorf("%s: want array, got %T", at, v)
+			return
+		}
+		for i, item := range arr {
+			conform(t, doc, schema["items"].(map[string]any), item, at+"["+strconv.Itoa(i)+"]")
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			t.Errorf("%s: want string, got %T", at, v)
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			t.Errorf("%s: want integer, got %v", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			t.Errorf("%s: want boolean, got %T", at, v)
+		}
+	}
+}
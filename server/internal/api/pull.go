@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// pullPage is the slice of the store one /pull request returns.
+type pullPage struct {
+	stateRev   int64 // revision the page was read at
+	rev        int64 // revision the pagination started at
+	acked      int64 // since, on an unfiltered pull: the client holds every change up to it
+	files      []*sync.File
+	tombstones []sync.Tombstone
+	moves      []sync.Move
+	locks      []sync.Lock
+	next       string
+}
+
+// cursor is the decoded continuation token: where the previous page stopped, and the store
+// horizon when paging began, so pages read across a garbage collection are refused.
+type cursor struct {
+	Rev     int64  `json:"r"`
+	After   string `json:"a"`
+	Horizon int64  `json:"h,omitempty"`
+}
+
+// errResync refuses a pull whose since or cursor predates the last garbage collection.
+var errResync = errors.New("resync required")
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.After == "" {
+		return cursor{}, errors.New("invalid cursor")
+	}
+	return c, nil
+}
+
+// pullQuery reads limit, cursor, since, prefix and glob and returns the page they select.
+// Files and tombstones are merged in path order, so pages are stable and a client can resume
+// an interrupted sync from its last cursor. Moves all come on the first page, ahead of the
+// tombstones they explain. With since, only changes made after that revision are included;
+// if tombstones after it have been pruned the result would be incomplete, and errResync is
+// returned instead.
+func (h *Handler) pullQuery(q url.Values) (pullPage, error) {
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return pullPage{}, errors.New("limit must be a positive integer")
+		}
+		limit = n
+	}
+	var since int64
+	if s := q.Get("since"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return pullPage{}, errors.New("since must be a revision")
+		}
+		since = n
+	}
+	match, err := pathFilter(q.Get("prefix"), q.Get("glob"))
+	if err != nil {
+		return pullPage{}, err
+	}
+	st := h.store.State()
+	c := cursor{Rev: st.Rev, Horizon: st.Horizon}
+	if s := q.Get("cursor"); s != "" {
+		if c, err = decodeCursor(s); err != nil {
+			return pullPage{}, err
+		}
+	}
+	if (since > 0 && since < st.Horizon) || c.Horizon != st.Horizon {
+		return pullPage{}, errResync
+	}
+
+	page := pullPage{stateRev: st.Rev, rev: c.Rev}
+	if q.Get("prefix") == "" && q.Get("glob") == "" {
+		page.acked = since
+	}
+	if c.After == "" {
+		for _, m := range st.Moves {
+			if m.Rev > since && (match(m.From) || match(m.To)) {
+				page.moves = append(page.moves, m)
+			}
+		}
+		for _, l := range h.store.Locks() {
+			if match(l.Path) {
+				page.locks = append(page.locks, l)
+			}
+		}
+	}
+	fi, ti, n := 0, 0, 0
+	for fi < len(st.Files) || ti < len(st.Tombstones) {
+		var p string
+		var rev int64
+		isFile := ti == len(st.Tombstones) || (fi < len(st.Files) && st.Files[fi].Path < st.Tombstones[ti].Path)
+		if isFile {
+			p, rev = st.Files[fi].Path, st.Files[fi].Rev
+		} else {
+			p, rev = st.Tombstones[ti].Path, st.Tombstones[ti].Rev
+		}
+		if p <= c.After || rev <= since || !match(p) {
+			if isFile {
+				fi++
+			} else {
+				ti++
+			}
+			continue
+		}
+		if limit > 0 && n == limit {
+			page.next = cursor{Rev: c.Rev, After: lastPath(page), Horizon: c.Horizon}.encode()
+			break
+		}
+		if isFile {
+			page.files = append(page.files, st.Files[fi])
+			fi++
+		} else {
+			page.tombstones = append(page.tombstones, st.Tombstones[ti])
+			ti++
+		}
+		n++
+	}
+	return page, nil
+}
+
+// lastPath is the greatest path on the page.
+func lastPath(p pullPage) string {
+	var last string
+	if len(p.files) > 0 {
+		last = p.files[len(p.files)-1].Path
+	}
+	if len(p.tombstones) > 0 {
+		last = max(last, p.tombstones[len(p.tombstones)-1].Path)
+	}
+	return last
+}
+
+// pathFilter matches paths starting with prefix and, when glob is set, matching it.
+func pathFilter(prefix, glob string) (func(string) bool, error) {
+	if glob == "" {
+		return func(p string) bool { return strings.HasPrefix(p, prefix) }, nil
+	}
+	re, err := globRegexp(glob)
+	if err != nil {
+		return nil, err
+	}
+	return func(p string) bool { return strings.HasPrefix(p, prefix) && re.MatchString(p) }, nil
+}
+
+// globRegexp compiles a glob where * and ? stay within one path segment, ** spans
+// segments ("**/" also matches no folder at all) and [...] is a character class.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				b.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return nil, errors.New("glob has an unclosed [")
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, errors.New("invalid glob")
+	}
+	return re, nil
+}
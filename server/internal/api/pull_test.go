@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestPull_pages(t *testing.T) {
+	store := sync.NewStore()
+	for _, p := range []string{"e.md", "c.md", "a.md", "Notes/x.md", "Notes/sub/y.txt"} {
+		store.UpsertFile(p, p, "h")
+	}
+	store.DeleteFile("b.md")
+	store.DeleteFile("d.md")
+	store.Move("e.md", "f.md")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	pull := func(query string) PullResponse {
+		t.Helper()
+		rec := serve(t, r, http.MethodGet, "/v1/pull"+query, "", nil)
+		var res PullResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("pull %s: %d %v", query, rec.Code, err)
+		}
+		return res
+	}
+
+	var seen []string
+	var pages int
+	res := pull("?limit=3")
+	rev := res.Revision
+	if len(res.Moved) != 1 {
+		t.Fatalf("moves belong on the first page: %+v", res.Moved)
+	}
+	for {
+		pages++
+		for _, f := range res.Files {
+			seen = append(seen, f.Path)
+		}
+		for _, d := range res.Deleted {
+			seen = append(seen, "-"+d)
+		}
+		if res.Next == "" {
+			break
+		}
+		if pages == 1 {
+			// Changes between pages don't disturb the order of what's left.
+			store.UpsertFile("0-first.md", "x", "h")
+		}
+		res = pull("?limit=3&cursor=" + res.Next)
+		if len(res.Moved) != 0 || res.Revision != rev {
+			t.Fatalf("later pages: moved=%v revision=%d", res.Moved, res.Revision)
+		}
+	}
+	slices.Sort(seen)
+	want := []string{"-b.md", "-d.md", "-e.md", "Notes/sub/y.txt", "Notes/x.md", "a.md", "c.md", "f.md"}
+	if pages != 3 || !slices.Equal(seen, want) {
+		t.Fatalf("pages=%d seen=%v", pages, seen)
+	}
+
+	if res := pull("?prefix=Notes/&glob=**/*.md"); len(res.Files) != 1 || res.Files[0].Path != "Notes/x.md" {
+		t.Errorf("prefix+glob: %+v", res.Files)
+	}
+	if res := pull("?glob=*.md"); len(res.Files) != 4 || len(res.Deleted) != 3 || len(res.Moved) != 1 {
+		t.Errorf("glob stays in the top folder: %+v", res)
+	}
+
+	for _, q := range []string{"?limit=0", "?limit=x", "?cursor=!!!", "?cursor=e30", "?glob=[abc"} {
+		if rec := serve(t, r, http.MethodGet, "/v1/pull"+q, "", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("pull %s: %d", q, rec.Code)
+		}
+	}
+
+	rec := serve(t, r, http.MethodGet, "/v1/pull?limit=2", "", map[string]string{"Accept": ndjson})
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	var end PullEvent
+	json.Unmarshal([]byte(lines[len(lines)-1]), &end)
+	if len(lines) != 4 || end.Type != "end" || end.Next == "" {
+		t.Errorf("ndjson page: %v", lines)
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	for _, tt := range []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.md", "a.md", true},
+		{"*.md", "Notes/a.md", false},
+		{"**/*.md", "a.md", true},
+		{"**/*.md", "Notes/sub/a.md", true},
+		{"Notes/**", "Notes/sub/a.png", true},
+		{"Notes/?.md", "Notes/a.md", true},
+		{"Notes/?.md", "Notes/ab.md", false},
+		{"[ab].md", "b.md", true},
+		{"[!ab].md", "b.md", false},
+		{"a+b.md", "a+b.md", true},
+	} {
+		re, err := globRegexp(tt.glob)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.glob, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("glob %q on %q = %v, want %v", tt.glob, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPull_have(t *testing.T) {
+	base := strings.Repeat("line\n", 100)
+	store := sync.NewStore()
+	store.UpsertFile("big.md", base, "v1")
+	store.UpsertFile("big.md", base+"more\n", "v2")
+	store.UpsertFile("same.md", "s", "hs")
+	store.UpsertFile("other.md", "o", "ho")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	have := `{"have":{"big.md":"v1","same.md":"hs","other.md":"unknown"}}`
+	rec := serve(t, r, http.MethodPost, "/v1/pull", have, nil)
+	var res PullResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("pull: %d %v", rec.Code, err)
+	}
+	if len(res.Files) != 2 || res.Files[0].Path != "big.md" || res.Files[1].Path != "other.md" {
+		t.Fatalf("files the client has should be left out: %+v", res.Files)
+	}
+	big := res.Files[0]
+	if big.Content != "" || big.BaseHash != "v1" || big.Hash != "v2" {
+		t.Fatalf("big.md should come as a patch: %+v", big)
+	}
+	if got, err := sync.Patch(base, big.Patch); err != nil || got != base+"more\n" {
+		t.Fatalf("patch should rebuild the file: %v", err)
+	}
+	if res.Files[1].Content != "o" || res.Files[1].Patch != nil {
+		t.Fatalf("an unknown base gets the full content: %+v", res.Files[1])
+	}
+
+	rec = serve(t, r, http.MethodPost, "/v1/pull", have, map[string]string{"Accept": ndjson})
+	var patched bool
+	for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+		var ev PullEvent
+		json.Unmarshal([]byte(line), &ev)
+		if ev.Path == "same.md" {
+			t.Fatal("streamed pull should also skip files the client has")
+		}
+		patched = patched || (ev.Path == "big.md" && ev.Patch != nil)
+	}
+	if !patched {
+		t.Fatalf("streamed pull should patch big.md: %s", rec.Body.String())
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/pull", `{`, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad body: %d", rec.Code)
+	}
+}
+
+func TestPull_since(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("a.md", "a", "ha")
+	store.UpsertFile("b.md", "b", "hb")
+	store.DeleteFile("old.md")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	pull := func(query string) (PullResponse, *ErrorResponse) {
+		t.Helper()
+		rec := serve(t, r, http.MethodGet, "/v1/pull"+query, "", nil)
+		if rec.Code != http.StatusOK {
+			var e ErrorResponse
+			json.NewDecoder(rec.Body).Decode(&e)
+			return PullResponse{}, &e
+		}
+		var res PullResponse
+		json.NewDecoder(rec.Body).Decode(&res)
+		return res, nil
+	}
+	first, _ := pull("")
+	store.UpsertFile("b.md", "b2", "hb2")
+	store.DeleteFile("a.md")
+	store.UpsertFile("c.md", "c", "hc")
+	store.Move("c.md", "d.md")
+
+	res, _ := pull("?since=" + strconv.FormatInt(first.Revision, 10))
+	var files []string
+	for _, f := range res.Files {
+		files = append(files, f.Path)
+	}
+	if !slices.Equal(files, []string{"b.md", "d.md"}) || !slices.Equal(res.Deleted, []string{"a.md", "c.md"}) || len(res.Moved) != 1 {
+		t.Fatalf("since should return only later changes: files=%v deleted=%v moved=%v", files, res.Deleted, res.Moved)
+	}
+
+	page, _ := pull("?limit=1")
+	store.PruneTombstones(time.Now().UnixMilli() + 1)
+	for _, q := range []string{"?since=" + strconv.FormatInt(first.Revision, 10), "?limit=1&cursor=" + page.Next} {
+		if _, e := pull(q); e == nil || e.Code != CodeResyncRequired {
+			t.Errorf("%s after pruning: %+v", q, e)
+		}
+	}
+	res, e := pull("?since=" + strconv.FormatInt(store.Horizon(), 10))
+	if e != nil || len(res.Deleted) != 0 {
+		t.Fatalf("a since at the horizon is still complete: %+v %+v", e, res)
+	}
+	if _, e := pull("?since=-1"); e == nil || e.Code != CodeInvalidQuery {
+		t.Fatalf("negative since: %+v", e)
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// Replay applies the journal an offline device recorded; see ReplayRequest. Like a push it
+// takes an Idempotency-Key, so a replay cut off mid-response can be retried safely.
+func (h *Handler) Replay(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config()
+	var req ReplayRequest
+	if !decodeJSON(w, r, cfg, &req) {
+		return
+	}
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = req.IdempotencyKey
+	}
+	req.IdempotencyKey = ""
+	h.idempotent(w, r, key, fingerprint(req), func(w http.ResponseWriter) { h.replay(w, r, cfg, req) })
+}
+
+func (h *Handler) replay(w http.ResponseWriter, r *http.Request, cfg *config.Config, req ReplayRequest) {
+	var res PushResponse
+	var changed []string
+	err := h.store.Batch(func(tx *sync.Tx) error {
+		tx.As(deviceID(r.Context()))
+		res, changed = applyJournal(tx, req.Ops, cfg.Limits.MaxPathLength, req.ConflictCopies)
+		if req.Strict && res.failed() {
+			return errRejected
+		}
+		return nil
+	})
+	if errors.Is(err, errRejected) {
+		respondError(w, r, http.StatusUnprocessableEntity, CodePushRejected, "replay rejected; nothing was applied", map[string]any{"results": res.Results})
+		return
+	}
+	if !h.commit(w, r, changed) {
+		return
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// applyJournal applies ops in order within tx. Paths the journal itself has already changed
+// are exempt from the At check, so a file created and then edited offline replays cleanly.
+// With copies, conflicting creates and full-content modifies become conflict copies.
+func applyJournal(tx *sync.Tx, ops []JournalOp, maxLen int, copies bool) (PushResponse, []string) {
+	res := PushResponse{Status: "ok", Results: make([]PushResult, 0, len(ops))}
+	var changed []string
+	ours := make(map[string]bool)
+	// stale reports the server copy of op.Path if it changed since the client recorded op.
+	stale := func(op JournalOp) (*sync.File, bool) {
+		cur, ok := tx.Get(op.Path)
+		if !ok || !safePath(op.Path, maxLen) {
+			return nil, false
+		}
+		if op.Vector != nil {
+			return cur, !op.Vector.Descends(cur.Vector)
+		}
+		if op.BaseHash != "" {
+			return cur, cur.Hash != op.BaseHash
+		}
+		return cur, op.At > 0 && !ours[op.Path] && cur.UpdatedAt > op.At
+	}
+	for _, op := range ops {
+		var r PushResult
+		var c []string
+		switch op.Op {
+		case "create":
+			if cur, ok := tx.Get(op.Path); ok && safePath(op.Path, maxLen) && cur.Content != op.Content {
+				r = PushResult{Path: op.Path, Status: ResultConflict, Reason: "exists on server", Hash: cur.Hash}
+				if copies {
+					c = keepCopy(tx, PushFile{Path: op.Path, Content: op.Content, Hash: op.Hash}, &r)
+				}
+				break
+			}
+			r, c = applyWrite(tx, PushFile{Path: op.Path, Content: op.Content, Hash: op.Hash, Vector: op.Vector}, maxLen, copies)
+		case "modify":
+			// With a vector, applyWrite tells a stale edit from a concurrent one.
+			if cur, ok := stale(op); ok && op.Vector == nil && (op.Patch != nil || cur.Content != op.Content) {
+				r = PushResult{Path: op.Path, Status: ResultConflict, Reason: "changed on server", Hash: cur.Hash}
+				if copies && op.Patch == nil {
+					c = keepCopy(tx, PushFile{Path: op.Path, Content: op.Content, Hash: op.Hash}, &r)
+				}
+				break
+			}
+			r, c = applyWrite(tx, PushFile{Path: op.Path, Content: op.Content, Hash: op.Hash, BaseHash: op.BaseHash, Patch: op.Patch, Vector: op.Vector}, maxLen, copies)
+		case "rename":
+			r, c = res.move(tx, Move{From: op.From, To: op.Path}, maxLen)
+			if r.Reason == "source not found" && tx.Deleted(op.From) {
+				r.Status, r.Reason = ResultConflict, "deleted on server"
+			}
+		case "delete":
+			if cur, ok := stale(op); ok {
+				r = PushResult{Path: op.Path, Status: ResultConflict, Reason: "changed on server", Hash: cur.Hash, Vector: cur.Vector}
+				break
+			}
+			r, c = res.delete(tx, op.Path, maxLen)
+		default:
+			r = PushResult{Path: op.Path, Status: ResultRejected, Reason: "unknown op"}
+		}
+		r.Op = op.Op
+		res.Results = append(res.Results, r)
+		for _, p := range c {
+			ours[p] = true
+		}
+		changed = append(changed, c...)
+	}
+	return res, changed
+}
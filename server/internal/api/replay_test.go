@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestReplay(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("old.md", "old", "ho")
+	fake := &fakeMirror{}
+	r := NewRouter(NewHandler(store, config.Default(), fake))
+
+	// Created, edited and renamed offline: later ops see the earlier ones despite old client times.
+	journal := `{"ops":[
+		{"op":"create","path":"a.md","content":"one","at":1},
+		{"op":"modify","path":"a.md","content":"two","at":2},
+		{"op":"rename","from":"a.md","path":"b.md","at":3},
+		{"op":"delete","path":"old.md","baseHash":"ho","at":4}
+	]}`
+	rec := serve(t, r, http.MethodPost, "/v1/replay", journal, nil)
+	var res PushResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec.Code != http.StatusOK || len(res.Results) != 4 {
+		t.Fatalf("replay: %d %+v", rec.Code, res)
+	}
+	for i, op := range []string{"create", "modify", "rename", "delete"} {
+		if res.Results[i].Op != op || res.Results[i].Status != ResultAccepted {
+			t.Errorf("result %d: %+v", i, res.Results[i])
+		}
+	}
+	if f, ok := store.Get("b.md"); !ok || f.Content != "two" {
+		t.Fatalf("b.md should hold the edited content: %+v", f)
+	}
+	if _, ok := store.Get("old.md"); ok || fake.calls != 1 {
+		t.Fatalf("old.md should be deleted and mirrored once; calls=%d", fake.calls)
+	}
+}
+
+func TestReplay_conflicts(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("edited.md", "server", "hs")
+	store.UpsertFile("taken.md", "server", "ht")
+	store.UpsertFile("moved.md", "m", "hm")
+	store.DeleteFile("moved.md")
+	r := NewRouter(NewHandler(store, config.Default(), &fakeMirror{}))
+
+	journal := `{"ops":[
+		{"op":"modify","path":"edited.md","content":"offline","at":1},
+		{"op":"delete","path":"edited.md","baseHash":"stale"},
+		{"op":"create","path":"taken.md","content":"mine"},
+		{"op":"rename","from":"moved.md","path":"elsewhere.md"},
+		{"op":"touch","path":"x.md"},
+		{"op":"modify","path":"edited.md","content":"server","hash":"hs","at":1}
+	]}`
+	rec := serve(t, r, http.MethodPost, "/v1/replay", journal, nil)
+	var res PushResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	want := []struct{ status, reason string }{
+		{ResultConflict, "changed on server"},
+		{ResultConflict, "changed on server"},
+		{ResultConflict, "exists on server"},
+		{ResultConflict, "deleted on server"},
+		{ResultRejected, "unknown op"},
+		{ResultUnchanged, ""},
+	}
+	if rec.Code != http.StatusOK || len(res.Results) != len(want) {
+		t.Fatalf("replay: %d %+v", rec.Code, res)
+	}
+	for i, w := range want {
+		if got := res.Results[i]; got.Status != w.status || got.Reason != w.reason {
+			t.Errorf("result %d: got %+v, want %s %q", i, got, w.status, w.reason)
+		}
+	}
+	if f, _ := store.Get("edited.md"); f.Content != "server" {
+		t.Fatalf("conflicting ops must not apply: %+v", f)
+	}
+
+	rec = serve(t, r, http.MethodPost, "/v1/replay", `{"strict":true,"ops":[{"op":"create","path":"new.md","content":"n"},{"op":"create","path":"taken.md","content":"mine"}]}`, nil)
+	var e ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&e)
+	if rec.Code != http.StatusUnprocessableEntity || e.Code != CodePushRejected {
+		t.Fatalf("strict replay: %d %+v", rec.Code, e)
+	}
+	if _, ok := store.Get("new.md"); ok {
+		t.Fatal("a rejected strict replay must apply nothing")
+	}
+	if rec := serve(t, r, http.MethodPost, "/v1/replay", `{"ops":`, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad json: %d", rec.Code)
+	}
+}
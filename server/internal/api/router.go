@@ -1,16 +1,35 @@
 package api
 
 import (
+	"crypto/subtle"
+	"log"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"slices"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/shaun/flux/server/internal/config"
 )
 
-func cors(next http.Handler) http.Handler {
+// cors allows the currently configured origins; "*" allows any. Other origins get no CORS headers.
+func (h *Handler) cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		origins := h.Config().CORS.AllowedOrigins
+		allow := "*"
+		if !slices.Contains(origins, "*") {
+			allow = ""
+			w.Header().Add("Vary", "Origin")
+			if o := r.Header.Get("Origin"); o != "" && slices.Contains(origins, o) {
+				allow = o
+			}
+		}
+		if allow != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allow)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, Authorization, X-Request-ID, If-Match, If-None-Match, Idempotency-Key, X-Device-ID, X-Device-Name, X-Device-Platform, X-Client-Version")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag, Idempotent-Replayed")
+		}
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -19,13 +38,123 @@ func cors(next http.Handler) http.Handler {
 	})
 }
 
+// recoverer turns a handler panic into a CodeInternal error instead of a dropped connection.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				log.Printf("[Flux] Panic (request %s): %v\n%s", requestID(r.Context()), v, debug.Stack())
+				respondError(w, r, http.StatusInternalServerError, CodeInternal, "internal error", nil)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// basicAuth requires credentials matching one of the currently configured users, or a client
+// certificate verified against tls.client_ca. With neither configured every request passes.
+func (h *Handler) basicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.Config()
+		users := cfg.Auth.Users
+		if len(users) == 0 && cfg.TLS.ClientCA == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The TLS config (certs.Reloader) fails the handshake for a client certificate that
+		// doesn't verify, so any certificate that got this far is trusted.
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, pass, ok := r.BasicAuth()
+		if ok && validUser(users, name, pass) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="flux"`)
+		respondError(w, r, http.StatusUnauthorized, CodeUnauthorized, "unauthorized", nil)
+	})
+}
+
+// validUser checks every user in constant time so timing doesn't reveal which names exist.
+func validUser(users []config.User, name, pass string) bool {
+	found := false
+	for _, u := range users {
+		nameOK := subtle.ConstantTimeCompare([]byte(u.Name), []byte(name)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(u.Password), []byte(pass)) == 1
+		if nameOK && passOK {
+			found = true
+		}
+	}
+	return found
+}
+
+// RedirectToHTTPS answers plain-HTTP requests with a permanent redirect to the same URL on
+// the TLS listener at tlsAddr (e.g. ":8443"; port 443 is left implicit).
+func RedirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
 func NewRouter(h *Handler) chi.Router {
 	r := chi.NewRouter()
-	r.Use(cors)
-	r.Get("/health", h.Health)
-	r.Group(func(r chi.Router) {
-		r.Post("/push", h.Push)
-		r.Get("/pull", h.Pull)
+	r.Use(withRequestID, recoverer, h.cors, decompress, compression)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		respondError(w, r, http.StatusNotFound, CodeNotFound, "not found", nil)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		respondError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed", nil)
+	})
+	r.Get("/openapi.json", h.OpenAPI)
+	routes := func(r chi.Router) {
+		r.Get("/health", h.Health)
+		r.Group(func(r chi.Router) {
+			r.Use(h.basicAuth, h.device)
+			r.Get("/status", h.Status)
+			r.Post("/push", h.Push)
+			r.Get("/pull", h.Pull)
+		})
+	}
+	r.Route("/v1", func(r chi.Router) {
+		routes(r)
+		r.Group(func(r chi.Router) {
+			r.Use(h.basicAuth, h.device)
+			r.Get("/manifest", h.Manifest)
+			r.Post("/fetch", h.Fetch)
+			r.Post("/replay", h.Replay)
+			r.Post("/pull", h.Pull)
+			r.Post("/chunks/missing", h.MissingChunks)
+			r.Get("/chunks/{hash}", h.GetChunk)
+			r.Put("/chunks/{hash}", h.PutChunk)
+			r.Get("/devices", h.ListDevices)
+			r.Delete("/devices/{id}", h.RevokeDevice)
+			r.Get("/conflicts", h.ListConflicts)
+			r.Delete("/conflicts/*", h.ResolveConflict)
+			r.Get("/locks", h.ListLocks)
+			r.Put("/locks/*", h.AcquireLock)
+			r.Delete("/locks/*", h.ReleaseLock)
+			r.Get("/collab/*", h.Collab)
+			r.Get("/files", h.ListFiles)
+			r.Get("/files/*", h.GetFile)
+			r.Head("/files/*", h.GetFile)
+			r.Put("/files/*", h.PutFile)
+			r.Delete("/files/*", h.DeleteFile)
+		})
 	})
+	// Unversioned paths predate /v1 and stay as aliases for existing clients.
+	routes(r)
 	return r
 }
@@ -1,21 +1,26 @@
 package api
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/shaun/flux/server/internal/config"
 	"github.com/shaun/flux/server/internal/sync"
 )
 
 func TestRouter_healthAndPull(t *testing.T) {
 	store := sync.NewStore()
-	h := NewHandler(store)
+	h := NewHandler(store, config.Default())
 	router := NewRouter(h)
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
 	rec := httptest.NewRecorder()
 	router.ServeHTTP(rec, req)
-	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"ok"`) {
 		t.Errorf("GET /health: %d %s", rec.Code, rec.Body.String())
 	}
 
@@ -32,7 +37,7 @@ func TestRouter_healthAndPull(t *testing.T) {
 
 func TestRouter_options(t *testing.T) {
 	store := sync.NewStore()
-	h := NewHandler(store)
+	h := NewHandler(store, config.Default())
 	router := NewRouter(h)
 	req := httptest.NewRequest(http.MethodOptions, "/pull", nil)
 	rec := httptest.NewRecorder()
@@ -41,3 +46,129 @@ func TestRouter_options(t *testing.T) {
 		t.Errorf("OPTIONS: %d", rec.Code)
 	}
 }
+
+func TestRouter_basicAuth(t *testing.T) {
+	cfg := config.Default()
+	cfg.Auth.Users = []config.User{{Name: "u", Password: "p"}}
+	router := NewRouter(NewHandler(sync.NewStore(), cfg))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("health should not require auth: %d", rec.Code)
+	}
+
+	for _, tt := range []struct {
+		name, user, pass string
+		want             int
+	}{
+		{"none", "", "", http.StatusUnauthorized},
+		{"wrong password", "u", "x", http.StatusUnauthorized},
+		{"unknown user", "x", "p", http.StatusUnauthorized},
+		{"valid", "u", "p", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/pull", nil)
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: code %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestRouter_corsAllowedOrigins(t *testing.T) {
+	cfg := config.Default()
+	cfg.CORS.AllowedOrigins = []string{"app://obsidian.md"}
+	router := NewRouter(NewHandler(sync.NewStore(), cfg))
+
+	req := httptest.NewRequest(http.MethodGet, "/pull", nil)
+	req.Header.Set("Origin", "app://obsidian.md")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "app://obsidian.md" {
+		t.Errorf("allowed origin: header %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/pull", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin: header %q", got)
+	}
+}
+
+func TestRouter_clientCertAuth(t *testing.T) {
+	cfg := config.Default()
+	cfg.TLS = config.TLS{Cert: "c", Key: "k", ClientCA: "ca", ClientAuth: "optional"}
+	router := NewRouter(NewHandler(sync.NewStore(), cfg))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pull", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("no cert and no users should be rejected: %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/pull", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("verified client cert should pass: %d", rec.Code)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, tt := range []struct {
+		tlsAddr, host, want string
+	}{
+		{":8443", "flux.example:8080", "https://flux.example:8443/pull?x=1"},
+		{":443", "flux.example", "https://flux.example/pull?x=1"},
+		{"0.0.0.0:443", "flux.example:80", "https://flux.example/pull?x=1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/pull?x=1", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		RedirectToHTTPS(tt.tlsAddr).ServeHTTP(rec, req)
+		if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s via %s: %d %q, want %q", tt.host, tt.tlsAddr, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestRouter_errors(t *testing.T) {
+	h := NewHandler(sync.NewStore(), config.Default())
+	r := NewRouter(h)
+	r.Get("/boom", func(http.ResponseWriter, *http.Request) { panic("boom") })
+	for _, tt := range []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodGet, "/nope", http.StatusNotFound, CodeNotFound},
+		{http.MethodDelete, "/push", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{http.MethodPost, "/push", http.StatusBadRequest, CodeInvalidJSON},
+		{http.MethodGet, "/boom", http.StatusInternalServerError, CodeInternal},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader("{"))
+		req.Header.Set("X-Request-ID", "req-1")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		var e ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+			t.Fatalf("%s %s: decode %v", tt.method, tt.path, err)
+		}
+		if rec.Code != tt.status || e.Code != tt.code || e.RequestID != "req-1" || rec.Header().Get("X-Request-ID") != "req-1" {
+			t.Errorf("%s %s: %d %+v", tt.method, tt.path, rec.Code, e)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if len(rec.Header().Get("X-Request-ID")) != 16 {
+		t.Errorf("request without an ID should get one: %q", rec.Header().Get("X-Request-ID"))
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+const ndjson = "application/x-ndjson"
+
+// isNDJSON reports whether a Content-Type or Accept header asks for newline-delimited JSON.
+func isNDJSON(header string) bool {
+	return strings.Contains(header, ndjson)
+}
+
+// pullStream writes a pull page one line at a time, so the response never holds the whole
+// vault in memory and clients can apply files as they arrive.
+func (h *Handler) pullStream(w http.ResponseWriter, page pullPage, have map[string]string) {
+	w.Header().Set("Content-Type", ndjson)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	for _, m := range page.moves {
+		if enc.Encode(PullEvent{Type: "move", From: m.From, To: m.To}) != nil {
+			return
+		}
+	}
+	for _, t := range page.tombstones {
+		if enc.Encode(PullEvent{Type: "deleted", Path: t.Path}) != nil {
+			return
+		}
+	}
+	for _, l := range page.locks {
+		if enc.Encode(PullEvent{Type: "lock", Path: l.Path, Device: l.Device, ExpiresAt: l.ExpiresAt}) != nil {
+			return
+		}
+	}
+	for _, f := range page.files {
+		pf, ok := h.pullFile(f, have[f.Path])
+		if !ok {
+			continue
+		}
+		if enc.Encode(PullEvent{Type: "file", Path: pf.Path, Content: pf.Content, Hash: pf.Hash, BaseHash: pf.BaseHash, Patch: pf.Patch, Vector: pf.Vector}) != nil {
+			return
+		}
+	}
+	enc.Encode(PullEvent{Type: "end", Revision: page.rev, Next: page.next})
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine returns the next non-empty line of at most max bytes, or io.EOF.
+func readLine(br *bufio.Reader, max int64) ([]byte, error) {
+	for {
+		var line []byte
+		for {
+			chunk, err := br.ReadSlice('\n')
+			if int64(len(line)+len(chunk)) > max {
+				return nil, errLineTooLong
+			}
+			line = append(line, chunk...)
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil && (err != io.EOF || len(line) == 0) {
+				return nil, err
+			}
+			break
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+// pushStream applies an NDJSON push line by line. Only each line is held in memory and
+// limited to limits.max_push_bytes, so initial syncs of any size fit in one request; all of
+// it still reaches the mirrors as one commit. A bad line stops the stream: the lines before
+// it are kept and the error's details say which line failed, so the client can resume there.
+// The conflictCopies query parameter stands in for PushRequest.ConflictCopies.
+func (h *Handler) pushStream(w http.ResponseWriter, r *http.Request) {
+	cfg := h.Config()
+	copies := r.URL.Query().Get("conflictCopies") == "true"
+	br := bufio.NewReader(r.Body)
+	res := PushResponse{Status: "ok", Results: []PushResult{}}
+	var changed []string
+	for n := 1; ; n++ {
+		line, err := readLine(br, cfg.Limits.MaxPushBytes)
+		if err == io.EOF {
+			break
+		}
+		var op PushOp
+		if err == nil {
+			err = json.Unmarshal(line, &op)
+		}
+		req, ok := op.request()
+		if err != nil || !ok {
+			status, code, msg := http.StatusBadRequest, CodeInvalidJSON, "invalid push line"
+			if errors.Is(err, errLineTooLong) {
+				status, code, msg = http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "push line too large"
+			}
+			if !h.commit(w, r, changed) {
+				return
+			}
+			if n > 1 {
+				markApplied(w)
+			}
+			respondError(w, r, status, code, msg, map[string]any{"line": n, "results": res.Results})
+			return
+		}
+		req.ConflictCopies = copies
+		h.store.Batch(func(tx *sync.Tx) error {
+			tx.As(deviceID(r.Context()))
+			one, c := applyPush(tx, req, cfg.Limits.MaxPathLength)
+			res.Results = append(res.Results, one.Results...)
+			res.Moved = append(res.Moved, one.Moved...)
+			res.Deleted = append(res.Deleted, one.Deleted...)
+			changed = append(changed, c...)
+			return nil
+		})
+	}
+	if !h.commit(w, r, changed) {
+		return
+	}
+	respondJSON(w, http.StatusOK, res)
+}
+
+// request converts the line to a one-entry PushRequest.
+func (op PushOp) request() (PushRequest, bool) {
+	switch op.Op {
+	case "move":
+		return PushRequest{Moved: []Move{{From: op.From, To: op.To}}}, true
+	case "write":
+		return PushRequest{Files: []PushFile{{Path: op.Path, Content: op.Content, Hash: op.Hash, BaseHash: op.BaseHash, Patch: op.Patch, Chunks: op.Chunks, Vector: op.Vector}}}, true
+	case "delete":
+		return PushRequest{Deleted: []string{op.Path}}, true
+	}
+	return PushRequest{}, false
+}
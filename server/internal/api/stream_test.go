@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/shaun/flux/server/internal/config"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+func TestPull_ndjson(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("b.md", "b", "hb")
+	store.UpsertFile("old.md", "o", "ho")
+	store.Move("old.md", "a.md")
+	store.DeleteFile("gone.md")
+	r := NewRouter(NewHandler(store, config.Default()))
+
+	rec := serve(t, r, http.MethodGet, "/v1/pull", "", map[string]string{"Accept": ndjson})
+	if rec.Header().Get("Content-Type") != ndjson {
+		t.Fatalf("content type: %q", rec.Header().Get("Content-Type"))
+	}
+	var got []string
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		var ev PullEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev.Type+":"+ev.Path+ev.From)
+		if ev.Type == "end" && ev.Revision != 4 {
+			t.Errorf("end revision: %d", ev.Revision)
+		}
+	}
+	want := "move:old.md deleted:gone.md deleted:old.md file:a.md file:b.md end:"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("stream:\n got  %v\n want %s", got, want)
+	}
+}
+
+func TestPush_ndjson(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits.MaxPushBytes = 200
+	store := sync.NewStore()
+	store.UpsertFile("old.md", "o", "ho")
+	fake := &fakeMirror{}
+	r := NewRouter(NewHandler(store, cfg, fake))
+
+	// Far more than MaxPushBytes in total, but every line fits.
+	var body strings.Builder
+	body.WriteString(`{"op":"move","from":"old.md","to":"new.md"}` + "\n\n")
+	for i := range 20 {
+		body.WriteString(`{"op":"write","path":"n` + string(rune('a'+i)) + `.md","content":"` + strings.Repeat("x", 100) + `"}` + "\n")
+	}
+	body.WriteString(`{"op":"delete","path":"na.md"}`)
+	rec := serve(t, r, http.MethodPost, "/v1/push", body.String(), map[string]string{"Content-Type": ndjson})
+	var res PushResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(res.Results) != 22 || len(res.Moved) != 1 || len(res.Deleted) != 1 {
+		t.Fatalf("ndjson push: %d %+v", rec.Code, res)
+	}
+	if f, ok := store.Get("nb.md"); !ok || f.Hash != sync.ContentHash(f.Content) {
+		t.Fatalf("write without hash should get the content hash: %+v", f)
+	}
+	if fake.calls != 1 {
+		t.Fatalf("the whole stream should mirror as one sync: %d", fake.calls)
+	}
+
+	rec = serve(t, r, http.MethodPost, "/v1/push", `{"op":"write","path":"ok.md","content":"k"}`+"\n"+`{"op":"rename"}`+"\n"+`{"op":"delete","path":"ok.md"}`, map[string]string{"Content-Type": ndjson})
+	var e struct {
+		Code    string
+		Details struct {
+			Line    int
+			Results []PushResult
+		}
+	}
+	json.NewDecoder(rec.Body).Decode(&e)
+	if rec.Code != http.StatusBadRequest || e.Code != CodeInvalidJSON || e.Details.Line != 2 || len(e.Details.Results) != 1 {
+		t.Fatalf("bad line: %d %+v", rec.Code, e)
+	}
+	if _, ok := store.Get("ok.md"); !ok || fake.calls != 2 {
+		t.Fatalf("lines before the bad one should be kept and mirrored: calls=%d", fake.calls)
+	}
+
+	rec = serve(t, r, http.MethodPost, "/v1/push", `{"op":"write","path":"big.md","content":"`+strings.Repeat("x", 5000)+`"}`, map[string]string{"Content-Type": ndjson})
+	json.NewDecoder(rec.Body).Decode(&e)
+	if rec.Code != http.StatusRequestEntityTooLarge || e.Code != CodeBodyTooLarge || e.Details.Line != 1 {
+		t.Fatalf("oversized line: %d %+v", rec.Code, e)
+	}
+}
@@ -1,23 +1,376 @@
 package api
 
+import (
+	"github.com/shaun/flux/server/internal/collab"
+	"github.com/shaun/flux/server/internal/mirror"
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// PushRequest is applied in order: moves, then files, then deletes. Moved and deleted
+// paths may name folders, which apply to every file under them. With Strict set, any
+// rejected or conflicting entry fails the whole push and nothing is applied.
+//
+// IdempotencyKey (or the Idempotency-Key header) makes retries safe: a push repeating a
+// recent key gets the original response back instead of being applied again.
+//
+// With ConflictCopies set, a write that conflicts with a live file keeps both versions: the
+// server's stays at the path and the pushed one is written next to it as a conflict copy,
+// listed in /v1/conflicts, with result status "copied". Patches whose base is gone stay conflicts.
 type PushRequest struct {
-	Files   []PushFile `json:"files"`
-	Deleted []string   `json:"deleted"`
+	Moved          []Move     `json:"moved,omitempty"`
+	Files          []PushFile `json:"files"`
+	Deleted        []string   `json:"deleted"`
+	Strict         bool       `json:"strict,omitempty"`
+	ConflictCopies bool       `json:"conflictCopies,omitempty"`
+	IdempotencyKey string     `json:"idempotencyKey,omitempty"`
+}
+
+// Move renames a file or folder, keeping its content and history.
+type Move struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PushResponse lists every file moved or tombstoned by the push; folders expand to the files they held.
+// Results has one entry per request entry, in request order. A refused strict push is an
+// error (CodePushRejected) carrying the results in its details.
+type PushResponse struct {
+	Status  string       `json:"status"`
+	Results []PushResult `json:"results"`
+	Moved   []Move       `json:"moved,omitempty"`
+	Deleted []string     `json:"deleted,omitempty"`
+}
+
+// Push result statuses.
+const (
+	ResultAccepted  = "accepted"
+	ResultUnchanged = "unchanged" // content or tombstone already matched; nothing was written
+	ResultRejected  = "rejected"  // invalid entry; Reason says why
+	ResultConflict  = "conflict"  // the server copy changed since the client's base; Hash is the server's
+	ResultCopied    = "copied"    // a conflict kept as Copy, with the server version left at Path
+)
+
+// PushResult is the outcome of one move, file or delete. For moves Path is the target and From the source.
+type PushResult struct {
+	Op     string             `json:"op"` // "move", "write" or "delete"
+	Path   string             `json:"path"`
+	From   string             `json:"from,omitempty"`
+	Status string             `json:"status"`
+	Reason string             `json:"reason,omitempty"`
+	Hash   string             `json:"hash,omitempty"`
+	Vector sync.VersionVector `json:"vector,omitempty"` // of the accepted write, or of the server copy on a conflict
+	Copy   string             `json:"copy,omitempty"`   // path of the conflict copy, for "copied"
+	Lock   *LockInfo          `json:"lock,omitempty"`   // the lock that refused the entry
 }
 
+// PushFile is a file write. BaseHash, when set, is the hash the client last pulled; the
+// write is a conflict if the server copy has moved on since.
+//
+// Patch, instead of Content, sends only the changed lines of the BaseHash version. Hash is
+// then checked against the patched content. If the server copy isn't that base the result is
+// a "patch base mismatch" conflict and the client should send the full content.
+//
+// Vector, instead of BaseHash, is the version vector of the client's copy: the one it last
+// pulled, with its own device's entry ticked for its edit. The write is accepted if it
+// descends from the server copy's vector, so a client whose earlier push went through but
+// whose response was lost isn't refused its next edit; otherwise it is a conflict, with reason
+// "concurrent edit" if the two copies were edited independently. Results carry the server
+// copy's vector, which the client merges into its own, also when the write was unchanged.
+//
+// Chunks, instead of Content, names chunks uploaded to /v1/chunks (or already on the server)
+// whose bytes make up the content, in order. Together they must be UTF-8 text; a file that
+// isn't is rejected with reason "content is not UTF-8 text".
 type PushFile struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
-	Hash    string `json:"hash"`
+	Path     string             `json:"path"`
+	Content  string             `json:"content"`
+	Hash     string             `json:"hash"`
+	BaseHash string             `json:"baseHash,omitempty"`
+	Patch    []sync.LineEdit    `json:"patch,omitempty"`
+	Chunks   []string           `json:"chunks,omitempty"`
+	Vector   sync.VersionVector `json:"vector,omitempty"`
 }
 
+// PullResponse carries moves in the order they happened; clients should replay them as
+// renames before applying deletes. Each move's source also appears in Deleted for
+// clients that don't understand moves. Files and Deleted are sorted by path.
+//
+// With a limit, Next is the cursor for the following page and is empty on the last one.
+// Revision is the store revision when the first page was served; pull again if the store
+// has moved past it by the time the last page arrives.
+//
+// Once every page has arrived, Revision can be passed as since on the next pull to get only
+// what changed after it. A since older than the last tombstone pruning gets 410
+// (CodeResyncRequired) and the client must resync from the full state.
+//
+// Locks lists the advisory locks held on matching paths, on the first page only, whatever
+// since says; taking or releasing a lock starts a new revision.
 type PullResponse struct {
-	Files   []PullFile `json:"files"`
-	Deleted []string   `json:"deleted"`
+	Files    []PullFile `json:"files"`
+	Moved    []Move     `json:"moved"`
+	Deleted  []string   `json:"deleted"`
+	Locks    []LockInfo `json:"locks,omitempty"`
+	Revision int64      `json:"revision"`
+	Next     string     `json:"next,omitempty"`
+}
+
+// PullRequest is the body of POST /v1/pull: the hash of each file the client already has.
+// Files still at that hash are left out of the response, and files the server changed since
+// come back as a patch against it when that is smaller than the content.
+type PullRequest struct {
+	Have map[string]string `json:"have"`
 }
 
+// PullFile is a file's full content, or with Patch set, the edits from the BaseHash version
+// the client said it has.
 type PullFile struct {
-	Path    string `json:"path"`
+	Path     string             `json:"path"`
+	Content  string             `json:"content"`
+	Hash     string             `json:"hash"`
+	BaseHash string             `json:"baseHash,omitempty"`
+	Patch    []sync.LineEdit    `json:"patch,omitempty"`
+	Vector   sync.VersionVector `json:"vector,omitempty"`
+}
+
+type HealthResponse struct {
+	Status  string       `json:"status"`
+	Mode    string       `json:"mode"`
+	Mirrors []string     `json:"mirrors"`
+	Limits  HealthLimits `json:"limits"`
+}
+
+type StatusResponse struct {
+	Mode    string          `json:"mode"`
+	Files   int             `json:"files"`
+	Deleted int             `json:"deleted"`
+	Mirrors []mirror.Status `json:"mirrors"`
+}
+
+// FileResponse is a single file from /v1/files/{path}. Its ETag header is the quoted hash.
+// Chunked requests get Chunks, the file's chunk hashes in order, instead of Content.
+type FileResponse struct {
+	Path      string             `json:"path"`
+	Content   string             `json:"content"`
+	Hash      string             `json:"hash"`
+	Revision  int64              `json:"revision"`
+	UpdatedAt int64              `json:"updatedAt"`
+	Device    string             `json:"device,omitempty"`
+	Vector    sync.VersionVector `json:"vector,omitempty"`
+	Chunks    []string           `json:"chunks,omitempty"`
+}
+
+// PutFileRequest is the JSON body of PUT /v1/files/{path}. Hash defaults to the content hash.
+type PutFileRequest struct {
 	Content string `json:"content"`
-	Hash    string `json:"hash"`
+	Hash    string `json:"hash,omitempty"`
+}
+
+// FileInfo describes a file without its content. Revision is the store revision of its last
+// change and Device the ID of the device that made it, if it identified itself.
+type FileInfo struct {
+	Path      string             `json:"path"`
+	Hash      string             `json:"hash"`
+	Size      int                `json:"size"`
+	Revision  int64              `json:"revision"`
+	UpdatedAt int64              `json:"updatedAt"`
+	Device    string             `json:"device,omitempty"`
+	Vector    sync.VersionVector `json:"vector,omitempty"`
+}
+
+// TombstoneInfo describes a deleted path.
+type TombstoneInfo struct {
+	Path      string             `json:"path"`
+	Revision  int64              `json:"revision"`
+	DeletedAt int64              `json:"deletedAt"`
+	Device    string             `json:"device,omitempty"`
+	Vector    sync.VersionVector `json:"vector,omitempty"`
+}
+
+// ManifestResponse is every file and tombstone without content, sorted by path. Revision is
+// the store revision the listing reflects.
+type ManifestResponse struct {
+	Revision int64           `json:"revision"`
+	Files    []FileInfo      `json:"files"`
+	Deleted  []TombstoneInfo `json:"deleted"`
+	Locks    []LockInfo      `json:"locks,omitempty"`
+}
+
+// FetchRequest asks for the content of several files at once.
+type FetchRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// FetchResponse returns the requested files in request order; paths with no live file are listed in Missing.
+type FetchResponse struct {
+	Files   []FileResponse `json:"files"`
+	Missing []string       `json:"missing"`
+}
+
+// FileListResponse is GET /v1/files, sorted by path.
+type FileListResponse struct {
+	Files []FileInfo `json:"files"`
+}
+
+// DeleteResponse lists the files tombstoned by DELETE /v1/files/{path}; a folder expands to the files it held.
+type DeleteResponse struct {
+	Deleted []string `json:"deleted"`
+}
+
+// PushOp is one line of an NDJSON push (Content-Type: application/x-ndjson). Op is "move"
+// (From, To), "write" (Path, Content, Patch or Chunks, Hash, BaseHash) or "delete" (Path). Lines apply in order.
+type PushOp struct {
+	Op       string             `json:"op"`
+	Path     string             `json:"path,omitempty"`
+	From     string             `json:"from,omitempty"`
+	To       string             `json:"to,omitempty"`
+	Content  string             `json:"content,omitempty"`
+	Hash     string             `json:"hash,omitempty"`
+	BaseHash string             `json:"baseHash,omitempty"`
+	Patch    []sync.LineEdit    `json:"patch,omitempty"`
+	Chunks   []string           `json:"chunks,omitempty"`
+	Vector   sync.VersionVector `json:"vector,omitempty"`
+}
+
+// PullEvent is one line of an NDJSON pull (Accept: application/x-ndjson): every "move", then
+// every "deleted" path, then every "lock" (Path, Device, ExpiresAt), then every "file", then
+// one "end" carrying the revision and next cursor as in PullResponse. A stream without "end"
+// was cut off.
+type PullEvent struct {
+	Type      string             `json:"type"`
+	Path      string             `json:"path,omitempty"`
+	Content   string             `json:"content,omitempty"`
+	Hash      string             `json:"hash,omitempty"`
+	From      string             `json:"from,omitempty"`
+	To        string             `json:"to,omitempty"`
+	BaseHash  string             `json:"baseHash,omitempty"`
+	Patch     []sync.LineEdit    `json:"patch,omitempty"`
+	Vector    sync.VersionVector `json:"vector,omitempty"`
+	Device    string             `json:"device,omitempty"`
+	ExpiresAt int64              `json:"expiresAt,omitempty"`
+	Revision  int64              `json:"revision,omitempty"`
+	Next      string             `json:"next,omitempty"`
+}
+
+// ReplayRequest is the change journal a device recorded while offline. Ops apply in order in
+// one batch, each against the server copy as the ops before it left it, and the response is a
+// PushResponse with one result per op. With Strict set, any rejected or conflicting op fails
+// the whole replay and nothing is applied. ConflictCopies works as in PushRequest.
+type ReplayRequest struct {
+	Ops            []JournalOp `json:"ops"`
+	Strict         bool        `json:"strict,omitempty"`
+	ConflictCopies bool        `json:"conflictCopies,omitempty"`
+	IdempotencyKey string      `json:"idempotencyKey,omitempty"`
+}
+
+// JournalOp is one recorded change: "create" (Path, Content), "modify" (Path, Content or Patch),
+// "rename" (From to Path) or "delete" (Path). At is the client's clock (Unix ms) when the
+// change was made. BaseHash is the hash the change was made against; a modify or delete is a
+// conflict if the server copy no longer has it. Without BaseHash, At is compared with the
+// server's last update instead, which depends on the two clocks agreeing.
+type JournalOp struct {
+	Op       string             `json:"op"`
+	Path     string             `json:"path"`
+	From     string             `json:"from,omitempty"`
+	Content  string             `json:"content,omitempty"`
+	Hash     string             `json:"hash,omitempty"`
+	BaseHash string             `json:"baseHash,omitempty"`
+	Patch    []sync.LineEdit    `json:"patch,omitempty"`
+	Vector   sync.VersionVector `json:"vector,omitempty"`
+	At       int64              `json:"at,omitempty"`
+}
+
+// ChunksRequest lists chunk hashes for POST /v1/chunks/missing.
+type ChunksRequest struct {
+	Hashes []string `json:"hashes"`
+}
+
+// ChunksResponse lists the requested chunks the server does not have, to upload before pushing.
+type ChunksResponse struct {
+	Missing []string `json:"missing"`
+}
+
+// HealthLimits tells clients how to size requests: a JSON push must fit in MaxPushBytes, so
+// large syncs are split into batches or sent as NDJSON, where the limit applies per line.
+type HealthLimits struct {
+	MaxPushBytes  int64 `json:"maxPushBytes"`
+	MaxPathLength int   `json:"maxPathLength"`
+}
+
+// DeviceInfo is a device that identified itself with X-Device-ID. Acked is the newest
+// revision it confirmed by pulling with since; Behind is how many revisions it lacks.
+type DeviceInfo struct {
+	ID            string `json:"id"`
+	Name          string `json:"name,omitempty"`
+	Platform      string `json:"platform,omitempty"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+	FirstSeen     int64  `json:"firstSeen"`
+	LastSeen      int64  `json:"lastSeen"`
+	Acked         int64  `json:"acked"`
+	Behind        int64  `json:"behind"`
+	RevokedAt     int64  `json:"revokedAt,omitempty"`
+}
+
+// DevicesResponse is GET /v1/devices, sorted by ID.
+type DevicesResponse struct {
+	Devices []DeviceInfo `json:"devices"`
+}
+
+// ConflictInfo is a conflict kept as a copy: Path holds the server version (hash Winner) and
+// Copy the losing edit (hash Hash) by Device.
+type ConflictInfo struct {
+	Path      string `json:"path"`
+	Copy      string `json:"copy"`
+	Device    string `json:"device,omitempty"`
+	Hash      string `json:"hash"`
+	Winner    string `json:"winner"`
+	CreatedAt int64  `json:"createdAt"`
+	Revision  int64  `json:"revision"`
+}
+
+// ConflictsResponse is GET /v1/conflicts, sorted by copy path.
+type ConflictsResponse struct {
+	Conflicts []ConflictInfo `json:"conflicts"`
+}
+
+// LockInfo is an advisory lock: Device may change Path, and other devices may not, until
+// ExpiresAt (Unix ms) unless the lock is renewed.
+type LockInfo struct {
+	Path       string `json:"path"`
+	Device     string `json:"device"`
+	AcquiredAt int64  `json:"acquiredAt"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
+// LocksResponse is GET /v1/locks, sorted by path.
+type LocksResponse struct {
+	Locks []LockInfo `json:"locks"`
+}
+
+// CollabMessage is one JSON text message on the /v1/collab WebSocket.
+//
+// The server sends "sync" first: the document as Runs, the Clock to start Lamport clocks
+// above, the Session ID to insert characters as, and the Peers viewing it. Clients send
+// "update" with Ops, which the server applies and relays to the other peers as "update"
+// with the sender's Session, and "presence" with a State of their choice (e.g. a cursor),
+// after which every peer gets "presence" with the full Peers list. "error" (Code, Message)
+// precedes closing a connection that sent something invalid; the client should reconnect
+// and start over from the new "sync".
+type CollabMessage struct {
+	Type    string       `json:"type"`
+	Path    string       `json:"path,omitempty"`
+	Session string       `json:"session,omitempty"`
+	Clock   int64        `json:"clock,omitempty"`
+	Runs    []collab.Run `json:"runs,omitempty"`
+	Ops     []collab.Op  `json:"ops,omitempty"`
+	Peers   []Peer       `json:"peers,omitempty"`
+	State   any          `json:"state,omitempty"`
+	Code    string       `json:"code,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+// Peer is one connection viewing a collab document.
+type Peer struct {
+	Session string `json:"session"`
+	Device  string `json:"device,omitempty"`
+	Name    string `json:"name,omitempty"`
+	State   any    `json:"state,omitempty"`
 }
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// The server side of RFC 6455, as much as the collab channel needs: the handshake, text
+// messages (fragmented or not), ping, pong and close. No extensions or subprotocols.
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	opContinuation = 0x0
+	opText         = 0x1
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	closeGoingAway   = 1001
+	closeProtocol    = 1002
+	closeUnsupported = 1003
+	closePolicy      = 1008
+	closeTooBig      = 1009
+
+	// The server pings every wsPingInterval; a client silent for wsReadTimeout is gone.
+	wsPingInterval = 30 * time.Second
+	wsReadTimeout  = 75 * time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+// wsError is a protocol failure, closed with its code.
+type wsError struct {
+	code int
+	msg  string
+}
+
+func (e *wsError) Error() string { return e.msg }
+
+type wsConn struct {
+	conn  net.Conn
+	br    *bufio.Reader
+	limit int64        // largest message accepted
+	mu    gosync.Mutex // serialises writes
+}
+
+// isWebSocket reports whether r is a valid request to upgrade to a WebSocket.
+func isWebSocket(r *http.Request) bool {
+	key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key"))
+	return r.Method == http.MethodGet && headerHas(r.Header, "Connection", "upgrade") && headerHas(r.Header, "Upgrade", "websocket") &&
+		r.Header.Get("Sec-WebSocket-Version") == "13" && err == nil && len(key) == 16
+}
+
+// headerHas reports whether the comma-separated header lists token, ignoring case.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the handshake of a request isWebSocket accepted and takes
+// over its connection.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, limit int64) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader, limit: limit}, nil
+}
+
+// ReadMessage returns the next text message, answering pings on the way. A close from the
+// client is answered and returned as io.EOF; protocol errors are *wsError.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opClose:
+			code := payload
+			if len(code) > 2 {
+				code = code[:2]
+			}
+			c.writeFrame(opClose, code)
+			return nil, io.EOF
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opText:
+			if started {
+				return nil, &wsError{closeProtocol, "expected a continuation frame"}
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, &wsError{closeProtocol, "unexpected continuation frame"}
+			}
+		default:
+			return nil, &wsError{closeUnsupported, "only text messages are supported"}
+		}
+		if int64(len(msg)+len(payload)) > c.limit {
+			return nil, &wsError{closeTooBig, "message too large"}
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	c.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	if hdr[0]&0x70 != 0 {
+		return fin, op, nil, &wsError{closeProtocol, "reserved bits set"}
+	}
+	if hdr[1]&0x80 == 0 {
+		return fin, op, nil, &wsError{closeProtocol, "client frames must be masked"}
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= opClose && (n > 125 || !fin) {
+		return fin, op, nil, &wsError{closeProtocol, "invalid control frame"}
+	}
+	if n > uint64(c.limit) {
+		return fin, op, nil, &wsError{closeTooBig, "message too large"}
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteText sends one unfragmented text message.
+func (c *wsConn) WriteText(msg []byte) error {
+	return c.writeFrame(opText, msg)
+}
+
+// Ping checks the client is still there; its pong refreshes the read deadline.
+func (c *wsConn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close frame with code and reason, then drops the connection.
+func (c *wsConn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(opClose, append(payload, reason...))
+	return c.conn.Close()
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hdr := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = binary.BigEndian.AppendUint16(append(hdr, 126), uint16(n))
+	default:
+		hdr = binary.BigEndian.AppendUint64(append(hdr, 127), uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := (&net.Buffers{hdr, payload}).WriteTo(c.conn)
+	return err
+}
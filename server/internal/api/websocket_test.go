@@ -0,0 +1,248 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is the client end of a WebSocket, speaking raw frames.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWS(t *testing.T, srv *httptest.Server, path string, header map[string]string) *wsClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	req := "GET " + path + " HTTP/1.1\r\nHost: flux\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\nAccept-Encoding: gzip\r\n"
+	for k, v := range header {
+		req += k + ": " + v + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if res.StatusCode != http.StatusSwitchingProtocols || res.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("handshake: %d %v", res.StatusCode, res.Header)
+	}
+	return &wsClient{t: t, conn: conn, br: br}
+}
+
+// frame writes one frame, masked as clients must unless masked is false.
+func (c *wsClient) frame(op byte, fin, masked bool, payload string) {
+	c.t.Helper()
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	hdr := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = binary.BigEndian.AppendUint16(append(hdr, 126), uint16(n))
+	default:
+		hdr = binary.BigEndian.AppendUint64(append(hdr, 127), uint64(n))
+	}
+	data := []byte(payload)
+	if masked {
+		hdr[1] |= 0x80
+		mask := []byte{1, 2, 3, 4}
+		hdr = append(hdr, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(append(hdr, data...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) send(v any) {
+	c.t.Helper()
+	data, _ := json.Marshal(v)
+	c.frame(opText, true, true, string(data))
+}
+
+// read returns the next frame from the server, which never masks or fragments.
+func (c *wsClient) read() (byte, []byte) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return hdr[0] & 0x0f, payload
+}
+
+func (c *wsClient) recv() CollabMessage {
+	c.t.Helper()
+	op, payload := c.read()
+	if op != opText {
+		c.t.Fatalf("want a text message, got op %d %q", op, payload)
+	}
+	var msg CollabMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// closed reads the server's close frame and returns its code.
+func (c *wsClient) closed() int {
+	c.t.Helper()
+	op, payload := c.read()
+	if op != opClose || len(payload) < 2 {
+		c.t.Fatalf("want a close frame, got op %d %q", op, payload)
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func TestWebSocket(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocket(r) {
+			w.WriteHeader(http.StatusUpgradeRequired)
+			return
+		}
+		conn, err := upgradeWebSocket(w, r, 16)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for {
+			msg, err := conn.ReadMessage()
+			var we *wsError
+			if errors.As(err, &we) {
+				conn.Close(we.code, we.msg)
+				return
+			}
+			if err != nil {
+				conn.conn.Close()
+				return
+			}
+			conn.WriteText(msg)
+		}
+	}))
+	defer srv.Close()
+
+	c := dialWS(t, srv, "/", nil)
+	c.frame(opText, false, true, "hel")
+	c.frame(opPing, true, true, "p")
+	c.frame(opContinuation, true, true, "lo")
+	if op, payload := c.read(); op != opPong || string(payload) != "p" {
+		t.Fatalf("pong: %d %q", op, payload)
+	}
+	if op, payload := c.read(); op != opText || string(payload) != "hello" {
+		t.Fatalf("fragmented echo: %d %q", op, payload)
+	}
+	c.frame(opPong, true, true, "")
+	c.frame(opText, true, true, strings.Repeat("x", 16))
+	if _, payload := c.read(); len(payload) != 16 {
+		t.Fatalf("limit-sized echo: %q", payload)
+	}
+	c.frame(opClose, true, true, "\x03\xe8bye")
+	if code := c.closed(); code != 1000 {
+		t.Fatalf("close echo: %d", code)
+	}
+
+	for name, tc := range map[string]struct {
+		send func(*wsClient)
+		code int
+	}{
+		"unmasked":  {func(c *wsClient) { c.frame(opText, true, false, "x") }, closeProtocol},
+		"binary":    {func(c *wsClient) { c.frame(0x2, true, true, "x") }, closeUnsupported},
+		"too large": {func(c *wsClient) { c.frame(opText, true, true, strings.Repeat("x", 17)) }, closeTooBig},
+		"too large in pieces": {func(c *wsClient) {
+			c.frame(opText, false, true, "0123456789")
+			c.frame(opContinuation, true, true, "0123456789")
+		}, closeTooBig},
+		"extended length":      {func(c *wsClient) { c.frame(opText, true, true, strings.Repeat("x", 200)) }, closeTooBig},
+		"huge length":          {func(c *wsClient) { c.conn.Write([]byte{0x81, 0xff, 0, 0, 0, 0, 0, 1, 0, 0}) }, closeTooBig},
+		"stray continuation":   {func(c *wsClient) { c.frame(opContinuation, true, true, "x") }, closeProtocol},
+		"interleaved text":     {func(c *wsClient) { c.frame(opText, false, true, "x"); c.frame(opText, true, true, "y") }, closeProtocol},
+		"fragmented control":   {func(c *wsClient) { c.frame(opPing, false, true, "x") }, closeProtocol},
+		"reserved bits":        {func(c *wsClient) { c.frame(opText|0x40, true, true, "x") }, closeProtocol},
+		"oversized ping frame": {func(c *wsClient) { c.frame(opPing, true, true, strings.Repeat("x", 126)) }, closeProtocol},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := dialWS(t, srv, "/", nil)
+			c.t = t
+			tc.send(c)
+			if code := c.closed(); code != tc.code {
+				t.Fatalf("close code %d, want %d", code, tc.code)
+			}
+		})
+	}
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("plain GET: %d", res.StatusCode)
+	}
+}
+
+func TestIsWebSocket(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	for _, tc := range []struct {
+		method, connection, upgrade, version, key string
+		want                                      bool
+	}{
+		{"GET", "Upgrade", "websocket", "13", key, true},
+		{"GET", "keep-alive, upgrade", "WebSocket", "13", key, true},
+		{"POST", "Upgrade", "websocket", "13", key, false},
+		{"GET", "keep-alive", "websocket", "13", key, false},
+		{"GET", "Upgrade", "h2c", "13", key, false},
+		{"GET", "Upgrade", "websocket", "8", key, false},
+		{"GET", "Upgrade", "websocket", "13", "short", false},
+	} {
+		r := httptest.NewRequest(tc.method, "/", nil)
+		r.Header.Set("Connection", tc.connection)
+		r.Header.Set("Upgrade", tc.upgrade)
+		r.Header.Set("Sec-WebSocket-Version", tc.version)
+		r.Header.Set("Sec-WebSocket-Key", tc.key)
+		if got := isWebSocket(r); got != tc.want {
+			t.Errorf("%+v: %v", tc, got)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package certs serves TLS certificates from files and swaps them in when they change on
+// disk (e.g. after a certbot renewal) without restarting the listener.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+type state struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// Reloader holds the current certificate and client CA pool. Every TLS handshake uses
+// whatever was loaded last; a failed Reload keeps the previous files in use.
+type Reloader struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+	state      atomic.Pointer[state]
+}
+
+// New loads certFile/keyFile and, when caFile is set, the CA bundle used to verify client certificates.
+func New(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile, clientAuth: clientAuth}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ClientAuth maps the config value ("", "optional", "require") to the crypto/tls policy.
+func ClientAuth(mode string) tls.ClientAuthType {
+	switch mode {
+	case "optional":
+		return tls.VerifyClientCertIfGiven
+	case "require":
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.NoClientCert
+}
+
+// Reload re-reads the files and swaps them in atomically if they are all valid.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	next := &state{cert: &cert}
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		next.clientCAs = x509.NewCertPool()
+		if !next.clientCAs.AppendCertsFromPEM(pem) {
+			return errors.New(r.caFile + ": no certificates found")
+		}
+	}
+	r.state.Store(next)
+	return nil
+}
+
+// Certificate returns the certificate currently being served.
+func (r *Reloader) Certificate() *tls.Certificate {
+	return r.state.Load().cert
+}
+
+// TLSConfig returns a server config that picks up reloaded files on every handshake. It is
+// a single config, so the server's NextProtos (HTTP/2) and session ticket keys apply to every
+// connection: the certificate comes from GetCertificate, and client certificates are
+// verified against the current CA pool in VerifyConnection rather than by crypto/tls.
+func (r *Reloader) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.state.Load().cert, nil
+		},
+	}
+	switch r.clientAuth {
+	case tls.VerifyClientCertIfGiven:
+		cfg.ClientAuth = tls.RequestClientCert
+	case tls.RequireAndVerifyClientCert:
+		cfg.ClientAuth = tls.RequireAnyClientCert
+	default:
+		return cfg
+	}
+	cfg.VerifyConnection = r.verifyClient
+	return cfg
+}
+
+// verifyClient checks the client's certificate chain, if it sent one, against the CA pool
+// loaded last. It also runs on resumed sessions, so a replaced CA takes effect for those too.
+func (r *Reloader) verifyClient(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.state.Load().clientCAs,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,208 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for cn and returns the cert and key paths.
+func writeCert(t *testing.T, dir, cn string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		DNSNames:              []string{cn},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func leaf(t *testing.T, c *tls.Certificate) *x509.Certificate {
+	t.Helper()
+	l, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func commonName(t *testing.T, c *tls.Certificate) string {
+	t.Helper()
+	return leaf(t, c).Subject.CommonName
+}
+
+// handshake runs a TLS handshake between server and client over a pipe and returns the
+// server's view of the connection.
+func handshake(server, client *tls.Config) (tls.ConnectionState, error) {
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+	errc := make(chan error, 1)
+	go func() {
+		c := tls.Client(cc, client)
+		err := c.Handshake()
+		if err == nil {
+			// TLS 1.3 reports a rejected client certificate on the first read.
+			_, err = c.Read(make([]byte, 1))
+		}
+		errc <- err
+	}()
+	s := tls.Server(sc, server)
+	err := s.Handshake()
+	if err == nil {
+		_, err = s.Write([]byte{0})
+	}
+	if cerr := <-errc; err == nil {
+		err = cerr
+	}
+	return s.ConnectionState(), err
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCert(t, dir, "old.example")
+	r, err := New(certPath, keyPath, "", tls.NoClientCert)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if cn := commonName(t, r.Certificate()); cn != "old.example" {
+		t.Fatalf("initial cert: %s", cn)
+	}
+
+	writeCert(t, dir, "new.example")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	cert, err := r.TLSConfig().GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := commonName(t, cert); cn != "new.example" {
+		t.Fatalf("handshake should use reloaded cert, got %s", cn)
+	}
+
+	// A broken renewal keeps serving the last good certificate.
+	if err := os.WriteFile(keyPath, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected error reloading invalid key")
+	}
+	if cn := commonName(t, r.Certificate()); cn != "new.example" {
+		t.Fatalf("failed reload replaced cert: %s", cn)
+	}
+}
+
+func TestReloader_clientCA(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCert(t, dir, "server.example")
+	caDir := t.TempDir()
+	caPath, _ := writeCert(t, caDir, "ca.example")
+
+	r, err := New(certPath, keyPath, caPath, ClientAuth("require"))
+	if err != nil {
+		t.Fatalf("New with CA: %v", err)
+	}
+	cfg := r.TLSConfig()
+	cfg.NextProtos = []string{"h2"} // as net/http adds it
+	client := func(dir string) *tls.Config {
+		c := &tls.Config{ServerName: "server.example", RootCAs: x509.NewCertPool(), NextProtos: []string{"h2"}}
+		c.RootCAs.AddCert(leaf(t, r.Certificate()))
+		if dir != "" {
+			pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Certificates = []tls.Certificate{pair}
+		}
+		return c
+	}
+	otherDir := t.TempDir()
+	otherPath, _ := writeCert(t, otherDir, "other.example")
+	fromCA, fromOther := client(caDir), client(otherDir)
+	cs, err := handshake(cfg, fromCA)
+	if err != nil || cs.NegotiatedProtocol != "h2" {
+		t.Fatalf("handshake with a certificate from the CA: %v, protocol %q", err, cs.NegotiatedProtocol)
+	}
+	if _, err := handshake(cfg, client("")); err == nil {
+		t.Fatal("handshake without a client certificate accepted")
+	}
+	if _, err := handshake(cfg, fromOther); err == nil {
+		t.Fatal("certificate from another CA accepted")
+	}
+
+	// A reloaded CA bundle applies to the same config.
+	otherPEM, err := os.ReadFile(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(caPath, otherPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(cfg, fromOther); err != nil {
+		t.Fatalf("certificate from the reloaded CA: %v", err)
+	}
+	if _, err := handshake(cfg, fromCA); err == nil {
+		t.Fatal("certificate from the replaced CA accepted")
+	}
+
+	badCA := filepath.Join(caDir, "empty.pem")
+	if err := os.WriteFile(badCA, []byte("no certs"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(certPath, keyPath, badCA, tls.VerifyClientCertIfGiven); err == nil {
+		t.Fatal("expected error for CA file without certificates")
+	}
+	if _, err := New(certPath, keyPath, filepath.Join(caDir, "missing.pem"), tls.VerifyClientCertIfGiven); err == nil {
+		t.Fatal("expected error for missing CA file")
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	for mode, want := range map[string]tls.ClientAuthType{
+		"":         tls.NoClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+		"require":  tls.RequireAndVerifyClientCert,
+	} {
+		if got := ClientAuth(mode); got != want {
+			t.Errorf("ClientAuth(%q) = %v, want %v", mode, got, want)
+		}
+	}
+}
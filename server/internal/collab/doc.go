@@ -0,0 +1,197 @@
+// Package collab holds the text CRDT behind real-time collaborative editing.
+//
+// A Doc is a replicated growable array (RGA): every character ever inserted keeps a unique
+// ID, each insert names the character it was typed after, and deleted characters stay behind
+// as hidden markers so later edits can still refer to them. Replicas that apply the same
+// operations, in any order that respects causality, end up with the same text.
+package collab
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ID names one character: the client that inserted it and that client's Lamport clock at
+// the time. A client picks each Seq above every Seq it has seen, so a character typed after
+// another always has the greater ID.
+type ID struct {
+	Client string `json:"client"`
+	Seq    int64  `json:"seq"`
+}
+
+// less orders IDs by Seq, then Client.
+func (a ID) less(b ID) bool {
+	if a.Seq != b.Seq {
+		return a.Seq < b.Seq
+	}
+	return a.Client < b.Client
+}
+
+// Op is one edit. An insert places Text after the character After (nil for the start of the
+// document); its characters get IDs ID, ID+1, ... from the same client. A delete hides the
+// Len characters (default 1) with IDs ID, ID+1, ...
+type Op struct {
+	Type  string `json:"type"` // "insert" or "delete"
+	ID    ID     `json:"id"`
+	After *ID    `json:"after,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Len   int    `json:"len,omitempty"`
+}
+
+// Run is a stretch of characters with consecutive IDs from one client, as a Doc is sent to a
+// replica that joins it. A deleted run keeps its IDs, which edits may still refer to, but not
+// its text.
+type Run struct {
+	ID      ID     `json:"id"`
+	Text    string `json:"text,omitempty"`
+	Deleted int    `json:"deleted,omitempty"` // length of a deleted run
+}
+
+var (
+	ErrUnknownID = errors.New("unknown character id")
+	ErrInvalidOp = errors.New("invalid op")
+)
+
+type elem struct {
+	id      ID
+	r       rune
+	deleted bool
+}
+
+// Doc is a replica of one document. It is not safe for concurrent use.
+type Doc struct {
+	elems []elem
+	clock int64
+}
+
+// NewDoc returns a document holding text, as inserted by the client "" before anyone else.
+func NewDoc(text string) *Doc {
+	d := &Doc{}
+	for _, r := range text {
+		d.clock++
+		d.elems = append(d.elems, elem{id: ID{Seq: d.clock}, r: r})
+	}
+	return d
+}
+
+// Clock is the greatest Seq in the document; clients joining it start their clock there.
+func (d *Doc) Clock() int64 {
+	return d.clock
+}
+
+// Text is the visible content.
+func (d *Doc) Text() string {
+	var b strings.Builder
+	for _, e := range d.elems {
+		if !e.deleted {
+			b.WriteRune(e.r)
+		}
+	}
+	return b.String()
+}
+
+// Apply applies ops in order and returns how many were applied; on error the rest are not.
+// Ops that were already applied are skipped, so replaying an update is harmless.
+func (d *Doc) Apply(ops ...Op) (int, error) {
+	for i, op := range ops {
+		var err error
+		switch op.Type {
+		case "insert":
+			err = d.insert(op)
+		case "delete":
+			err = d.delete(op)
+		default:
+			err = fmt.Errorf("%w: type %q", ErrInvalidOp, op.Type)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(ops), nil
+}
+
+func (d *Doc) insert(op Op) error {
+	runes := []rune(op.Text)
+	if len(runes) == 0 || op.ID.Seq < 1 {
+		return fmt.Errorf("%w: insert needs text and a positive seq", ErrInvalidOp)
+	}
+	if d.find(op.ID) >= 0 {
+		return nil
+	}
+	pos := 0
+	if op.After != nil {
+		i := d.find(*op.After)
+		if i < 0 {
+			return fmt.Errorf("%w: %s/%d", ErrUnknownID, op.After.Client, op.After.Seq)
+		}
+		pos = i + 1
+	}
+	// Characters inserted after the same one concurrently are ordered by ID, greatest first;
+	// skipping the greater ones also skips everything typed after them.
+	for pos < len(d.elems) && op.ID.less(d.elems[pos].id) {
+		pos++
+	}
+	run := make([]elem, len(runes))
+	for i, r := range runes {
+		run[i] = elem{id: ID{Client: op.ID.Client, Seq: op.ID.Seq + int64(i)}, r: r}
+	}
+	d.elems = append(d.elems[:pos], append(run, d.elems[pos:]...)...)
+	d.clock = max(d.clock, op.ID.Seq+int64(len(runes))-1)
+	return nil
+}
+
+func (d *Doc) delete(op Op) error {
+	n := max(op.Len, 1)
+	if n > len(d.elems) {
+		return fmt.Errorf("%w: delete of %d characters", ErrInvalidOp, n)
+	}
+	var hit []int
+	for i, e := range d.elems {
+		if e.id.Client == op.ID.Client && e.id.Seq >= op.ID.Seq && e.id.Seq < op.ID.Seq+int64(n) {
+			hit = append(hit, i)
+		}
+	}
+	if len(hit) < n {
+		return fmt.Errorf("%w: delete of %s/%d+%d", ErrUnknownID, op.ID.Client, op.ID.Seq, n)
+	}
+	for _, i := range hit {
+		d.elems[i].deleted = true
+	}
+	return nil
+}
+
+func (d *Doc) find(id ID) int {
+	for i, e := range d.elems {
+		if e.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Runs returns the whole document, deleted characters included, in order.
+func (d *Doc) Runs() []Run {
+	var out []Run
+	for i := 0; i < len(d.elems); {
+		first := d.elems[i]
+		j := i + 1
+		for j < len(d.elems) && d.elems[j].deleted == first.deleted &&
+			d.elems[j].id == (ID{Client: first.id.Client, Seq: first.id.Seq + int64(j-i)}) {
+			j++
+		}
+		run := Run{ID: first.id}
+		if first.deleted {
+			run.Deleted = j - i
+		} else {
+			var b strings.Builder
+			for _, e := range d.elems[i:j] {
+				b.WriteRune(e.r)
+			}
+			run.Text = b.String()
+		}
+		out = append(out, run)
+		i = j
+	}
+	return out
+}
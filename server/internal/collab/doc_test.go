@@ -0,0 +1,84 @@
+package collab
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestDoc_concurrentInserts(t *testing.T) {
+	// Two clients type at the same spot of "ac" without seeing each other's edit.
+	at := &ID{Seq: 1}
+	alice := []Op{{Type: "insert", ID: ID{Client: "alice", Seq: 3}, After: at, Text: "XY"}}
+	bob := []Op{
+		{Type: "insert", ID: ID{Client: "bob", Seq: 3}, After: at, Text: "b"},
+		{Type: "insert", ID: ID{Client: "bob", Seq: 4}, After: &ID{Client: "bob", Seq: 3}, Text: "!"},
+	}
+	var texts []string
+	for _, order := range [][]Op{slices.Concat(alice, bob), slices.Concat(bob, alice)} {
+		d := NewDoc("ac")
+		if n, err := d.Apply(order...); err != nil || n != len(order) {
+			t.Fatalf("apply: %d %v", n, err)
+		}
+		texts = append(texts, d.Text())
+	}
+	if texts[0] != texts[1] {
+		t.Fatalf("replicas diverged: %q vs %q", texts[0], texts[1])
+	}
+	// At equal seq the greater client goes first, and "!" stays right after the "b" it follows.
+	if texts[0] != "ab!XYc" {
+		t.Fatalf("text: %q", texts[0])
+	}
+}
+
+func TestDoc_delete(t *testing.T) {
+	d := NewDoc("hello")
+	ops := []Op{
+		{Type: "delete", ID: ID{Seq: 2}, Len: 3},
+		{Type: "insert", ID: ID{Client: "c", Seq: 6}, After: &ID{Seq: 3}, Text: "ipp"},
+	}
+	if _, err := d.Apply(ops...); err != nil {
+		t.Fatal(err)
+	}
+	if d.Text() != "hippo" || d.Clock() != 8 {
+		t.Fatalf("text %q clock %d", d.Text(), d.Clock())
+	}
+	// Replaying is harmless.
+	if _, err := d.Apply(ops...); err != nil || d.Text() != "hippo" {
+		t.Fatalf("replay: %q %v", d.Text(), err)
+	}
+	want := []Run{
+		{ID: ID{Seq: 1}, Text: "h"},
+		{ID: ID{Seq: 2}, Deleted: 2},
+		{ID: ID{Client: "c", Seq: 6}, Text: "ipp"},
+		{ID: ID{Seq: 4}, Deleted: 1},
+		{ID: ID{Seq: 5}, Text: "o"},
+	}
+	if got := d.Runs(); !slices.Equal(got, want) {
+		t.Fatalf("runs:\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDoc_errors(t *testing.T) {
+	d := NewDoc("ab")
+	n, err := d.Apply(
+		Op{Type: "insert", ID: ID{Client: "c", Seq: 3}, Text: "x"},
+		Op{Type: "insert", ID: ID{Client: "c", Seq: 4}, After: &ID{Client: "z", Seq: 9}, Text: "y"},
+	)
+	if n != 1 || !errors.Is(err, ErrUnknownID) || d.Text() != "xab" {
+		t.Fatalf("unknown after: %d %v %q", n, err, d.Text())
+	}
+	for _, op := range []Op{
+		{Type: "insert", ID: ID{Client: "c", Seq: 5}},
+		{Type: "insert", Text: "x"},
+		{Type: "delete", ID: ID{Seq: 1}, Len: 10},
+		{Type: "move"},
+	} {
+		if _, err := d.Apply(op); !errors.Is(err, ErrInvalidOp) {
+			t.Errorf("%+v: %v", op, err)
+		}
+	}
+	if _, err := d.Apply(Op{Type: "delete", ID: ID{Seq: 2}, Len: 2}); !errors.Is(err, ErrUnknownID) || d.Text() != "xab" {
+		t.Fatalf("partial delete must change nothing: %v %q", err, d.Text())
+	}
+}
@@ -0,0 +1,295 @@
+// Package config loads and validates the server configuration.
+//
+// Values are layered: built-in defaults, then the YAML file, then environment
+// variables (ApplyEnv), then command-line flags applied by main. Validate must
+// pass before the config is handed to the store, mirrors or api.NewHandler.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Listen string `yaml:"listen"`
+	// ShutdownTimeout bounds draining in-flight requests and the mirror queue on SIGTERM.
+	// Keep it under the container stop grace period (Docker's default is 10s).
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	TLS             TLS           `yaml:"tls"`
+	Auth            Auth          `yaml:"auth"`
+	Storage         Storage       `yaml:"storage"`
+	Mirrors         []Mirror      `yaml:"mirrors"`
+	Limits          Limits        `yaml:"limits"`
+	CORS            CORS          `yaml:"cors"`
+	Retention       Retention     `yaml:"retention"`
+	Collab          Collab        `yaml:"collab"`
+}
+
+// TLS serves HTTPS when Cert and Key are set; both files (and ClientCA) are reloaded when they change.
+// ClientAuth "optional" accepts a client certificate signed by ClientCA in place of Basic Auth;
+// "require" rejects handshakes without one. RedirectHTTP, if set, is a plain-HTTP listen
+// address that redirects every request to HTTPS.
+type TLS struct {
+	Cert         string `yaml:"cert"`
+	Key          string `yaml:"key"`
+	ClientCA     string `yaml:"client_ca"`
+	ClientAuth   string `yaml:"client_auth"`
+	RedirectHTTP string `yaml:"redirect_http"`
+}
+
+// Enabled reports whether the server listens with TLS.
+func (t TLS) Enabled() bool {
+	return t.Cert != ""
+}
+
+// Auth requires HTTP Basic credentials (or a verified client certificate, see TLS) on sync
+// routes when any users are listed or a client CA is configured.
+type Auth struct {
+	Users []User `yaml:"users"`
+}
+
+type User struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+}
+
+// Storage selects the store backend: "file" persists under Dir, "memory" keeps nothing across restarts.
+type Storage struct {
+	Backend string `yaml:"backend"`
+	Dir     string `yaml:"dir"`
+}
+
+// StorePath is the store snapshot file for the file backend.
+func (s Storage) StorePath() string {
+	return filepath.Join(s.Dir, "store.json")
+}
+
+// QueuePath is where undelivered mirror changes are saved on shutdown.
+func (s Storage) QueuePath() string {
+	return filepath.Join(s.Dir, "mirror-queue.json")
+}
+
+// Mirror is one remote the store is copied to. Only "github" is supported.
+type Mirror struct {
+	Type  string `yaml:"type"`
+	Owner string `yaml:"owner"`
+	Repo  string `yaml:"repo"`
+	Token string `yaml:"token"`
+}
+
+type Limits struct {
+	MaxPushBytes  int64 `yaml:"max_push_bytes"`
+	MaxPathLength int   `yaml:"max_path_length"`
+}
+
+// CORS lists allowed browser origins; "*" allows any.
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// Retention controls how long tombstones and earlier file versions are kept; zero keeps
+// them forever (versions up to the per-file cap). AckedTombstones also drops tombstones
+// every active registered device has acknowledged, whatever their age.
+type Retention struct {
+	Tombstones      time.Duration `yaml:"tombstones"`
+	History         time.Duration `yaml:"history"`
+	AckedTombstones bool          `yaml:"acked_tombstones"`
+}
+
+// Collab controls collaborative editing over /v1/collab: an open document is saved to the
+// store, and queued for the mirrors, every SaveInterval while it changes and when the last
+// viewer leaves.
+type Collab struct {
+	SaveInterval time.Duration `yaml:"save_interval"`
+}
+
+// Default returns the configuration used when no file is given: standalone, no auth, file store in ./data.
+func Default() *Config {
+	return &Config{
+		Listen:          ":8080",
+		ShutdownTimeout: 8 * time.Second,
+		Storage:         Storage{Backend: "file", Dir: "data"},
+		Limits: Limits{
+			MaxPushBytes:  10 << 20, // 10 MiB
+			MaxPathLength: 2048,
+		},
+		CORS:   CORS{AllowedOrigins: []string{"*"}},
+		Collab: Collab{SaveInterval: 30 * time.Second},
+	}
+}
+
+// Load reads the YAML file at path over the defaults. An empty path returns the defaults.
+// Unknown keys are rejected so typos don't silently fall back to defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides fields from environment variables:
+// PORT and FLUX_LISTEN set the listen address, FLUX_DATA_DIR the storage dir, and
+// FLUX_GIT_OWNER/FLUX_GIT_REPO/FLUX_GIT_TOKEN configure the first GitHub mirror
+// (FLUX_GIT_TOKEN alone fills the token of a mirror declared in the file).
+func (c *Config) ApplyEnv(getenv func(string) string) {
+	if p := getenv("PORT"); p != "" {
+		c.Listen = ":" + p
+	}
+	if l := getenv("FLUX_LISTEN"); l != "" {
+		c.Listen = l
+	}
+	if d := getenv("FLUX_DATA_DIR"); d != "" {
+		c.Storage.Dir = d
+	}
+	owner, repo, token := getenv("FLUX_GIT_OWNER"), getenv("FLUX_GIT_REPO"), getenv("FLUX_GIT_TOKEN")
+	if owner == "" && repo == "" && token == "" {
+		return
+	}
+	i := -1
+	for j, m := range c.Mirrors {
+		if m.Type == "github" {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		c.Mirrors = append(c.Mirrors, Mirror{Type: "github"})
+		i = len(c.Mirrors) - 1
+	}
+	m := &c.Mirrors[i]
+	if owner != "" {
+		m.Owner = owner
+	}
+	if repo != "" {
+		m.Repo = repo
+	}
+	if token != "" {
+		m.Token = token
+	}
+}
+
+// Validate reports every problem at once, each prefixed with the offending key.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if c.Listen == "" {
+		add("listen: must not be empty")
+	}
+	if c.ShutdownTimeout <= 0 {
+		add("shutdown_timeout: must be positive")
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		add("tls: cert and key must be set together")
+	}
+	switch c.TLS.ClientAuth {
+	case "":
+		if c.TLS.ClientCA != "" {
+			add("tls.client_auth: required with tls.client_ca (\"optional\" or \"require\")")
+		}
+	case "optional", "require":
+		if c.TLS.ClientCA == "" {
+			add("tls.client_ca: required when tls.client_auth is set")
+		}
+	default:
+		add("tls.client_auth: must be \"optional\" or \"require\", got %q", c.TLS.ClientAuth)
+	}
+	if !c.TLS.Enabled() && (c.TLS.ClientCA != "" || c.TLS.RedirectHTTP != "") {
+		add("tls: client_ca and redirect_http need cert and key")
+	}
+	seen := make(map[string]bool)
+	for i, u := range c.Auth.Users {
+		if u.Name == "" {
+			add("auth.users[%d].name: required", i)
+		} else if seen[u.Name] {
+			add("auth.users[%d].name: duplicate user %q", i, u.Name)
+		}
+		seen[u.Name] = true
+		if u.Password == "" {
+			add("auth.users[%d].password: required", i)
+		}
+	}
+	switch c.Storage.Backend {
+	case "file":
+		if c.Storage.Dir == "" {
+			add("storage.dir: required for the file backend")
+		}
+	case "memory":
+	default:
+		add("storage.backend: must be \"file\" or \"memory\", got %q", c.Storage.Backend)
+	}
+	for i, m := range c.Mirrors {
+		if m.Type != "github" {
+			add("mirrors[%d].type: must be \"github\", got %q", i, m.Type)
+			continue
+		}
+		if m.Owner == "" {
+			add("mirrors[%d].owner: required", i)
+		}
+		if m.Repo == "" {
+			add("mirrors[%d].repo: required", i)
+		}
+		if m.Token == "" {
+			add("mirrors[%d].token: required (or set FLUX_GIT_TOKEN)", i)
+		}
+	}
+	if c.Limits.MaxPushBytes <= 0 {
+		add("limits.max_push_bytes: must be positive")
+	}
+	if c.Limits.MaxPathLength <= 0 {
+		add("limits.max_path_length: must be positive")
+	}
+	if len(c.CORS.AllowedOrigins) == 0 {
+		add("cors.allowed_origins: must list at least one origin (use \"*\" for any)")
+	}
+	if c.Retention.Tombstones < 0 {
+		add("retention.tombstones: must not be negative")
+	}
+	if c.Retention.History < 0 {
+		add("retention.history: must not be negative")
+	}
+	if c.Collab.SaveInterval <= 0 {
+		add("collab.save_interval: must be positive")
+	}
+	return errors.Join(errs...)
+}
+
+// RestartRequired lists the keys that differ between c and next but only take effect
+// after a restart. Everything else is applied by a reload. (The TLS files themselves are
+// reloaded when their contents change; only changing the settings needs a restart.)
+func (c *Config) RestartRequired(next *Config) []string {
+	var keys []string
+	if c.Listen != next.Listen {
+		keys = append(keys, "listen")
+	}
+	if c.TLS != next.TLS {
+		keys = append(keys, "tls")
+	}
+	if c.Storage != next.Storage {
+		keys = append(keys, "storage")
+	}
+	return keys
+}
+
+// KeepRestartOnly copies c's values for the keys RestartRequired reports into next, so a
+// reloaded config still describes the listener, TLS settings and storage actually in use.
+func (c *Config) KeepRestartOnly(next *Config) {
+	next.Listen, next.TLS, next.Storage = c.Listen, c.TLS, c.Storage
+}
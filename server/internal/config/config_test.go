@@ -0,0 +1,198 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "flux.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func TestLoad_defaults(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults should validate: %v", err)
+	}
+	if cfg.Listen != ":8080" || cfg.Storage.StorePath() != filepath.Join("data", "store.json") || len(cfg.Mirrors) != 0 {
+		t.Fatalf("defaults: %+v", cfg)
+	}
+}
+
+func TestLoad_file(t *testing.T) {
+	path := writeFile(t, `
+listen: ":9000"
+auth:
+  users:
+    - name: shaun
+      password: secret
+storage:
+  backend: memory
+mirrors:
+  - type: github
+    owner: o
+    repo: r
+    token: tk
+limits:
+  max_push_bytes: 1024
+cors:
+  allowed_origins: ["app://obsidian.md"]
+retention:
+  tombstones: 720h
+  history: 2160h
+  acked_tombstones: true
+collab:
+  save_interval: 5s
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if cfg.Listen != ":9000" || cfg.Storage.Backend != "memory" || cfg.Limits.MaxPushBytes != 1024 {
+		t.Fatalf("loaded: %+v", cfg)
+	}
+	if cfg.Limits.MaxPathLength != 2048 {
+		t.Errorf("unset limit should keep default, got %d", cfg.Limits.MaxPathLength)
+	}
+	if len(cfg.Auth.Users) != 1 || cfg.Auth.Users[0].Name != "shaun" {
+		t.Errorf("users: %+v", cfg.Auth.Users)
+	}
+	if cfg.Retention.Tombstones != 720*time.Hour || cfg.Retention.History != 2160*time.Hour || !cfg.Retention.AckedTombstones {
+		t.Errorf("retention: %+v", cfg.Retention)
+	}
+	if cfg.Collab.SaveInterval != 5*time.Second {
+		t.Errorf("collab: %+v", cfg.Collab)
+	}
+}
+
+func TestLoad_errors(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+	if _, err := Load(writeFile(t, "listne: \":1\"\n")); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	cfg := Default()
+	cfg.ApplyEnv(env(map[string]string{
+		"PORT":           "9090",
+		"FLUX_DATA_DIR":  "/var/lib/flux",
+		"FLUX_GIT_OWNER": "o",
+		"FLUX_GIT_REPO":  "r",
+		"FLUX_GIT_TOKEN": "tk",
+	}))
+	if cfg.Listen != ":9090" || cfg.Storage.Dir != "/var/lib/flux" {
+		t.Fatalf("env overrides: %+v", cfg)
+	}
+	if len(cfg.Mirrors) != 1 || cfg.Mirrors[0] != (Mirror{Type: "github", Owner: "o", Repo: "r", Token: "tk"}) {
+		t.Fatalf("env mirror: %+v", cfg.Mirrors)
+	}
+
+	cfg.ApplyEnv(env(map[string]string{"FLUX_LISTEN": "127.0.0.1:1", "FLUX_GIT_TOKEN": "rotated"}))
+	if cfg.Listen != "127.0.0.1:1" || len(cfg.Mirrors) != 1 || cfg.Mirrors[0].Token != "rotated" || cfg.Mirrors[0].Owner != "o" {
+		t.Fatalf("token-only override: %+v", cfg)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Listen = ""
+	cfg.ShutdownTimeout = 0
+	cfg.TLS.Cert = "cert.pem"
+	cfg.Auth.Users = []User{{Name: "a", Password: "p"}, {Name: "a"}, {}}
+	cfg.Storage.Backend = "s3"
+	cfg.Mirrors = []Mirror{{Type: "gitlab"}, {Type: "github"}}
+	cfg.Limits = Limits{}
+	cfg.CORS.AllowedOrigins = nil
+	cfg.Retention.Tombstones = -time.Hour
+	cfg.Retention.History = -time.Hour
+	cfg.Collab.SaveInterval = 0
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		"listen:", "shutdown_timeout:", "tls:", "auth.users[1].name: duplicate", "auth.users[1].password", "auth.users[2].name: required",
+		"storage.backend", "mirrors[0].type", "mirrors[1].owner", "mirrors[1].repo", "mirrors[1].token",
+		"limits.max_push_bytes", "limits.max_path_length", "cors.allowed_origins", "retention.tombstones",
+		"retention.history", "collab.save_interval",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+
+	cfg = Default()
+	cfg.Storage.Dir = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "storage.dir") {
+		t.Errorf("file backend without dir: %v", err)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := Default()
+	next := Default()
+	next.Auth.Users = []User{{Name: "u", Password: "p"}}
+	next.Limits.MaxPushBytes = 1
+	next.CORS.AllowedOrigins = []string{"app://obsidian.md"}
+	if keys := old.RestartRequired(next); len(keys) != 0 {
+		t.Fatalf("reloadable changes reported as restart-only: %v", keys)
+	}
+	next.Listen = ":1"
+	next.Storage.Dir = "elsewhere"
+	keys := old.RestartRequired(next)
+	if len(keys) != 2 || keys[0] != "listen" || keys[1] != "storage" {
+		t.Fatalf("RestartRequired: %v", keys)
+	}
+	old.KeepRestartOnly(next)
+	if keys := old.RestartRequired(next); len(keys) != 0 || next.Limits.MaxPushBytes != 1 {
+		t.Fatalf("after KeepRestartOnly: %v, limits %+v", keys, next.Limits)
+	}
+}
+
+func TestValidate_tls(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		tls  TLS
+		want string
+	}{
+		{"ok", TLS{Cert: "c", Key: "k", ClientCA: "ca", ClientAuth: "require", RedirectHTTP: ":80"}, ""},
+		{"ca without mode", TLS{Cert: "c", Key: "k", ClientCA: "ca"}, "tls.client_auth: required"},
+		{"mode without ca", TLS{Cert: "c", Key: "k", ClientAuth: "optional"}, "tls.client_ca: required"},
+		{"bad mode", TLS{Cert: "c", Key: "k", ClientCA: "ca", ClientAuth: "always"}, "tls.client_auth: must be"},
+		{"redirect without cert", TLS{RedirectHTTP: ":80"}, "need cert and key"},
+	} {
+		cfg := Default()
+		cfg.TLS = tt.tls
+		err := cfg.Validate()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
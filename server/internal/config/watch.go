@@ -0,0 +1,31 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch polls path every interval and calls onChange when its modification time or size changes.
+// It returns when ctx is done. Polling avoids a file-notification dependency and copes with
+// editors and config managers that replace the file instead of writing it in place.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last, _ := os.Stat(path)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last == nil || !fi.ModTime().Equal(last.ModTime()) || fi.Size() != last.Size() {
+			last = fi
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flux.yaml")
+	if err := os.WriteFile(path, []byte("listen: \":1\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, path, 5*time.Millisecond, func() { changed <- struct{}{} })
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-changed:
+		t.Fatal("onChange called without a change")
+	default:
+	}
+
+	if err := os.WriteFile(path, []byte("listen: \":22\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("onChange not called after write")
+	}
+	cancel()
+	<-done
+}
@@ -2,10 +2,13 @@ package github
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/google/go-github/v66/github"
 	"github.com/shaun/flux/server/internal/sync"
@@ -25,11 +28,6 @@ func NewClientWithHTTPClient(hc *http.Client) *Client {
 	return &Client{hc: hc}
 }
 
-func isConflict(err error) bool {
-	var ghErr *github.ErrorResponse
-	return errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusConflict
-}
-
 // FetchFromRepo recursively fetches all .md files from the repo and returns them.
 // Used to seed the store on startup.
 func (c *Client) FetchFromRepo(ctx context.Context, token, owner, repo string) ([]*sync.File, error) {
@@ -86,6 +84,9 @@ func (c *Client) FetchFromRepo(ctx context.Context, token, owner, repo string) (
 				if err != nil {
 					return err
 				}
+				if !utf8.ValidString(content) {
+					continue // only UTF-8 text is synced
+				}
 				out = append(out, &sync.File{Path: p, Content: content, Hash: sync.ContentHash(content)})
 			}
 		}
@@ -97,8 +98,12 @@ func (c *Client) FetchFromRepo(ctx context.Context, token, owner, repo string) (
 	return out, nil
 }
 
+// Sync writes files and removes deleted paths on the main branch in a single commit, using the
+// Git Data API (tree, commit, ref). Files whose content is unchanged and deletes of paths that
+// aren't in the repo are skipped; if nothing is left no commit is made. If the branch moved
+// while the commit was being built, the commit is rebuilt on the new head.
 func (c *Client) Sync(ctx context.Context, token, owner, repo string, files []*sync.File, deleted []string) error {
-	if token == "" {
+	if token == "" || (len(files) == 0 && len(deleted) == 0) {
 		return nil
 	}
 	var httpClient *http.Client
@@ -111,70 +116,182 @@ func (c *Client) Sync(ctx context.Context, token, owner, repo string, files []*s
 	client := github.NewClient(httpClient)
 	branch := "main"
 
-	getOpts := &github.RepositoryContentGetOptions{Ref: branch}
-	for _, path := range deleted {
-		existing, _, _, err := client.Repositories.GetContents(ctx, owner, repo, path, getOpts)
-		if err != nil {
-			var ghErr *github.ErrorResponse
-			if errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == 404 {
-				continue
-			}
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		err = commitChanges(ctx, client, owner, repo, branch, files, deleted)
+		if err == nil || !isStaleRef(err) {
 			return err
 		}
-		for retry := 0; retry < 2; retry++ {
-			_, _, err = client.Repositories.DeleteFile(ctx, owner, repo, path, &github.RepositoryContentFileOptions{
-				Message: github.String(fmt.Sprintf("Flux: delete %s", path)),
-				SHA:     existing.SHA,
-				Branch:  &branch,
-			})
-			if err == nil {
-				break
-			}
-			if !isConflict(err) {
-				return err
-			}
-			var getErr error
-			existing, _, _, getErr = client.Repositories.GetContents(ctx, owner, repo, path, getOpts)
-			if getErr != nil {
-				return err
+	}
+	return err
+}
+
+// commitChanges builds one commit on top of the branch head and fast-forwards the branch to it.
+func commitChanges(ctx context.Context, client *github.Client, owner, repo, branch string, files []*sync.File, deleted []string) error {
+	ref, _, err := client.Git.GetRef(ctx, owner, repo, "heads/"+branch)
+	if err != nil {
+		if !isEmptyRepo(err) {
+			return err
+		}
+		if len(files) == 0 {
+			return nil // nothing to delete from an empty repo
+		}
+		return bootstrap(ctx, client, owner, repo, branch, files, deleted)
+	}
+	head, _, err := client.Git.GetCommit(ctx, owner, repo, ref.GetObject().GetSHA())
+	if err != nil {
+		return err
+	}
+	baseTree := head.GetTree().GetSHA()
+	tree, _, err := client.Git.GetTree(ctx, owner, repo, baseTree, true)
+	if err != nil {
+		return err
+	}
+	// existing maps path -> blob SHA. A truncated listing (very large repos) can't prove a
+	// path is absent or unchanged, so then every change is sent as-is.
+	var existing map[string]string
+	if !tree.GetTruncated() {
+		existing = make(map[string]string, len(tree.Entries))
+		for _, e := range tree.Entries {
+			if e.GetType() == "blob" {
+				existing[e.GetPath()] = e.GetSHA()
 			}
 		}
 	}
 
+	// A deleted path whose blob reappears at a new path is a rename: the new entry reuses the
+	// blob, so git records a pure rename and no content is uploaded.
+	deletedBlobs := make(map[string]string)
+	for _, path := range deleted {
+		if sha, ok := existing[path]; ok {
+			deletedBlobs[sha] = path
+		}
+	}
+
+	var entries []*github.TreeEntry
+	var synced, removed []string
+	var renamed [][2]string
 	for _, f := range files {
-		opts := &github.RepositoryContentFileOptions{
-			Message: github.String(fmt.Sprintf("Flux: sync %s", f.Path)),
-			Content: []byte(f.Content),
-			Branch:  &branch,
+		sha := blobSHA(f.Content)
+		if cur, ok := existing[f.Path]; ok && cur == sha {
+			continue
 		}
-		existing, _, _, err := client.Repositories.GetContents(ctx, owner, repo, f.Path, &github.RepositoryContentGetOptions{Ref: branch})
-		if err != nil {
-			var ghErr *github.ErrorResponse
-			if errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == 404 {
-				_, _, err = client.Repositories.CreateFile(ctx, owner, repo, f.Path, opts)
-				if err != nil {
-					return err
-				}
-				continue
-			}
-			return err
+		entry := &github.TreeEntry{
+			Path: github.String(f.Path),
+			Mode: github.String("100644"),
+			Type: github.String("blob"),
 		}
-		opts.SHA = existing.SHA
-		_, _, err = client.Repositories.UpdateFile(ctx, owner, repo, f.Path, opts)
-		if err != nil {
-			if isConflict(err) {
-				// Race: file changed since we fetched. Re-fetch SHA and retry once.
-				existing, _, _, err2 := client.Repositories.GetContents(ctx, owner, repo, f.Path, &github.RepositoryContentGetOptions{Ref: branch})
-				if err2 != nil {
-					return err
-				}
-				opts.SHA = existing.SHA
-				_, _, err = client.Repositories.UpdateFile(ctx, owner, repo, f.Path, opts)
-			}
-			if err != nil {
-				return err
-			}
+		if from, ok := deletedBlobs[sha]; ok {
+			entry.SHA = github.String(sha)
+			renamed = append(renamed, [2]string{from, f.Path})
+			delete(deletedBlobs, sha)
+		} else {
+			entry.Content = github.String(f.Content)
+			synced = append(synced, f.Path)
 		}
+		entries = append(entries, entry)
+	}
+	isRenameSource := make(map[string]bool, len(renamed))
+	for _, r := range renamed {
+		isRenameSource[r[0]] = true
+	}
+	for _, path := range deleted {
+		if _, ok := existing[path]; existing != nil && !ok {
+			continue
+		}
+		// A nil SHA and nil Content removes the path from the base tree.
+		entries = append(entries, &github.TreeEntry{
+			Path: github.String(path),
+			Mode: github.String("100644"),
+			Type: github.String("blob"),
+		})
+		if !isRenameSource[path] {
+			removed = append(removed, path)
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	newTree, _, err := client.Git.CreateTree(ctx, owner, repo, baseTree, entries)
+	if err != nil {
+		return err
+	}
+	commit, _, err := client.Git.CreateCommit(ctx, owner, repo, &github.Commit{
+		Message: github.String(commitMessage(synced, removed, renamed)),
+		Tree:    &github.Tree{SHA: newTree.SHA},
+		Parents: []*github.Commit{{SHA: head.SHA}},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	_, _, err = client.Git.UpdateRef(ctx, owner, repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: commit.SHA},
+	}, false)
+	return err
+}
+
+// bootstrap creates the branch in an empty repo with the first file (the Git Data API can't
+// write to a repo with no commits), then commits the rest on top.
+func bootstrap(ctx context.Context, client *github.Client, owner, repo, branch string, files []*sync.File, deleted []string) error {
+	first := files[0]
+	_, _, err := client.Repositories.CreateFile(ctx, owner, repo, first.Path, &github.RepositoryContentFileOptions{
+		Message: github.String(fmt.Sprintf("Flux: sync %s", first.Path)),
+		Content: []byte(first.Content),
+		Branch:  &branch,
+	})
+	if err != nil {
+		return err
+	}
+	if len(files) == 1 && len(deleted) == 0 {
+		return nil
+	}
+	return commitChanges(ctx, client, owner, repo, branch, files[1:], deleted)
+}
+
+func commitMessage(synced, removed []string, renamed [][2]string) string {
+	switch {
+	case len(synced) == 1 && len(removed) == 0 && len(renamed) == 0:
+		return fmt.Sprintf("Flux: sync %s", synced[0])
+	case len(synced) == 0 && len(removed) == 1 && len(renamed) == 0:
+		return fmt.Sprintf("Flux: delete %s", removed[0])
+	case len(synced) == 0 && len(removed) == 0 && len(renamed) == 1:
+		return fmt.Sprintf("Flux: move %s -> %s", renamed[0][0], renamed[0][1])
+	}
+	var parts []string
+	if len(renamed) > 0 {
+		parts = append(parts, fmt.Sprintf("move %d files", len(renamed)))
+	}
+	if len(synced) > 0 {
+		parts = append(parts, fmt.Sprintf("sync %d files", len(synced)))
+	}
+	if len(removed) > 0 {
+		parts = append(parts, fmt.Sprintf("delete %d files", len(removed)))
+	}
+	return "Flux: " + strings.Join(parts, ", ")
+}
+
+// blobSHA is the git object ID of content, to detect files the repo already has.
+func blobSHA(content string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write([]byte(content))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isStaleRef reports a rejected non-fast-forward ref update: the branch moved under us.
+func isStaleRef(err error) bool {
+	var ghErr *github.ErrorResponse
+	return errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusUnprocessableEntity &&
+		ghErr.Response.Request != nil && ghErr.Response.Request.Method == http.MethodPatch
+}
+
+// isEmptyRepo reports a missing branch: 404 for no such ref, 409 for a repo with no commits.
+func isEmptyRepo(err error) bool {
+	var ghErr *github.ErrorResponse
+	if !errors.As(err, &ghErr) || ghErr.Response == nil {
+		return false
 	}
-	return nil
+	return ghErr.Response.StatusCode == http.StatusNotFound || ghErr.Response.StatusCode == http.StatusConflict
 }
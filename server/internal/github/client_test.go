@@ -50,7 +50,7 @@ func TestClient_FetchFromRepo(t *testing.T) {
 			json.NewEncoder(w).Encode(map[string]any{
 				"type": "file", "path": "Flux/note.md",
 				"encoding": "base64",
-				"content": "IyBoZWxsbwo=",
+				"content":  "IyBoZWxsbwo=",
 			})
 			return
 		}
@@ -83,115 +83,206 @@ func TestClient_Sync_emptyToken(t *testing.T) {
 	}
 }
 
-func TestClient_Sync_createAndUpdate(t *testing.T) {
-	// Fake GitHub API: GET 404 -> create; GET 200 with body -> update
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			w.WriteHeader(http.StatusNotFound)
-		case http.MethodPut:
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"content":{"sha":"abc"}}`))
-		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
-		}
-	}))
-	defer server.Close()
+// fakeGitData is a minimal Git Data API: one branch whose tree is a flat map of path -> blob SHA.
+type fakeGitData struct {
+	empty    bool              // repo has no commits yet
+	tree     map[string]string // path -> blob SHA at head
+	stale    int               // reject this many ref updates as non-fast-forward
+	trees    [][]map[string]any
+	messages []string
+	refPuts  int
+	created  []string // paths created via the Contents API
+}
 
-	client := &http.Client{Transport: &rewriteTransport{baseURL: server.URL}}
-	c := NewClientWithHTTPClient(client)
-	files := []*sync.File{{Path: "a.md", Content: "hi", Hash: "h1"}}
-	err := c.Sync(context.Background(), "token", "o", "r", files, nil)
-	if err != nil {
-		t.Fatalf("Sync: %v", err)
+func (f *fakeGitData) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/repos/o/r/")
+	switch {
+	case r.Method == http.MethodGet && p == "git/ref/heads/main":
+		if f.empty {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"message":"Git Repository is empty."}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ref": "refs/heads/main", "object": map[string]any{"sha": "head"}})
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "git/commits/"):
+		json.NewEncoder(w).Encode(map[string]any{"sha": "head", "tree": map[string]any{"sha": "base"}})
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "git/trees/"):
+		var entries []map[string]any
+		for path, sha := range f.tree {
+			entries = append(entries, map[string]any{"path": path, "type": "blob", "sha": sha})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"sha": "base", "tree": entries, "truncated": false})
+	case r.Method == http.MethodPost && p == "git/trees":
+		var body struct {
+			Tree []map[string]any `json:"tree"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.trees = append(f.trees, body.Tree)
+		json.NewEncoder(w).Encode(map[string]any{"sha": "newtree"})
+	case r.Method == http.MethodPost && p == "git/commits":
+		var body struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.messages = append(f.messages, body.Message)
+		json.NewEncoder(w).Encode(map[string]any{"sha": "newcommit"})
+	case r.Method == http.MethodPatch && p == "git/refs/heads/main":
+		f.refPuts++
+		if f.stale > 0 {
+			f.stale--
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"message":"Update is not a fast forward"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"ref": "refs/heads/main", "object": map[string]any{"sha": "newcommit"}})
+	case r.Method == http.MethodPut && strings.HasPrefix(p, "contents/"):
+		f.created = append(f.created, strings.TrimPrefix(p, "contents/"))
+		f.empty = false
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"content":{"sha":"abc"}}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
 	}
 }
 
-func TestClient_Sync_updateAndDelete(t *testing.T) {
-	var gotUpdate, gotDelete bool
+func (f *fakeGitData) client(t *testing.T) *Client {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return NewClientWithHTTPClient(&http.Client{Transport: &rewriteTransport{baseURL: server.URL}})
+}
 
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			// Return existing content so UpdateFile/DeleteFile paths are taken.
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"sha":"abc"}`))
-		case http.MethodPut:
-			gotUpdate = true
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"content":{"sha":"new"}}`))
-		case http.MethodDelete:
-			if strings.Contains(r.URL.Path, "gone.md") {
-				gotDelete = true
-			}
-			w.WriteHeader(http.StatusOK)
-		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
+func TestClient_Sync_singleCommit(t *testing.T) {
+	fake := &fakeGitData{tree: map[string]string{
+		"same.md":         blobSHA("same"),
+		"changed.md":      blobSHA("old"),
+		"Folder/a.md":     "sha-a",
+		"Folder/sub/b.md": "sha-b",
+	}}
+	files := []*sync.File{
+		{Path: "same.md", Content: "same"},
+		{Path: "changed.md", Content: "new"},
+		{Path: "added.md", Content: "hi"},
+	}
+	deleted := []string{"Folder/a.md", "Folder/sub/b.md", "never-existed.md"}
+	if err := fake.client(t).Sync(context.Background(), "token", "o", "r", files, deleted); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(fake.trees) != 1 || len(fake.messages) != 1 || fake.refPuts != 1 {
+		t.Fatalf("expected one tree/commit/ref update, got %d/%d/%d", len(fake.trees), len(fake.messages), fake.refPuts)
+	}
+	got := map[string]any{}
+	for _, e := range fake.trees[0] {
+		if content, ok := e["content"]; ok {
+			got[e["path"].(string)] = content
+		} else if sha, ok := e["sha"]; ok && sha == nil {
+			got[e["path"].(string)] = nil
 		}
-	}))
-	defer server.Close()
+	}
+	want := map[string]any{"changed.md": "new", "added.md": "hi", "Folder/a.md": nil, "Folder/sub/b.md": nil}
+	if len(got) != len(want) {
+		t.Fatalf("tree entries %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if gv, ok := got[k]; !ok || gv != v {
+			t.Errorf("entry %s = %v, want %v", k, gv, v)
+		}
+	}
+	if fake.messages[0] != "Flux: sync 2 files, delete 2 files" {
+		t.Errorf("message %q", fake.messages[0])
+	}
+}
 
-	client := &http.Client{Transport: &rewriteTransport{baseURL: server.URL}}
-	c := NewClientWithHTTPClient(client)
-	files := []*sync.File{{Path: "existing.md", Content: "hi", Hash: "h1"}}
-	deleted := []string{"gone.md"}
+func TestClient_Sync_nothingChanged(t *testing.T) {
+	fake := &fakeGitData{tree: map[string]string{"a.md": blobSHA("a")}}
+	files := []*sync.File{{Path: "a.md", Content: "a"}}
+	if err := fake.client(t).Sync(context.Background(), "token", "o", "r", files, []string{"gone.md"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(fake.messages) != 0 {
+		t.Fatalf("no commit expected, got %v", fake.messages)
+	}
+}
 
-	if err := c.Sync(context.Background(), "token", "o", "r", files, deleted); err != nil {
-		t.Fatalf("Sync update+delete: %v", err)
+func TestClient_Sync_retryStaleRef(t *testing.T) {
+	fake := &fakeGitData{tree: map[string]string{}, stale: 1}
+	files := []*sync.File{{Path: "x.md", Content: "init"}}
+	if err := fake.client(t).Sync(context.Background(), "token", "o", "r", files, nil); err != nil {
+		t.Fatalf("Sync stale ref retry: %v", err)
 	}
-	if !gotUpdate {
-		t.Fatalf("expected update call")
+	if fake.refPuts != 2 || len(fake.messages) != 2 || fake.messages[1] != "Flux: sync x.md" {
+		t.Fatalf("expected rebuilt commit, refPuts=%d messages=%v", fake.refPuts, fake.messages)
 	}
-	if !gotDelete {
-		t.Fatalf("expected delete call for gone.md")
+}
+
+func TestClient_Sync_staleRefGivesUp(t *testing.T) {
+	fake := &fakeGitData{tree: map[string]string{}, stale: 5}
+	files := []*sync.File{{Path: "x.md", Content: "init"}}
+	if err := fake.client(t).Sync(context.Background(), "token", "o", "r", files, nil); err == nil {
+		t.Fatal("expected error after repeated stale ref")
+	}
+	if fake.refPuts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", fake.refPuts)
 	}
 }
 
-func TestClient_Sync_retryOn409(t *testing.T) {
-	// First UpdateFile returns 409, retry with fresh SHA succeeds
-	updateCalls := 0
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"sha":"abc","content":"aW5pdA=="}`))
-			return
-		}
-		if r.Method == http.MethodPut {
-			updateCalls++
-			if updateCalls == 1 {
-				w.WriteHeader(http.StatusConflict)
-				w.Write([]byte(`{"message":"update does not match sha"}`))
-				return
-			}
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"content":{"sha":"new"}}`))
-		}
-	}))
-	defer server.Close()
+func TestClient_Sync_emptyRepo(t *testing.T) {
+	fake := &fakeGitData{empty: true, tree: map[string]string{}}
+	files := []*sync.File{{Path: "first.md", Content: "1"}, {Path: "second.md", Content: "2"}}
+	if err := fake.client(t).Sync(context.Background(), "token", "o", "r", files, nil); err != nil {
+		t.Fatalf("Sync empty repo: %v", err)
+	}
+	if len(fake.created) != 1 || fake.created[0] != "first.md" {
+		t.Fatalf("first file should bootstrap the branch: %v", fake.created)
+	}
+	if len(fake.messages) != 1 || fake.messages[0] != "Flux: sync second.md" {
+		t.Fatalf("rest should be one commit: %v", fake.messages)
+	}
+}
 
-	client := &http.Client{Transport: &rewriteTransport{baseURL: server.URL}}
-	c := NewClientWithHTTPClient(client)
-	files := []*sync.File{{Path: "x.md", Content: "init", Hash: "h1"}}
-	if err := c.Sync(context.Background(), "token", "o", "r", files, nil); err != nil {
-		t.Fatalf("Sync 409 retry: %v", err)
+func TestClient_Sync_emptyRepoDeletesOnly(t *testing.T) {
+	fake := &fakeGitData{empty: true, tree: map[string]string{}}
+	if err := fake.client(t).Sync(context.Background(), "token", "o", "r", nil, []string{"gone.md"}); err != nil {
+		t.Fatalf("deletes on an empty repo: %v", err)
 	}
-	if updateCalls != 2 {
-		t.Fatalf("expected 2 update calls (retry), got %d", updateCalls)
+	if len(fake.created) != 0 || len(fake.messages) != 0 {
+		t.Fatalf("no commit expected: created %v, messages %v", fake.created, fake.messages)
 	}
 }
 
-func TestClient_Sync_deleteSkip404(t *testing.T) {
-	// Deleted path returns 404 -> skip (no error)
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotFound)
-	}))
-	defer server.Close()
+func TestClient_Sync_rename(t *testing.T) {
+	fake := &fakeGitData{tree: map[string]string{"Old/a.md": blobSHA("a"), "Old/b.md": blobSHA("b")}}
+	files := []*sync.File{{Path: "New/a.md", Content: "a"}, {Path: "New/b.md", Content: "b"}}
+	if err := fake.client(t).Sync(context.Background(), "token", "o", "r", files, []string{"Old/a.md", "Old/b.md"}); err != nil {
+		t.Fatalf("Sync rename: %v", err)
+	}
+	if len(fake.messages) != 1 || fake.messages[0] != "Flux: move 2 files" {
+		t.Fatalf("messages: %v", fake.messages)
+	}
+	for _, e := range fake.trees[0] {
+		if _, hasContent := e["content"]; hasContent {
+			t.Errorf("renamed blob should be reused, not re-uploaded: %v", e)
+		}
+		if strings.HasPrefix(e["path"].(string), "New/") && e["sha"] == nil {
+			t.Errorf("new path should reference the existing blob: %v", e)
+		}
+	}
+}
 
-	client := &http.Client{Transport: &rewriteTransport{baseURL: server.URL}}
-	c := NewClientWithHTTPClient(client)
-	err := c.Sync(context.Background(), "token", "o", "r", nil, []string{"gone.md"})
-	if err != nil {
-		t.Fatalf("Sync delete 404: %v", err)
+func TestCommitMessage(t *testing.T) {
+	for _, tt := range []struct {
+		synced, removed []string
+		renamed         [][2]string
+		want            string
+	}{
+		{[]string{"a.md"}, nil, nil, "Flux: sync a.md"},
+		{nil, []string{"a.md"}, nil, "Flux: delete a.md"},
+		{nil, []string{"a.md", "b.md"}, nil, "Flux: delete 2 files"},
+		{nil, nil, [][2]string{{"a.md", "b.md"}}, "Flux: move a.md -> b.md"},
+		{[]string{"c.md"}, nil, [][2]string{{"a.md", "b.md"}}, "Flux: move 1 files, sync 1 files"},
+	} {
+		if got := commitMessage(tt.synced, tt.removed, tt.renamed); got != tt.want {
+			t.Errorf("commitMessage(%v, %v, %v) = %q, want %q", tt.synced, tt.removed, tt.renamed, got, tt.want)
+		}
 	}
 }
 
@@ -214,3 +305,18 @@ func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	req.URL.Host = u.Host
 	return t.base.RoundTrip(req)
 }
+
+func TestRemote(t *testing.T) {
+	r := NewRemote(NewClient(), "o", "r", "")
+	if r.Name() != "github:o/r" {
+		t.Fatalf("Name: %q", r.Name())
+	}
+	// Empty token short-circuits both directions.
+	if err := r.Sync(context.Background(), nil, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	files, err := r.Fetch(context.Background())
+	if err != nil || files != nil {
+		t.Fatalf("Fetch: %v %v", files, err)
+	}
+}
@@ -0,0 +1,33 @@
+package github
+
+import (
+	"context"
+
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// Remote mirrors the store to one GitHub repository. Implements mirror.Mirror.
+type Remote struct {
+	client *Client
+	owner  string
+	repo   string
+	token  string
+}
+
+func NewRemote(client *Client, owner, repo, token string) *Remote {
+	return &Remote{client: client, owner: owner, repo: repo, token: token}
+}
+
+// Name identifies the remote in logs and status output; it never includes the token.
+func (r *Remote) Name() string {
+	return "github:" + r.owner + "/" + r.repo
+}
+
+func (r *Remote) Sync(ctx context.Context, files []*sync.File, deleted []string) error {
+	return r.client.Sync(ctx, r.token, r.owner, r.repo, files, deleted)
+}
+
+// Fetch returns the repo's notes, used to seed an empty store on startup.
+func (r *Remote) Fetch(ctx context.Context) ([]*sync.File, error) {
+	return r.client.FetchFromRepo(ctx, r.token, r.owner, r.repo)
+}
@@ -0,0 +1,237 @@
+// Package mirror copies the store to optional external remotes (e.g. a GitHub repo).
+// A server with no mirrors runs standalone on its own store.
+//
+// Changes are queued per mirror as paths and delivered by Flush, which reads the
+// current content from the store. A path stays queued until its mirror accepts it,
+// so a failed or interrupted sync is retried by the next Flush, and the queue can
+// be saved across restarts with SaveQueue/LoadQueue.
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	gosync "sync"
+	"time"
+
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+// Mirror receives changed files and deleted paths. Implemented by *github.Remote.
+type Mirror interface {
+	Name() string
+	Sync(ctx context.Context, files []*sync.File, deleted []string) error
+}
+
+// Status is the outcome of the most recent sync to one mirror.
+type Status struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	LastSyncAt int64  `json:"lastSyncAt,omitempty"`
+	Pending    int    `json:"pending"`
+}
+
+type entry struct {
+	m       Mirror
+	status  Status
+	pending map[string]uint64 // path -> generation of its latest Enqueue
+}
+
+// Set is the long-lived group of configured mirrors and their queues. Replace swaps the
+// mirrors on config reload without losing the queue of any mirror that is kept.
+type Set struct {
+	mu      gosync.Mutex
+	entries []*entry
+	flushMu gosync.Mutex // one Flush at a time so a path is never sent twice concurrently
+	gen     uint64       // bumped by every Enqueue, so Flush can tell a path was queued again
+}
+
+func NewSet(mirrors ...Mirror) *Set {
+	s := &Set{}
+	s.Replace(mirrors...)
+	return s
+}
+
+// Replace installs mirrors, keeping status and queued paths of those whose name is unchanged.
+func (s *Set) Replace(mirrors ...Mirror) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]*entry, len(mirrors))
+	for i, m := range mirrors {
+		e := &entry{m: m, status: Status{Name: m.Name(), OK: true}, pending: make(map[string]uint64)}
+		for _, old := range s.entries {
+			if old.m.Name() == m.Name() {
+				e.status, e.pending = old.status, old.pending
+			}
+		}
+		entries[i] = e
+	}
+	s.entries = entries
+}
+
+// Len reports how many mirrors are configured; zero means standalone.
+func (s *Set) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Names lists the configured mirrors in configuration order.
+func (s *Set) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, len(s.entries))
+	for i, e := range s.entries {
+		names[i] = e.m.Name()
+	}
+	return names
+}
+
+// Status returns the last sync outcome and queue length of every mirror in configuration order.
+func (s *Set) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Status, len(s.entries))
+	for i, e := range s.entries {
+		out[i] = e.status
+		out[i].Pending = len(e.pending)
+	}
+	return out
+}
+
+// Enqueue marks paths as changed (upserted or deleted) for every mirror.
+func (s *Set) Enqueue(paths ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen++
+	for _, e := range s.entries {
+		for _, p := range paths {
+			e.pending[p] = s.gen
+		}
+	}
+}
+
+// Pending reports the number of queued paths summed over all mirrors.
+func (s *Set) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, e := range s.entries {
+		n += len(e.pending)
+	}
+	return n
+}
+
+// Flush delivers every mirror's queued paths, reading their current state from store.
+// Every mirror is tried even if an earlier one fails; errors are joined. Paths stay
+// queued for a mirror until it accepts them, and a path enqueued again while its sync
+// runs stays queued for the next Flush, since the mirror may have read the older state.
+func (s *Set) Flush(ctx context.Context, store *sync.Store) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	type batch struct {
+		e     *entry
+		paths []string
+		gens  map[string]uint64
+	}
+	var batches []batch
+	for _, e := range s.entries {
+		if len(e.pending) == 0 {
+			continue
+		}
+		paths := make([]string, 0, len(e.pending))
+		for p := range e.pending {
+			paths = append(paths, p)
+		}
+		slices.Sort(paths)
+		batches = append(batches, batch{e, paths, maps.Clone(e.pending)})
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, b := range batches {
+		var files []*sync.File
+		var deleted []string
+		for _, p := range b.paths {
+			if f, ok := store.Get(p); ok {
+				files = append(files, f)
+			} else {
+				deleted = append(deleted, p)
+			}
+		}
+		err := b.e.m.Sync(ctx, files, deleted)
+		s.mu.Lock()
+		b.e.status.OK = err == nil
+		if err == nil {
+			b.e.status.LastSyncAt = time.Now().UnixMilli()
+			for _, p := range b.paths {
+				if b.e.pending[p] == b.gens[p] {
+					delete(b.e.pending, p)
+				}
+			}
+		}
+		s.mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.e.m.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SaveQueue writes the queued paths of every mirror to path, or removes path when nothing is queued.
+func (s *Set) SaveQueue(path string) error {
+	s.mu.Lock()
+	queue := make(map[string][]string)
+	for _, e := range s.entries {
+		for p := range e.pending {
+			queue[e.m.Name()] = append(queue[e.m.Name()], p)
+		}
+	}
+	s.mu.Unlock()
+	if len(queue) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(queue)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// LoadQueue re-queues paths saved by SaveQueue for mirrors that are still configured.
+// A missing file is not an error.
+func (s *Set) LoadQueue(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var queue map[string][]string
+	if err := json.Unmarshal(data, &queue); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen++
+	for _, e := range s.entries {
+		for _, p := range queue[e.m.Name()] {
+			e.pending[p] = s.gen
+		}
+	}
+	return nil
+}
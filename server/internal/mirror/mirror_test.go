@@ -0,0 +1,172 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shaun/flux/server/internal/sync"
+)
+
+type fakeMirror struct {
+	name    string
+	calls   int
+	err     error
+	files   []*sync.File
+	deleted []string
+}
+
+func (f *fakeMirror) Name() string { return f.name }
+
+func (f *fakeMirror) Sync(_ context.Context, files []*sync.File, deleted []string) error {
+	f.calls++
+	f.files, f.deleted = files, deleted
+	return f.err
+}
+
+func TestSet_empty(t *testing.T) {
+	s := NewSet()
+	if s.Len() != 0 || len(s.Names()) != 0 || len(s.Status()) != 0 {
+		t.Fatalf("empty set: len=%d names=%v", s.Len(), s.Names())
+	}
+	s.Enqueue("a.md")
+	if err := s.Flush(context.Background(), sync.NewStore()); err != nil {
+		t.Fatalf("Flush with no mirrors: %v", err)
+	}
+}
+
+func TestSet_Flush(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("x.md", "x", "h")
+	a := &fakeMirror{name: "a"}
+	b := &fakeMirror{name: "b", err: errors.New("boom")}
+	s := NewSet(a, b)
+	if names := s.Names(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("Names: %v", names)
+	}
+	s.Enqueue("x.md", "gone.md")
+	err := s.Flush(context.Background(), store)
+	if err == nil || !errors.Is(err, b.err) {
+		t.Fatalf("expected joined error from b, got %v", err)
+	}
+	if a.calls != 1 || b.calls != 1 {
+		t.Fatalf("every mirror should be synced: a=%d b=%d", a.calls, b.calls)
+	}
+	if len(a.files) != 1 || a.files[0].Path != "x.md" || len(a.deleted) != 1 || a.deleted[0] != "gone.md" {
+		t.Fatalf("a got files=%v deleted=%v", a.files, a.deleted)
+	}
+	st := s.Status()
+	if !st[0].OK || st[0].LastSyncAt == 0 || st[0].Pending != 0 {
+		t.Errorf("a status: %+v", st[0])
+	}
+	if st[1].OK || st[1].LastSyncAt != 0 || st[1].Pending != 2 {
+		t.Errorf("b status: %+v", st[1])
+	}
+
+	// Nothing new for a; b retries its queue.
+	b.err = nil
+	if err := s.Flush(context.Background(), store); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if a.calls != 1 || b.calls != 2 || s.Pending() != 0 {
+		t.Fatalf("retry: a=%d b=%d pending=%d", a.calls, b.calls, s.Pending())
+	}
+}
+
+// blockingMirror holds Sync until release is closed, signalling started first.
+type blockingMirror struct {
+	started, release chan struct{}
+	files            []*sync.File
+}
+
+func (b *blockingMirror) Name() string { return "slow" }
+
+func (b *blockingMirror) Sync(_ context.Context, files []*sync.File, _ []string) error {
+	b.files = files
+	b.started <- struct{}{}
+	<-b.release
+	return nil
+}
+
+func TestSet_Flush_requeuedDuringSync(t *testing.T) {
+	store := sync.NewStore()
+	store.UpsertFile("x.md", "v1", "h1")
+	m := &blockingMirror{started: make(chan struct{}), release: make(chan struct{})}
+	s := NewSet(m)
+	s.Enqueue("x.md")
+	done := make(chan error)
+	go func() { done <- s.Flush(context.Background(), store) }()
+	<-m.started
+	// A newer version lands while the mirror is still sending the old one.
+	store.UpsertFile("x.md", "v2", "h2")
+	s.Enqueue("x.md")
+	close(m.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s.Pending() != 1 {
+		t.Fatalf("path enqueued during sync was dropped: pending=%d", s.Pending())
+	}
+	m.release = make(chan struct{})
+	close(m.release)
+	go func() { <-m.started }()
+	if err := s.Flush(context.Background(), store); err != nil || s.Pending() != 0 {
+		t.Fatalf("second flush: %v, pending=%d", err, s.Pending())
+	}
+	if len(m.files) != 1 || m.files[0].Content != "v2" {
+		t.Fatalf("second flush sent %+v", m.files)
+	}
+}
+
+func TestSet_Replace(t *testing.T) {
+	s := NewSet(&fakeMirror{name: "a", err: errors.New("boom")}, &fakeMirror{name: "gone"})
+	s.Enqueue("x.md")
+	_ = s.Flush(context.Background(), sync.NewStore())
+	a := &fakeMirror{name: "a"}
+	s.Replace(a, &fakeMirror{name: "new"})
+	st := s.Status()
+	if st[0].OK || st[0].Pending != 1 {
+		t.Errorf("a should keep its status and queue: %+v", st[0])
+	}
+	if !st[1].OK || st[1].Name != "new" || st[1].Pending != 0 {
+		t.Errorf("new mirror should start empty: %+v", st[1])
+	}
+	if err := s.Flush(context.Background(), sync.NewStore()); err != nil || a.calls != 1 {
+		t.Fatalf("replaced mirror should receive kept queue: err=%v calls=%d", err, a.calls)
+	}
+}
+
+func TestSet_SaveLoadQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "mirror-queue.json")
+	s := NewSet(&fakeMirror{name: "a"}, &fakeMirror{name: "b"})
+	if err := s.SaveQueue(path); err != nil {
+		t.Fatalf("SaveQueue empty: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("empty queue should not leave a file: %v", err)
+	}
+	s.Enqueue("x.md", "y.md")
+	if err := s.SaveQueue(path); err != nil {
+		t.Fatalf("SaveQueue: %v", err)
+	}
+
+	restored := NewSet(&fakeMirror{name: "a"}, &fakeMirror{name: "c"})
+	if err := restored.LoadQueue(path); err != nil {
+		t.Fatalf("LoadQueue: %v", err)
+	}
+	st := restored.Status()
+	if st[0].Pending != 2 || st[1].Pending != 0 {
+		t.Fatalf("restored queue: %+v", st)
+	}
+	if err := restored.LoadQueue(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("LoadQueue missing: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("nope"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.LoadQueue(path); err == nil {
+		t.Fatal("expected error for corrupt queue")
+	}
+}
@@ -0,0 +1,261 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chunk boundaries depend only on the bytes around them, so an edit changes the chunks it
+// touches and the rest of the content dedupes against earlier versions. Clients that want to
+// upload only missing chunks must cut the same way: see Chunk.
+const (
+	chunkMin  = 2 << 10
+	chunkMax  = 64 << 10
+	chunkMask = 1<<13 - 1 // a boundary every 8 KiB on average
+
+	// blobThreshold is the content size from which the store keeps chunks: snapshots hold
+	// their hashes instead of the content, and so does history.
+	blobThreshold = 32 << 10
+)
+
+// gear holds the rolling-hash value of each byte: the first 8 bytes, big-endian, of sha256 of the byte.
+var gear = func() (g [256]uint64) {
+	for i := range g {
+		sum := sha256.Sum256([]byte{byte(i)})
+		g[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return g
+}()
+
+// Chunk splits data at content-defined boundaries (a gear hash, FastCDC-style). Each chunk is
+// at least 2 KiB, except the last, and at most 64 KiB: after the first 2 KiB of a chunk the
+// hash is h = h<<1 + gear[b] over each byte b, and the chunk ends after the byte where the low
+// 13 bits of h are zero.
+func Chunk(data []byte) [][]byte {
+	var out [][]byte
+	for len(data) > 0 {
+		n := cut(data)
+		out = append(out, data[:n])
+		data = data[n:]
+	}
+	return out
+}
+
+func cut(data []byte) int {
+	if len(data) <= chunkMin {
+		return len(data)
+	}
+	end := min(len(data), chunkMax)
+	var h uint64
+	for i := chunkMin; i < end; i++ {
+		h = h<<1 + gear[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return end
+}
+
+// ChunkHash is the address of a chunk: its hex-encoded sha256.
+func ChunkHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func validChunkHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// Blobs stores chunks by hash, each once. With a directory every chunk is a file under it;
+// otherwise chunks live in memory.
+type Blobs struct {
+	mu    sync.Mutex
+	dir   string
+	mem   map[string]blob
+	split map[string][]string // chunk hashes of content already stored, keyed by the content
+}
+
+type blob struct {
+	data []byte
+	at   time.Time
+}
+
+// NewBlobs returns a chunk store in dir, or in memory if dir is empty.
+func NewBlobs(dir string) *Blobs {
+	return &Blobs{dir: dir, mem: make(map[string]blob), split: make(map[string][]string)}
+}
+
+func (b *Blobs) file(hash string) string {
+	return filepath.Join(b.dir, hash[:2], hash)
+}
+
+// Put stores data and returns its hash. Storing a chunk that is already there only marks it
+// as recently used, so Sweep keeps it.
+func (b *Blobs) Put(data []byte) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.put(data)
+}
+
+func (b *Blobs) put(data []byte) (string, error) {
+	hash := ChunkHash(data)
+	now := time.Now()
+	if b.dir == "" {
+		if old, ok := b.mem[hash]; ok {
+			data = old.data
+		}
+		b.mem[hash] = blob{data: data, at: now}
+		return hash, nil
+	}
+	name := b.file(hash)
+	if err := os.Chtimes(name, now, now); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return "", err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp, name)
+}
+
+// Get returns the chunk with hash, or ErrNotFound.
+func (b *Blobs) Get(hash string) ([]byte, error) {
+	if !validChunkHash(hash) {
+		return nil, ErrNotFound
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dir == "" {
+		c, ok := b.mem[hash]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return c.data, nil
+	}
+	data, err := os.ReadFile(b.file(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Has reports whether the chunk with hash is stored.
+func (b *Blobs) Has(hash string) bool {
+	if !validChunkHash(hash) {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.dir == "" {
+		_, ok := b.mem[hash]
+		return ok
+	}
+	_, err := os.Stat(b.file(hash))
+	return err == nil
+}
+
+// Split chunks content, stores every chunk and returns their hashes in order.
+func (b *Blobs) Split(content string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if hashes, ok := b.split[content]; ok {
+		return hashes, nil
+	}
+	var hashes []string
+	for _, c := range Chunk([]byte(content)) {
+		h, err := b.put(c)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	b.split[content] = hashes
+	return hashes, nil
+}
+
+// Stored returns the chunk hashes of content if Split has stored it, without storing anything.
+func (b *Blobs) Stored(content string) ([]string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hashes, ok := b.split[content]
+	return hashes, ok
+}
+
+// remember records that content is stored as hashes, e.g. when loading a snapshot.
+func (b *Blobs) remember(content string, hashes []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.split[content] = hashes
+}
+
+// Join reassembles content from chunk hashes. It fails with ErrNotFound, naming the first
+// missing chunk, if any is not stored.
+func (b *Blobs) Join(hashes []string) (string, error) {
+	var sb strings.Builder
+	for _, h := range hashes {
+		data, err := b.Get(h)
+		if err != nil {
+			return "", fmt.Errorf("chunk %s: %w", h, err)
+		}
+		sb.Write(data)
+	}
+	return sb.String(), nil
+}
+
+// retain forgets the split results of content no longer in use, so they can be collected.
+func (b *Blobs) retain(keep map[string]bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for content := range b.split {
+		if !keep[content] {
+			delete(b.split, content)
+		}
+	}
+}
+
+// Sweep deletes chunks not in keep and unused since before cutoff, and reports how many it
+// deleted. The cutoff spares chunks a client has just uploaded for a push still to come.
+func (b *Blobs) Sweep(keep map[string]bool, cutoff time.Time) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	if b.dir == "" {
+		for h, c := range b.mem {
+			if !keep[h] && c.at.Before(cutoff) {
+				delete(b.mem, h)
+				n++
+			}
+		}
+		return n, nil
+	}
+	err := filepath.WalkDir(b.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || keep[d.Name()] {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return err
+		}
+		n++
+		return os.Remove(path)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return n, err
+}
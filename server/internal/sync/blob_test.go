@@ -0,0 +1,204 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func randomText(n int, seed int64) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	words := []string{"flux ", "vault ", "note ", "sync ", "chunk ", "\n", "# heading\n", "- item "}
+	var b bytes.Buffer
+	for b.Len() < n {
+		b.WriteString(words[rng.Intn(len(words))])
+	}
+	return b.Bytes()[:n]
+}
+
+func TestChunk(t *testing.T) {
+	data := randomText(1<<20, 1)
+	chunks := Chunk(data)
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks should join back to the data")
+	}
+	for i, c := range chunks {
+		if len(c) > chunkMax || (len(c) < chunkMin && i != len(chunks)-1) {
+			t.Fatalf("chunk %d has size %d", i, len(c))
+		}
+	}
+	if avg := len(data) / len(chunks); avg < 4<<10 || avg > 16<<10 {
+		t.Fatalf("average chunk size %d, want about 8 KiB", avg)
+	}
+
+	// An edit in the middle leaves the chunks away from it alone.
+	edited := append(bytes.Clone(data[:500<<10]), append([]byte("inserted text"), data[500<<10:]...)...)
+	seen := make(map[string]bool)
+	for _, c := range chunks {
+		seen[ChunkHash(c)] = true
+	}
+	fresh := 0
+	for _, c := range Chunk(edited) {
+		if !seen[ChunkHash(c)] {
+			fresh++
+		}
+	}
+	if fresh > 2 {
+		t.Fatalf("a small edit produced %d new chunks", fresh)
+	}
+	if Chunk(nil) != nil {
+		t.Fatal("no data, no chunks")
+	}
+}
+
+func TestBlobs(t *testing.T) {
+	for name, dir := range map[string]string{"memory": "", "disk": t.TempDir()} {
+		t.Run(name, func(t *testing.T) {
+			b := NewBlobs(dir)
+			content := string(randomText(100<<10, 2))
+			hashes, err := b.Split(content)
+			if err != nil || len(hashes) < 2 {
+				t.Fatalf("Split: %d chunks, %v", len(hashes), err)
+			}
+			if got, err := b.Join(hashes); err != nil || got != content {
+				t.Fatalf("Join: %v", err)
+			}
+			if !b.Has(hashes[0]) || b.Has(strings.Repeat("0", 64)) || b.Has("../x") {
+				t.Fatal("Has")
+			}
+			if _, err := b.Get("nothex"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get invalid hash: %v", err)
+			}
+			if _, err := b.Join([]string{strings.Repeat("0", 64)}); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Join missing chunk: %v", err)
+			}
+			h, _ := b.Put([]byte("loose"))
+			if data, err := b.Get(h); err != nil || string(data) != "loose" {
+				t.Fatalf("Get: %q %v", data, err)
+			}
+
+			keep := map[string]bool{}
+			for _, h := range hashes {
+				keep[h] = true
+			}
+			if n, err := b.Sweep(keep, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+				t.Fatalf("recent chunks must survive a sweep: %d %v", n, err)
+			}
+			if n, err := b.Sweep(keep, time.Now().Add(time.Hour)); err != nil || n != 1 || b.Has(h) || !b.Has(hashes[0]) {
+				t.Fatalf("sweep should drop only the unused chunk: %d %v", n, err)
+			}
+		})
+	}
+	if n, err := NewBlobs(filepath.Join(t.TempDir(), "none")).Sweep(nil, time.Now()); err != nil || n != 0 {
+		t.Fatalf("sweeping a missing directory: %d %v", n, err)
+	}
+}
+
+func TestOpenStore_chunkedContent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := string(randomText(200<<10, 3))
+	s.UpsertFile("big.md", big, "v1")
+	s.UpsertFile("big.md", big+"one more line\n", "v2")
+	s.UpsertFile("small.md", "small", "hs")
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if len(data) > 10<<10 {
+		t.Fatalf("large content should live in chunks, snapshot is %d bytes", len(data))
+	}
+	var stored int64
+	filepath.WalkDir(filepath.Join(dir, "blobs"), func(_ string, d os.DirEntry, _ error) error {
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			stored += info.Size()
+		}
+		return nil
+	})
+	if stored > int64(len(big))+chunkMax {
+		t.Fatalf("two versions should share chunks: %d bytes stored for a %d byte file", stored, len(big))
+	}
+
+	reloaded, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := reloaded.Get("big.md"); f.Content != big+"one more line\n" {
+		t.Fatal("reloaded content differs")
+	}
+	if h := reloaded.History("big.md"); len(h) != 1 || h[0].Content != "" || len(h[0].Chunks) == 0 {
+		t.Fatalf("large history should stay as chunks: %+v", h)
+	} else if content, err := reloaded.VersionContent(h[0]); err != nil || content != big {
+		t.Fatalf("reloaded history differs: %v", err)
+	}
+	if f, _ := reloaded.Get("small.md"); f.Content != "small" {
+		t.Fatal("small files stay inline")
+	}
+
+	// Chunks no longer referenced are swept once they are old enough.
+	reloaded.DeleteTree("big.md")
+	reloaded.history = map[string][]Version{}
+	reloaded.swept = time.Time{}
+	old := time.Now().Add(-2 * sweepInterval)
+	filepath.WalkDir(filepath.Join(dir, "blobs"), func(p string, d os.DirEntry, _ error) error {
+		return os.Chtimes(p, old, old)
+	})
+	if err := reloaded.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := reloaded.Blobs().Sweep(nil, time.Now()); n != 0 {
+		t.Fatalf("%d chunk(s) survived the sweep", n)
+	}
+
+	os.WriteFile(path, data, 0o600)
+	if _, err := OpenStore(path); err == nil {
+		t.Fatal("a snapshot whose chunks are missing should fail to open")
+	}
+}
+
+func TestStore_historyChunks(t *testing.T) {
+	s := NewStore()
+	big := string(randomText(100<<10, 5))
+	s.UpsertFile("big.md", big, "v1")
+	s.UpsertFile("big.md", big+"a\n", "v2") // replaced before any flush: kept inline for now
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.UpsertFile("big.md", big+"b\n", "v3")
+	h := s.History("big.md")
+	if len(h) != 2 {
+		t.Fatalf("history: %d versions", len(h))
+	}
+	for i, want := range []string{big, big + "a\n"} {
+		if h[i].Content != "" || len(h[i].Chunks) == 0 {
+			t.Fatalf("version %d should be kept as chunks: %d bytes inline", i, len(h[i].Content))
+		}
+		if got, err := s.VersionContent(h[i]); err != nil || got != want {
+			t.Fatalf("version %d content: %v", i, err)
+		}
+	}
+
+	// In-memory chunks are swept too once nothing refers to them.
+	s.DeleteTree("big.md")
+	s.PruneHistory(time.Now().UnixMilli() + 1)
+	s.swept = time.Time{}
+	for hash, c := range s.blobs.mem {
+		c.at = time.Now().Add(-2 * sweepInterval)
+		s.blobs.mem[hash] = c
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.blobs.mem) != 0 {
+		t.Fatalf("%d chunk(s) survived the sweep", len(s.blobs.mem))
+	}
+}
@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Conflict records a concurrent edit that lost: the version already on the server stays at
+// Path and the losing one was written next to it as Copy. Deleting the copy, by
+// ResolveConflict or any delete, resolves it; moving the copy moves the record.
+type Conflict struct {
+	Path      string `json:"path"`
+	Copy      string `json:"copy"`
+	Device    string `json:"device,omitempty"` // device whose edit lost
+	Hash      string `json:"hash"`             // of the losing version
+	Winner    string `json:"winner"`           // hash of the version kept at Path
+	CreatedAt int64  `json:"createdAt"`
+	Rev       int64  `json:"rev"`
+}
+
+// ConflictPath names the copy of p's losing version from device label on day t, e.g.
+// "Notes/Plan (conflict from Laptop 2026-10-17).md". n > 1 numbers further copies.
+func ConflictPath(p, label string, t time.Time, n int) string {
+	dir, name := path.Split(p)
+	ext := path.Ext(name)
+	if ext == name {
+		ext = "" // a dotfile such as ".env" has no extension
+	}
+	tag := "conflict"
+	if label != "" {
+		tag += " from " + label
+	}
+	tag += " " + t.UTC().Format(time.DateOnly)
+	if n > 1 {
+		tag += fmt.Sprintf(" %d", n)
+	}
+	return dir + strings.TrimSuffix(name, ext) + " (" + tag + ")" + ext
+}
+
+// maxLabel bounds the device label in a copy name, so a long device name can't push the
+// copy past the path length limit.
+const maxLabel = 64
+
+// label is how copies name the batch's device: its name, else its ID. Device names come from
+// clients, so the label is made safe to put in a path: no slashes, control characters or
+// "..", and at most maxLabel bytes.
+func (s *Store) label() string {
+	l := s.device
+	if d, ok := s.devices[s.device]; ok && d.Name != "" {
+		l = d.Name
+	}
+	l = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '-'
+		case unicode.IsControl(r) || r == utf8.RuneError:
+			return -1
+		}
+		return r
+	}, l)
+	for strings.Contains(l, "..") {
+		l = strings.ReplaceAll(l, "..", ".")
+	}
+	if len(l) > maxLabel {
+		n := maxLabel
+		for n > 0 && !utf8.RuneStart(l[n]) {
+			n--
+		}
+		l = l[:n]
+	}
+	return strings.TrimSpace(l)
+}
+
+// KeepConflict writes content, an edit of p that lost to the current version, to a free
+// copy path next to p and records the conflict.
+func (tx *Tx) KeepConflict(p, content, hash string) Conflict {
+	s := tx.s
+	now := time.Now()
+	cp := ""
+	for n := 1; ; n++ {
+		cp = ConflictPath(p, s.label(), now, n)
+		if _, taken := s.files[cp]; !taken {
+			break
+		}
+	}
+	c := Conflict{Path: p, Copy: cp, Device: s.device, Hash: hash, CreatedAt: now.UnixMilli()}
+	if f, ok := s.files[p]; ok {
+		c.Winner = f.Hash
+	}
+	s.upsert(cp, content, hash, nil)
+	c.Rev = s.rev
+	s.conflicts[cp] = c
+	return c
+}
+
+// ResolveConflict deletes the copy kept for a conflict, which forgets it, and returns the
+// tombstoned paths. It returns ErrNotFound if copy isn't a conflict copy.
+func (tx *Tx) ResolveConflict(copy string) ([]string, error) {
+	if _, ok := tx.s.conflicts[copy]; !ok {
+		return nil, ErrNotFound
+	}
+	return tx.s.deleteTree(copy), nil
+}
+
+// Conflicts returns the unresolved conflicts, sorted by copy path.
+func (s *Store) Conflicts() []Conflict {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Conflict, 0, len(s.conflicts))
+	for _, c := range s.conflicts {
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b Conflict) int { return strings.Compare(a.Copy, b.Copy) })
+	return out
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConflictPath(t *testing.T) {
+	day := time.Date(2026, 10, 17, 23, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		path, label string
+		n           int
+		want        string
+	}{
+		{"Note.md", "Laptop", 1, "Note (conflict from Laptop 2026-10-17).md"},
+		{"a/b/Plan.v2.md", "phone-1", 2, "a/b/Plan.v2 (conflict from phone-1 2026-10-17 2).md"},
+		{"dir/.env", "", 1, "dir/.env (conflict 2026-10-17)"},
+		{"README", "X", 1, "README (conflict from X 2026-10-17)"},
+	} {
+		if got := ConflictPath(c.path, c.label, day, c.n); got != c.want {
+			t.Errorf("ConflictPath(%q, %q, %d) = %q, want %q", c.path, c.label, c.n, got, c.want)
+		}
+	}
+}
+
+func TestStore_conflictHostileDeviceName(t *testing.T) {
+	s := NewStore()
+	s.SeeDevice(Device{ID: "d", Name: "../../etc/passwd\x00\r\n" + strings.Repeat("é", 3000)})
+	s.UpsertFile("Notes/a.md", "server", "hs")
+	var c Conflict
+	s.Batch(func(tx *Tx) error {
+		tx.As("d")
+		c = tx.KeepConflict("Notes/a.md", "mine", "hm")
+		return nil
+	})
+	name := strings.TrimPrefix(c.Copy, "Notes/")
+	if name == c.Copy || strings.ContainsAny(name, "/\\\x00\r\n") || strings.Contains(c.Copy, "..") {
+		t.Fatalf("unsafe copy path: %q", c.Copy)
+	}
+	if len(c.Copy) > 120 || !strings.HasPrefix(name, "a (conflict from .-.-etc-passwdé") {
+		t.Fatalf("label should be kept short: %d bytes, %q", len(c.Copy), c.Copy)
+	}
+}
+
+func TestStore_conflicts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SeeDevice(Device{ID: "laptop-1", Name: "Work/Laptop"})
+	s.UpsertFile("Note.md", "server", "hs")
+	var first, second Conflict
+	s.Batch(func(tx *Tx) error {
+		tx.As("laptop-1")
+		first = tx.KeepConflict("Note.md", "mine", "hm")
+		second = tx.KeepConflict("Note.md", "mine again", "hm2")
+		return nil
+	})
+	day := time.Now().UTC().Format(time.DateOnly)
+	if first.Copy != "Note (conflict from Work-Laptop "+day+").md" || first.Winner != "hs" || first.Device != "laptop-1" || first.Rev == 0 {
+		t.Fatalf("first: %+v", first)
+	}
+	if second.Copy != "Note (conflict from Work-Laptop "+day+" 2).md" {
+		t.Fatalf("a taken copy path gets a number: %+v", second)
+	}
+	if f, _ := s.Get(first.Copy); f.Content != "mine" {
+		t.Fatal("the losing version is written to the copy")
+	}
+
+	// A failed batch forgets its conflicts.
+	s.Batch(func(tx *Tx) error {
+		tx.KeepConflict("Note.md", "x", "hx")
+		return errors.New("abort")
+	})
+	if got := s.Conflicts(); len(got) != 2 {
+		t.Fatalf("conflicts after rollback: %+v", got)
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Conflicts(); len(got) != 2 || got[1] != first {
+		t.Fatalf("conflicts should persist: %+v", got)
+	}
+
+	// Moving a copy moves its record; deleting it resolves the conflict.
+	s.Move(second.Copy, "Elsewhere.md")
+	if got := s.Conflicts(); got[0].Copy != "Elsewhere.md" {
+		t.Fatalf("moved copy: %+v", got)
+	}
+	s.DeleteTree("Elsewhere.md")
+	s.Batch(func(tx *Tx) error {
+		if _, err := tx.ResolveConflict("Note.md"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("resolve a non-copy: %v", err)
+		}
+		if removed, err := tx.ResolveConflict(first.Copy); err != nil || len(removed) != 1 {
+			t.Errorf("resolve: %v %v", removed, err)
+		}
+		return nil
+	})
+	if got := s.Conflicts(); len(got) != 0 {
+		t.Fatalf("resolved conflicts remain: %+v", got)
+	}
+}
@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Device is a client that identified itself to the server. Acked is the newest revision the
+// device has confirmed it holds everything up to, by pulling with since=Acked.
+type Device struct {
+	ID            string `json:"id"`
+	Name          string `json:"name,omitempty"`
+	Platform      string `json:"platform,omitempty"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+	FirstSeen     int64  `json:"firstSeen"`
+	LastSeen      int64  `json:"lastSeen"`
+	Acked         int64  `json:"acked"`
+	RevokedAt     int64  `json:"revokedAt,omitempty"`
+}
+
+// SeeDevice records a request from d.ID, updating its name, platform and client version
+// when given, and returns the stored device. A revoked device is returned unchanged.
+// Sightings alone don't mark the store dirty; they are saved with the next change.
+func (s *Store) SeeDevice(d Device) Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UnixMilli()
+	cur, ok := s.devices[d.ID]
+	if !ok {
+		cur = &Device{ID: d.ID, FirstSeen: now}
+		s.devices[d.ID] = cur
+		s.markDirty()
+	}
+	if cur.RevokedAt != 0 {
+		return *cur
+	}
+	cur.LastSeen = now
+	if d.Name != "" {
+		cur.Name = d.Name
+	}
+	if d.Platform != "" {
+		cur.Platform = d.Platform
+	}
+	if d.ClientVersion != "" {
+		cur.ClientVersion = d.ClientVersion
+	}
+	return *cur
+}
+
+// AckDevice records that device id holds every change up to rev. Acks never move backwards.
+func (s *Store) AckDevice(id string, rev int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[id]; ok && d.RevokedAt == 0 && rev > d.Acked {
+		d.Acked = min(rev, s.rev)
+	}
+}
+
+// RevokeDevice stops accepting requests that name device id and returns it. It returns
+// ErrNotFound for unknown devices.
+func (s *Store) RevokeDevice(id string) (Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[id]
+	if !ok {
+		return Device{}, ErrNotFound
+	}
+	if d.RevokedAt == 0 {
+		d.RevokedAt = time.Now().UnixMilli()
+		s.markDirty()
+	}
+	return *d, nil
+}
+
+// Devices returns every known device, sorted by ID.
+func (s *Store) Devices() []Device {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		out = append(out, *d)
+	}
+	slices.SortFunc(out, func(a, b Device) int { return strings.Compare(a.ID, b.ID) })
+	return out
+}
+
+// AckedByAll is the newest revision every active device has acknowledged, or false when
+// there is none. Devices that are revoked, have never acknowledged anything, or were last
+// seen before seenSince (Unix ms) don't count: one request with a made-up ID must not hold
+// back collection forever. A device left out that comes back behind the collected
+// tombstones is told to resync.
+func (s *Store) AckedByAll(seenSince int64) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var rev int64
+	found := false
+	for _, d := range s.devices {
+		if d.RevokedAt != 0 || d.Acked == 0 || d.LastSeen < seenSince {
+			continue
+		}
+		if !found || d.Acked < rev {
+			rev = d.Acked
+		}
+		found = true
+	}
+	return rev, found
+}
+
+// PruneDevices forgets devices last seen before cutoff (Unix ms) and returns how many it
+// dropped, so clients sending made-up IDs can't grow the registry without bound. Revoked
+// devices are kept, so their revocation still holds if they come back.
+func (s *Store) PruneDevices(cutoff int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, d := range s.devices {
+		if d.RevokedAt == 0 && d.LastSeen < cutoff {
+			delete(s.devices, id)
+			n++
+		}
+	}
+	if n > 0 {
+		s.markDirty()
+	}
+	return n
+}
@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_devices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.AckedByAll(0); ok {
+		t.Fatal("no devices, nothing acknowledged")
+	}
+	s.SeeDevice(Device{ID: "laptop", Name: "Laptop", Platform: "macos", ClientVersion: "1.0.0"})
+	d := s.SeeDevice(Device{ID: "laptop", ClientVersion: "1.1.0"})
+	if d.Name != "Laptop" || d.ClientVersion != "1.1.0" || d.LastSeen == 0 || d.FirstSeen == 0 {
+		t.Fatalf("device: %+v", d)
+	}
+	s.SeeDevice(Device{ID: "phone"})
+
+	s.UpsertFile("a.md", "a", "h")
+	s.UpsertFile("b.md", "b", "h")
+	s.AckDevice("laptop", 1)
+	s.SeeDevice(Device{ID: "throwaway"})
+	if rev, ok := s.AckedByAll(0); !ok || rev != 1 {
+		t.Fatalf("devices that never acknowledged don't count: %d %v", rev, ok)
+	}
+	s.AckDevice("laptop", 2)
+	s.AckDevice("laptop", 1)
+	s.AckDevice("phone", 99)
+	s.AckDevice("unknown", 1)
+	if rev, ok := s.AckedByAll(0); !ok || rev != 2 {
+		t.Fatalf("acked by all: %d %v", rev, ok)
+	}
+	if _, ok := s.AckedByAll(time.Now().UnixMilli() + 1); ok {
+		t.Fatal("devices not seen since the cutoff don't count")
+	}
+	if devs := s.Devices(); len(devs) != 3 || devs[0].Acked != 2 || devs[1].Acked != 2 {
+		t.Fatalf("acks never pass the store revision or go backwards: %+v", devs)
+	}
+
+	if _, err := s.RevokeDevice("nope"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("revoke unknown: %v", err)
+	}
+	if d, err := s.RevokeDevice("phone"); err != nil || d.RevokedAt == 0 {
+		t.Fatalf("revoke: %+v %v", d, err)
+	}
+	if d := s.SeeDevice(Device{ID: "phone", Name: "Stolen"}); d.RevokedAt == 0 || d.Name != "" {
+		t.Fatalf("a revoked device stays as it was: %+v", d)
+	}
+	s.AckDevice("laptop", 2)
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devs := reloaded.Devices(); len(devs) != 3 || devs[1].RevokedAt == 0 || devs[0].Acked != 2 {
+		t.Fatalf("devices should persist: %+v", devs)
+	}
+
+	if n := reloaded.PruneDevices(time.Now().UnixMilli() + 1); n != 2 {
+		t.Fatalf("pruned %d devices", n)
+	}
+	if devs := reloaded.Devices(); len(devs) != 1 || devs[0].ID != "phone" {
+		t.Fatalf("revoked devices are kept: %+v", devs)
+	}
+}
+
+func TestStore_attributionAndPruneAcked(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "a", "h")
+	s.Batch(func(tx *Tx) error {
+		tx.As("laptop")
+		tx.UpsertFile("b.md", "b", "h")
+		tx.DeleteTree("a.md")
+		tx.Move("b.md", "c.md")
+		return nil
+	})
+	s.UpsertFile("d.md", "d", "h")
+	st := s.State()
+	if f, _ := s.Get("c.md"); f.Device != "laptop" || st.Tombstones[0].Device != "laptop" || st.Moves[0].Device != "laptop" {
+		t.Fatalf("changes in the batch should name the device: %+v %+v", f, st)
+	}
+	if f, _ := s.Get("d.md"); f.Device != "" {
+		t.Fatal("attribution ends with the batch")
+	}
+
+	rev := st.Tombstones[0].Rev
+	if n := s.PruneAcked(rev - 1); n != 0 {
+		t.Fatalf("nothing acknowledged yet, pruned %d", n)
+	}
+	if n := s.PruneAcked(rev + 1); n != 3 || s.Horizon() != rev+1 {
+		t.Fatalf("pruned %d, horizon %d", n, s.Horizon())
+	}
+}
@@ -1,18 +1,41 @@
 package sync
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"strconv"
+	"unicode/utf16"
 )
 
-// ContentHash matches the plugin's contentHash for compatibility.
+// ContentHash identifies content: "sha256:" and the lowercase hex SHA-256 of its UTF-8
+// bytes. Clients must hash the same bytes; the plugin's contentHash does.
 func ContentHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// legacyHash reports whether hash is the 32-bit hash older versions used in place of
+// ContentHash: over UTF-16 code units in the plugin, over bytes on the server.
+func legacyHash(s, hash string) bool {
+	units := make([]int32, 0, len(s))
+	for _, u := range utf16.Encode([]rune(s)) {
+		units = append(units, int32(u))
+	}
+	bytes := make([]int32, len(s))
+	for i := range len(s) {
+		bytes[i] = int32(s[i])
+	}
+	return hash == javaHash(units) || hash == javaHash(bytes)
+}
+
+func javaHash(units []int32) string {
 	var h int32
-	for i := 0; i < len(s); i++ {
-		h = (h<<5 - h + int32(s[i])) | 0
+	for _, u := range units {
+		h = h<<5 - h + u
 	}
 	if h < 0 {
 		h = -h
 	}
-	return fmt.Sprintf("sha256:%s%d", strconv.FormatInt(int64(h), 36), len(s))
+	return fmt.Sprintf("sha256:%s%d", strconv.FormatInt(int64(h), 36), len(units))
 }
@@ -5,13 +5,14 @@ import (
 )
 
 func TestContentHash(t *testing.T) {
-	// Must match plugin's contentHash for sync compatibility
+	// Must match plugin's contentHash for sync compatibility: sha256 over UTF-8 bytes.
 	tests := []struct {
 		in   string
 		want string
 	}{
-		{"", "sha256:00"},
-		{"# Hi", "sha256:n22m4"},
+		{"", "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"# Hi", "sha256:38c64a17e33e98b7abb8edace0888dffe5918eea28fe4812281fa1ecc0664af4"},
+		{"Café 日本 🎉", "sha256:29210f25b4b8142d7f3d2343a6320a6d604be8e9ea428aea9bc44ae112ce0817"},
 	}
 	for _, tt := range tests {
 		got := ContentHash(tt.in)
@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned when another device holds the lock on a path.
+var ErrLocked = errors.New("locked by another device")
+
+// Lock is an advisory lock on a path, held by a device until ExpiresAt unless renewed.
+// Locks live in memory: a restart releases them all.
+type Lock struct {
+	Path       string `json:"path"`
+	Device     string `json:"device"`
+	AcquiredAt int64  `json:"acquiredAt"`
+	ExpiresAt  int64  `json:"expiresAt"`
+}
+
+// Lock takes the lock on path for device, or renews it if device already holds it, until
+// ttl from now. It returns ErrLocked, with the current lock, if another device holds it.
+// Taking a lock starts a new revision so polling clients see it; renewing doesn't.
+func (s *Store) Lock(path, device string, ttl time.Duration) (Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	l, held := s.lock(path, now)
+	if held && l.Device != device {
+		return l, ErrLocked
+	}
+	if !held {
+		l = Lock{Path: path, Device: device, AcquiredAt: now.UnixMilli()}
+		s.bump()
+	}
+	l.ExpiresAt = now.Add(ttl).UnixMilli()
+	s.locks[path] = l
+	return l, nil
+}
+
+// Unlock releases device's lock on path. It returns ErrNotFound if path isn't locked and
+// ErrLocked, with the current lock, if another device holds it.
+func (s *Store) Unlock(path, device string) (Lock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, held := s.lock(path, time.Now())
+	switch {
+	case !held:
+		return Lock{}, ErrNotFound
+	case l.Device != device:
+		return l, ErrLocked
+	}
+	delete(s.locks, path)
+	s.bump()
+	return l, nil
+}
+
+// lock returns the unexpired lock on path. Expired locks stay until ExpireLocks drops them
+// in a new revision. Callers hold s.mu.
+func (s *Store) lock(path string, now time.Time) (Lock, bool) {
+	l, ok := s.locks[path]
+	if !ok || l.ExpiresAt <= now.UnixMilli() {
+		return Lock{}, false
+	}
+	return l, true
+}
+
+// ExpireLocks drops the locks that have expired and reports how many. Dropping any starts a
+// new revision, like a release, so clients polling with the revision's ETag see them go.
+func (s *Store) ExpireLocks() int {
+	now := time.Now().UnixMilli()
+	s.mu.RLock()
+	expired := false
+	for _, l := range s.locks {
+		if l.ExpiresAt <= now {
+			expired = true
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if !expired {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for p, l := range s.locks {
+		if l.ExpiresAt <= now {
+			delete(s.locks, p)
+			n++
+		}
+	}
+	if n > 0 {
+		s.bump()
+	}
+	return n
+}
+
+// Locks returns the unexpired locks, sorted by path.
+func (s *Store) Locks() []Lock {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now().UnixMilli()
+	out := make([]Lock, 0, len(s.locks))
+	for _, l := range s.locks {
+		if l.ExpiresAt > now {
+			out = append(out, l)
+		}
+	}
+	slices.SortFunc(out, func(a, b Lock) int { return strings.Compare(a.Path, b.Path) })
+	return out
+}
+
+// Locked returns a lock another device than the batch's holds on path or, for a folder,
+// on a file under it. A batch without a device (see As) counts as another device: anonymous
+// writers are refused while any lock applies.
+func (tx *Tx) Locked(path string) (Lock, bool) {
+	now := time.Now()
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for p := range tx.s.locks {
+		if p != path && !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		if l, ok := tx.s.lock(p, now); ok && l.Device != tx.s.device {
+			return l, true
+		}
+	}
+	return Lock{}, false
+}
@@ -0,0 +1,72 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStore_locks(t *testing.T) {
+	s := NewStore()
+	l, err := s.Lock("Notes/a.md", "laptop", time.Minute)
+	if err != nil || l.Device != "laptop" || l.ExpiresAt <= l.AcquiredAt || s.Revision() != 1 {
+		t.Fatalf("lock: %+v %v, revision %d", l, err, s.Revision())
+	}
+	if got, err := s.Lock("Notes/a.md", "phone", time.Minute); !errors.Is(err, ErrLocked) || got.Device != "laptop" {
+		t.Fatalf("another device: %+v %v", got, err)
+	}
+	renewed, err := s.Lock("Notes/a.md", "laptop", time.Hour)
+	if err != nil || renewed.AcquiredAt != l.AcquiredAt || renewed.ExpiresAt <= l.ExpiresAt || s.Revision() != 1 {
+		t.Fatalf("a heartbeat renews without a new revision: %+v %v", renewed, err)
+	}
+
+	s.Batch(func(tx *Tx) error {
+		tx.As("phone")
+		if _, ok := tx.Locked("Notes"); !ok {
+			t.Error("a lock under a folder locks the folder")
+		}
+		if _, ok := tx.Locked("Notes/b.md"); ok {
+			t.Error("other files are free")
+		}
+		tx.As("laptop")
+		if _, ok := tx.Locked("Notes/a.md"); ok {
+			t.Error("the holder isn't locked out")
+		}
+		tx.As("")
+		if _, ok := tx.Locked("Notes/a.md"); !ok {
+			t.Error("anonymous writers are locked out")
+		}
+		return nil
+	})
+
+	if _, err := s.Unlock("Notes/a.md", "phone"); !errors.Is(err, ErrLocked) {
+		t.Fatalf("unlock by another device: %v", err)
+	}
+	if _, err := s.Unlock("Notes/a.md", "laptop"); err != nil || len(s.Locks()) != 0 || s.Revision() != 2 {
+		t.Fatalf("unlock: %v %+v", err, s.Locks())
+	}
+	if _, err := s.Unlock("Notes/a.md", "laptop"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unlock twice: %v", err)
+	}
+
+	// Expired locks are gone and can be taken by anyone.
+	s.Lock("b.md", "laptop", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if len(s.Locks()) != 0 {
+		t.Fatal("expired locks aren't listed")
+	}
+	if l, err := s.Lock("b.md", "phone", time.Minute); err != nil || l.Device != "phone" {
+		t.Fatalf("take an expired lock: %+v %v", l, err)
+	}
+
+	// Expiry is a change of its own once swept.
+	s.Lock("c.md", "laptop", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	rev := s.Revision()
+	if n := s.ExpireLocks(); n != 1 || s.Revision() != rev+1 {
+		t.Fatalf("expire: %d, revision %d -> %d", n, rev, s.Revision())
+	}
+	if n := s.ExpireLocks(); n != 0 || s.Revision() != rev+1 {
+		t.Fatal("nothing left to expire")
+	}
+}
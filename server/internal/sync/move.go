@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a move source has no live files.
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when a move target is already taken.
+	ErrExists = errors.New("already exists")
+)
+
+// Move records that the file at From now lives at To. Moves are kept like tombstones
+// so other devices can replay them as renames instead of delete + create.
+type Move struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	At     int64  `json:"at"`
+	Rev    int64  `json:"rev"`
+	Device string `json:"device,omitempty"`
+}
+
+// Move renames a file, or a folder with everything under it, carrying content, version
+// history and metadata to the new path in one step. Each moved source path is also
+// tombstoned, so clients that don't understand moves still drop the old copy.
+// Nothing changes if from has no files (ErrNotFound) or any target path is taken (ErrExists).
+// Returns the per-file moves, sorted by source path.
+func (s *Store) Move(from, to string) ([]Move, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.move(from, to)
+}
+
+func (s *Store) move(from, to string) ([]Move, error) {
+	from, to = strings.TrimSuffix(from, "/"), strings.TrimSuffix(to, "/")
+	if from == to {
+		return nil, nil
+	}
+	var moves []Move
+	now := time.Now().UnixMilli()
+	if _, ok := s.files[from]; ok {
+		moves = append(moves, Move{From: from, To: to, At: now})
+	} else {
+		prefix := from + "/"
+		for p := range s.files {
+			if strings.HasPrefix(p, prefix) {
+				moves = append(moves, Move{From: p, To: to + "/" + strings.TrimPrefix(p, prefix), At: now})
+			}
+		}
+	}
+	if len(moves) == 0 {
+		return nil, ErrNotFound
+	}
+	for _, m := range moves {
+		if _, taken := s.files[m.To]; taken {
+			return nil, ErrExists
+		}
+	}
+	rev := s.bump()
+	for i := range moves {
+		m := &moves[i]
+		m.Rev, m.Device = rev, s.device
+		s.save(m.From)
+		s.save(m.To)
+		f := *s.files[m.From]
+		f.Path, f.Rev, f.Device = m.To, rev, s.device
+		s.files[m.To] = &f
+		delete(s.files, m.From)
+		if h, ok := s.history[m.From]; ok {
+			s.history[m.To] = h
+			delete(s.history, m.From)
+		}
+		s.deleted[m.From] = Tombstone{Path: m.From, DeletedAt: now, Rev: rev, Device: s.device, Vector: f.Vector.Tick(s.device)}
+		delete(s.deleted, m.To)
+		s.moved[m.From] = *m
+		if c, ok := s.conflicts[m.From]; ok {
+			c.Copy = m.To
+			s.conflicts[m.To] = c
+			delete(s.conflicts, m.From)
+		}
+	}
+	slices.SortFunc(moves, func(a, b Move) int { return strings.Compare(a.From, b.From) })
+	return moves, nil
+}
+
+// Moves returns every recorded move in the order they happened, so replaying them
+// in sequence reproduces chained renames (a -> b, then b -> c).
+func (s *Store) Moves() []Move {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.moves()
+}
+
+func (s *Store) moves() []Move {
+	out := make([]Move, 0, len(s.moved))
+	for _, m := range s.moved {
+		out = append(out, m)
+	}
+	// By revision, not time: chained renames can land in the same millisecond.
+	slices.SortFunc(out, func(a, b Move) int {
+		if a.Rev != b.Rev {
+			return cmp.Compare(a.Rev, b.Rev)
+		}
+		return strings.Compare(a.From, b.From)
+	})
+	return out
+}
@@ -0,0 +1,172 @@
+package sync
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_Move_file(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "v1", "h1")
+	s.UpsertFile("a.md", "v2", "h2")
+	before, _ := s.Get("a.md")
+
+	moves, err := s.Move("a.md", "Folder/b.md")
+	if err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if len(moves) != 1 || moves[0].From != "a.md" || moves[0].To != "Folder/b.md" {
+		t.Fatalf("moves: %+v", moves)
+	}
+	if _, ok := s.Get("a.md"); ok {
+		t.Fatal("source should be gone")
+	}
+	f, ok := s.Get("Folder/b.md")
+	if !ok || f.Content != "v2" || f.Hash != "h2" || f.UpdatedAt != before.UpdatedAt {
+		t.Fatalf("target: %+v", f)
+	}
+	if h := s.History("Folder/b.md"); len(h) != 1 || h[0].Content != "v1" {
+		t.Fatalf("history should follow the file: %+v", h)
+	}
+	if h := s.History("a.md"); len(h) != 0 {
+		t.Fatalf("history left at source: %+v", h)
+	}
+	_, deleted := s.GetFiles()
+	if len(deleted) != 1 || deleted[0] != "a.md" {
+		t.Fatalf("source should be tombstoned for older clients: %v", deleted)
+	}
+	if m := s.Moves(); len(m) != 1 || m[0].To != "Folder/b.md" {
+		t.Fatalf("Moves: %+v", m)
+	}
+
+	// Re-creating the source clears its tombstone; the move still happened, so its record
+	// stays for devices that haven't replayed it.
+	s.UpsertFile("a.md", "new", "h3")
+	if _, deleted := s.GetFiles(); len(deleted) != 0 {
+		t.Fatalf("tombstone should be cleared: %v", deleted)
+	}
+	if m := s.Moves(); len(m) != 1 || m[0].From != "a.md" {
+		t.Fatalf("move record should stay: %+v", m)
+	}
+}
+
+func TestStore_Move_folder(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("Old/a.md", "a", "h")
+	s.UpsertFile("Old/sub/b.md", "b", "h")
+	s.UpsertFile("Older/c.md", "c", "h")
+
+	moves, err := s.Move("Old", "New/")
+	if err != nil {
+		t.Fatalf("Move folder: %v", err)
+	}
+	if len(moves) != 2 || moves[0].To != "New/a.md" || moves[1].To != "New/sub/b.md" {
+		t.Fatalf("moves: %+v", moves)
+	}
+	for _, p := range []string{"New/a.md", "New/sub/b.md", "Older/c.md"} {
+		if _, ok := s.Get(p); !ok {
+			t.Errorf("missing %s", p)
+		}
+	}
+	if files, _ := s.Len(); files != 3 {
+		t.Fatalf("file count %d", files)
+	}
+}
+
+func TestStore_Move_errors(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("Dir/a.md", "a", "h")
+	s.UpsertFile("Dir/b.md", "b", "h")
+	s.UpsertFile("Other/b.md", "taken", "h")
+
+	if _, err := s.Move("missing.md", "x.md"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing source: %v", err)
+	}
+	if _, err := s.Move("Dir", "Other"); !errors.Is(err, ErrExists) {
+		t.Errorf("taken target: %v", err)
+	}
+	if _, ok := s.Get("Dir/a.md"); !ok {
+		t.Error("failed folder move must not move anything")
+	}
+	if moves, err := s.Move("Dir/a.md", "Dir/a.md"); err != nil || moves != nil {
+		t.Errorf("self move: %v %v", moves, err)
+	}
+}
+
+func TestStore_Moves_chained(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "x", "h")
+	if _, err := s.Move("a.md", "b.md"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Move("b.md", "c.md"); err != nil {
+		t.Fatal(err)
+	}
+	m := s.Moves()
+	if len(m) != 2 || m[0].From != "a.md" || m[1].From != "b.md" || m[1].To != "c.md" {
+		t.Fatalf("chained moves: %+v", m)
+	}
+}
+
+func TestStore_Moves_sameMillisecond(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("z.md", "x", "h")
+	for _, mv := range [][2]string{{"z.md", "y.md"}, {"y.md", "x.md"}} {
+		if _, err := s.Move(mv[0], mv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p, m := range s.moved {
+		m.At = 1
+		s.moved[p] = m
+	}
+	m := s.Moves()
+	if len(m) != 2 || m[0].From != "z.md" || m[1].From != "y.md" {
+		t.Fatalf("moves in one millisecond: %+v", m)
+	}
+}
+
+func TestStore_Moves_reusedSource(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "a", "ha")
+	s.UpsertFile("c.md", "c", "hc")
+	if _, err := s.Move("c.md", "d.md"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Move("a.md", "c.md"); err != nil {
+		t.Fatal(err)
+	}
+	m := s.Moves()
+	if len(m) != 2 || m[0].From != "c.md" || m[0].To != "d.md" || m[1].From != "a.md" || m[1].To != "c.md" {
+		t.Fatalf("moves after reusing a source path: %+v", m)
+	}
+	// Writing a file where one was moved away keeps the record too.
+	s.UpsertFile("a.md", "new", "hn")
+	if m := s.Moves(); len(m) != 2 {
+		t.Fatalf("moves after recreating a source: %+v", m)
+	}
+}
+
+func TestStore_Move_persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, _ := OpenStore(path)
+	s.UpsertFile("a.md", "v1", "h1")
+	s.UpsertFile("a.md", "v2", "h2")
+	if _, err := s.Move("a.md", "b.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := r.Moves(); len(m) != 1 || m[0].To != "b.md" {
+		t.Fatalf("reloaded moves: %+v", m)
+	}
+	if h := r.History("b.md"); len(h) != 1 {
+		t.Fatalf("reloaded history: %+v", h)
+	}
+}
@@ -0,0 +1,165 @@
+package sync
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// LineEdit replaces Delete lines of the base, starting at line At (0-based), with Insert.
+// Lines keep their trailing newline, so applying a patch reproduces content byte for byte.
+type LineEdit struct {
+	At     int      `json:"at"`
+	Delete int      `json:"delete,omitempty"`
+	Insert []string `json:"insert,omitempty"`
+}
+
+// ErrBadPatch means a patch's edits are out of order or reach past the end of the base.
+var ErrBadPatch = errors.New("patch does not apply")
+
+// diffMaxEdits bounds the work Diff does; past it the changed region is replaced whole.
+const diffMaxEdits = 512
+
+// Patch applies edits, in order and not overlapping, to base.
+func Patch(base string, edits []LineEdit) (string, error) {
+	src := lines(base)
+	var b strings.Builder
+	pos := 0
+	for _, e := range edits {
+		if e.At < pos || e.Delete < 0 || e.At+e.Delete > len(src) {
+			return "", ErrBadPatch
+		}
+		for _, l := range src[pos:e.At] {
+			b.WriteString(l)
+		}
+		for _, l := range e.Insert {
+			b.WriteString(l)
+		}
+		pos = e.At + e.Delete
+	}
+	for _, l := range src[pos:] {
+		b.WriteString(l)
+	}
+	return b.String(), nil
+}
+
+// Diff returns the edits turning a into b, line by line. Common leading and trailing lines
+// are skipped first, so a one-line change to a long note costs a single edit.
+func Diff(a, b string) []LineEdit {
+	x, y := lines(a), lines(b)
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+	x, y = x[pre:len(x)-suf], y[pre:len(y)-suf]
+	if len(x) == 0 && len(y) == 0 {
+		return nil
+	}
+	steps, ok := myers(x, y)
+	if !ok {
+		return []LineEdit{{At: pre, Delete: len(x), Insert: y}}
+	}
+	var edits []LineEdit
+	var cur *LineEdit
+	i, j := 0, 0
+	for _, s := range steps {
+		if s == stepEqual {
+			cur = nil
+			i, j = i+1, j+1
+			continue
+		}
+		if cur == nil {
+			edits = append(edits, LineEdit{At: pre + i})
+			cur = &edits[len(edits)-1]
+		}
+		if s == stepDelete {
+			cur.Delete++
+			i++
+		} else {
+			cur.Insert = append(cur.Insert, y[j])
+			j++
+		}
+	}
+	return edits
+}
+
+type step byte
+
+const (
+	stepEqual step = iota
+	stepDelete
+	stepInsert
+)
+
+// myers finds a shortest edit script from x to y (Myers' O(ND) algorithm), or reports false
+// if it needs more than diffMaxEdits edits.
+func myers(x, y []string) ([]step, bool) {
+	n, m := len(x), len(y)
+	limit := min(n+m, diffMaxEdits)
+	off := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				i = v[off+k+1]
+			} else {
+				i = v[off+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i, j = i+1, j+1
+			}
+			v[off+k] = i
+			if i >= n && j >= m {
+				return backtrack(trace, off, n, m), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// backtrack walks the saved frontiers from the end back to the start and returns the steps in order.
+func backtrack(trace [][]int, off, i, j int) []step {
+	var steps []step
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := i - j
+		prev := k - 1
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prev = k + 1
+		}
+		pi := v[off+prev]
+		pj := pi - prev
+		for i > pi && j > pj {
+			steps = append(steps, stepEqual)
+			i, j = i-1, j-1
+		}
+		if prev == k+1 {
+			steps = append(steps, stepInsert)
+		} else {
+			steps = append(steps, stepDelete)
+		}
+		i, j = pi, pj
+	}
+	for ; i > 0; i-- {
+		steps = append(steps, stepEqual)
+	}
+	slices.Reverse(steps)
+	return steps
+}
+
+// lines splits s after each newline; a final line without one is kept as-is.
+func lines(s string) []string {
+	l := strings.SplitAfter(s, "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+	return l
+}
@@ -0,0 +1,74 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDiffPatch(t *testing.T) {
+	long := strings.Repeat("line\n", 5000)
+	cases := []struct{ a, b string }{
+		{"", ""},
+		{"", "new\n"},
+		{"a\nb\nc\n", "a\nB\nc\n"},
+		{"a\nb\nc", "a\nb\nc\n"},
+		{"a\nb\nc\n", ""},
+		{"x\na\nb\nc\ny\n", "a\nz\nc\nw\n"},
+		{long, strings.Replace(long, "line\n", "edited\n", 1)},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for range 50 {
+		var a, b strings.Builder
+		for range 40 {
+			l := fmt.Sprintf("%d\n", rng.Intn(6))
+			if rng.Intn(3) > 0 {
+				a.WriteString(l)
+			}
+			if rng.Intn(3) > 0 {
+				b.WriteString(l)
+			}
+		}
+		cases = append(cases, struct{ a, b string }{a.String(), b.String()})
+	}
+	for _, c := range cases {
+		edits := Diff(c.a, c.b)
+		got, err := Patch(c.a, edits)
+		if err != nil || got != c.b {
+			t.Fatalf("Patch(%q, Diff) = %q, %v; want %q", c.a, got, err, c.b)
+		}
+	}
+	if edits := Diff(long, strings.Replace(long, "line\n", "edited\n", 1)); len(edits) != 1 || edits[0].Delete != 1 {
+		t.Fatalf("a one-line change should be one edit: %+v", edits)
+	}
+}
+
+func TestDiff_manyEdits(t *testing.T) {
+	var a, b strings.Builder
+	for i := range 2 * diffMaxEdits {
+		fmt.Fprintf(&a, "a%d\n", i)
+		fmt.Fprintf(&b, "b%d\n", i)
+	}
+	edits := Diff(a.String(), b.String())
+	if len(edits) != 1 || edits[0].Delete != 2*diffMaxEdits {
+		t.Fatalf("past the edit budget the region should be replaced whole: %d edits", len(edits))
+	}
+	if got, _ := Patch(a.String(), edits); got != b.String() {
+		t.Fatal("fallback patch should still apply")
+	}
+}
+
+func TestPatch_invalid(t *testing.T) {
+	for _, edits := range [][]LineEdit{
+		{{At: 3}},
+		{{At: 0, Delete: 3}},
+		{{At: 1}, {At: 0}},
+		{{At: 0, Delete: -1}},
+	} {
+		if _, err := Patch("a\nb\n", edits); !errors.Is(err, ErrBadPatch) {
+			t.Errorf("Patch(%+v) = %v, want ErrBadPatch", edits, err)
+		}
+	}
+}
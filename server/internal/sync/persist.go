@@ -0,0 +1,239 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// snapshot is the on-disk form of a Store.
+type snapshot struct {
+	Rev        int64                `json:"rev"`
+	Horizon    int64                `json:"horizon,omitempty"`
+	Files      []storedFile         `json:"files"`
+	Tombstones []Tombstone          `json:"tombstones"`
+	Deleted    map[string]int64     `json:"deleted,omitempty"` // deletion times, from snapshots that predate Tombstones
+	Moved      []Move               `json:"moved,omitempty"`
+	History    map[string][]Version `json:"history,omitempty"`
+	Devices    []*Device            `json:"devices,omitempty"`
+	Conflicts  []Conflict           `json:"conflicts,omitempty"`
+}
+
+// storedFile holds large content as chunk hashes, so the snapshot stays small and versions
+// sharing most of their content share its chunks in the blob directory.
+type storedFile struct {
+	*File
+	Chunks []string `json:"chunks,omitempty"`
+}
+
+// sweepInterval is how often Flush deletes chunks nothing refers to any more. Chunks used
+// within the last interval are kept, so uploads for a push in progress survive.
+const sweepInterval = time.Hour
+
+// OpenStore returns a store persisted to the JSON snapshot at path, with chunks of large
+// content in a "blobs" directory beside it. A missing file starts an empty store.
+func OpenStore(path string) (*Store, error) {
+	s := NewStore()
+	s.path = path
+	s.blobs = NewBlobs(filepath.Join(filepath.Dir(path), "blobs"))
+	s.swept = time.Now()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	for _, f := range snap.Files {
+		if f.Chunks != nil {
+			if f.Content, err = s.blobs.Join(f.Chunks); err != nil {
+				return nil, err
+			}
+			s.blobs.remember(f.Content, f.Chunks)
+		}
+		s.files[f.Path] = f.File
+	}
+	for p, at := range snap.Deleted {
+		s.deleted[p] = Tombstone{Path: p, DeletedAt: at}
+	}
+	for _, t := range snap.Tombstones {
+		s.deleted[t.Path] = t
+	}
+	s.rev, s.horizon = snap.Rev, snap.Horizon
+	for _, d := range snap.Devices {
+		s.devices[d.ID] = d
+	}
+	for _, c := range snap.Conflicts {
+		s.conflicts[c.Copy] = c
+	}
+	for _, m := range snap.Moved {
+		s.moved[m.From] = m
+	}
+	for p, h := range snap.History {
+		for _, v := range h {
+			for _, c := range v.Chunks {
+				if !s.blobs.Has(c) {
+					return nil, fmt.Errorf("history of %s: chunk %s: %w", p, c, ErrNotFound)
+				}
+			}
+		}
+		s.history[p] = h
+	}
+	// Hashes from before ContentHash was a real digest are rewritten in one new revision,
+	// so incremental pulls hand clients the new ones.
+	var rev int64
+	for _, f := range s.files {
+		if legacyHash(f.Content, f.Hash) {
+			if rev == 0 {
+				rev = s.bump()
+			}
+			f.Hash, f.Rev = ContentHash(f.Content), rev
+		}
+	}
+	return s, nil
+}
+
+// Flush writes the store to its snapshot file if it changed since the last flush, first
+// moving large content into chunks, and hourly sweeps chunks nothing refers to. In-memory
+// stores (NewStore) have no snapshot but chunk and sweep the same way. The store is only
+// read-locked while the snapshot is taken; chunking and writing happen outside the lock,
+// one flush at a time.
+func (s *Store) Flush() error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	s.mu.RLock()
+	now := time.Now()
+	sweep := now.Sub(s.swept) >= sweepInterval
+	dirty, edits := s.dirty, s.edits
+	if !dirty && !sweep {
+		s.mu.RUnlock()
+		return nil
+	}
+	snap := snapshot{Rev: s.rev, Horizon: s.horizon, Files: make([]storedFile, 0, len(s.files)), Tombstones: make([]Tombstone, 0, len(s.deleted)), History: make(map[string][]Version, len(s.history))}
+	for _, f := range s.files {
+		snap.Files = append(snap.Files, storedFile{File: f})
+	}
+	for p, h := range s.history {
+		snap.History[p] = slices.Clone(h)
+	}
+	for _, t := range s.deleted {
+		snap.Tombstones = append(snap.Tombstones, t)
+	}
+	for _, m := range s.moved {
+		snap.Moved = append(snap.Moved, m)
+	}
+	for _, d := range s.devices {
+		d := *d // devices change in place
+		snap.Devices = append(snap.Devices, &d)
+	}
+	for _, c := range s.conflicts {
+		snap.Conflicts = append(snap.Conflicts, c)
+	}
+	s.mu.RUnlock()
+
+	inUse := make(map[string]bool)
+	chunks := make(map[string]bool)
+	// split returns the chunk hashes content is kept as, or nil to keep it inline.
+	split := func(content string) ([]string, error) {
+		if len(content) < blobThreshold {
+			return nil, nil
+		}
+		hashes, err := s.blobs.Split(content)
+		for _, h := range hashes {
+			chunks[h] = true
+		}
+		return hashes, err
+	}
+	for i, sf := range snap.Files {
+		hashes, err := split(sf.Content)
+		if err != nil {
+			return err
+		}
+		if hashes != nil {
+			inUse[sf.Content] = true
+			c := *sf.File
+			c.Content = ""
+			snap.Files[i] = storedFile{File: &c, Chunks: hashes}
+		}
+	}
+	for _, h := range snap.History {
+		for i, v := range h {
+			// Versions that entered history before their content was chunked are chunked now.
+			hashes, err := split(v.Content)
+			if err != nil {
+				return err
+			}
+			if hashes != nil {
+				h[i].Content, h[i].Chunks = "", hashes
+			}
+			for _, c := range h[i].Chunks {
+				chunks[c] = true
+			}
+		}
+	}
+	if s.path != "" && dirty {
+		if err := s.write(snap); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	// Changes made while writing stay dirty for the next flush.
+	if s.edits == edits {
+		s.dirty = false
+	}
+	// History chunked above is kept as chunks in memory too; History hands out copies.
+	for _, h := range s.history {
+		for i, v := range h {
+			if v.Chunks != nil || len(v.Content) < blobThreshold {
+				continue
+			}
+			if hashes, ok := s.blobs.Stored(v.Content); ok {
+				h[i].Content, h[i].Chunks = "", hashes
+			}
+		}
+	}
+	s.blobs.retain(inUse)
+	if sweep {
+		s.swept = now
+	}
+	s.mu.Unlock()
+
+	// Chunks referenced since this snapshot was taken were stored or touched after the
+	// cutoff, so sweeping without the store lock can't remove them.
+	if sweep {
+		if n, err := s.blobs.Sweep(chunks, now.Add(-sweepInterval)); err != nil {
+			log.Printf("[Flux] Blob sweep failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[Flux] Swept %d unused chunk(s)", n)
+		}
+	}
+	return nil
+}
+
+// write saves snap to the snapshot file.
+func (s *Store) write(snap snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	// Write then rename so a crash never leaves a half-written snapshot.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,159 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOpenStore_missingFile(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	if files, deleted := s.Len(); files != 0 || deleted != 0 {
+		t.Fatalf("expected empty store, got %d files %d deleted", files, deleted)
+	}
+}
+
+func TestOpenStore_flushAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "store.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	s.UpsertFile("a.md", "content a", "hash-a")
+	s.UpsertFile("b.md", "content b", "hash-b")
+	s.DeleteFile("b.md")
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	reloaded, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	files, deleted := reloaded.GetFiles()
+	if len(files) != 1 || files[0].Path != "a.md" || files[0].Content != "content a" {
+		t.Fatalf("reloaded files: %+v", files)
+	}
+	if len(deleted) != 1 || deleted[0] != "b.md" {
+		t.Fatalf("reloaded deleted: %+v", deleted)
+	}
+}
+
+func TestStore_Flush_concurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				p := strconv.Itoa(w) + "-" + strconv.Itoa(i) + ".md"
+				s.UpsertFile(p, p, p)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				if err := s.Flush(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// Writes that landed during an earlier flush are still dirty, so this one saves them.
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := reloaded.Len(); files != 200 || reloaded.Revision() != s.Revision() {
+		t.Fatalf("reloaded %d files at rev %d, want 200 at %d", files, reloaded.Revision(), s.Revision())
+	}
+}
+
+func TestOpenStore_corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStore(path); err == nil {
+		t.Fatal("expected error for corrupt snapshot")
+	}
+}
+
+func TestStore_Flush_inMemoryNoop(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "x", "h")
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush in-memory: %v", err)
+	}
+}
+
+func TestOpenStore_revisionAndLegacyTombstones(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	legacy := `{"files":[{"path":"a.md","content":"a","hash":"h","updatedAt":1}],"deleted":{"old.md":5}}`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore legacy: %v", err)
+	}
+	if st := s.State(); len(st.Tombstones) != 1 || st.Tombstones[0].DeletedAt != 5 {
+		t.Fatalf("legacy tombstones: %+v", st.Tombstones)
+	}
+	s.UpsertFile("b.md", "b", "hb")
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Revision() != 1 || len(reloaded.State().Tombstones) != 1 {
+		t.Fatalf("revision should persist: %d %+v", reloaded.Revision(), reloaded.State())
+	}
+	reloaded.DeleteFile("b.md")
+	reloaded.PruneTombstones(time.Now().UnixMilli() + 1)
+	if err := reloaded.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := OpenStore(path); err != nil || again.Horizon() != 2 {
+		t.Fatalf("horizon should persist: %v", err)
+	}
+}
+
+func TestOpenStore_legacyHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	// The plugin's old hash over UTF-16 units, the server's over bytes, and a client's own.
+	legacy := `{"rev":3,"files":[{"path":"a.md","content":"Café","hash":"sha256:18uo14","rev":1},` +
+		`{"path":"b.md","content":"# Hi","hash":"sha256:n22m4","rev":2},{"path":"c.md","content":"c","hash":"custom","rev":3}]}`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a.md", "b.md"} {
+		if f, _ := s.Get(p); f.Hash != ContentHash(f.Content) || f.Rev != 4 {
+			t.Errorf("%s not rehashed: %+v", p, f)
+		}
+	}
+	if f, _ := s.Get("c.md"); f.Hash != "custom" || f.Rev != 3 || s.Revision() != 4 {
+		t.Errorf("c.md: %+v, revision %d", f, s.Revision())
+	}
+}
@@ -1,44 +1,218 @@
 package sync
 
 import (
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
 type File struct {
-	Path      string `json:"path"`
-	Content   string `json:"content"`
-	Hash      string `json:"hash"`
-	UpdatedAt int64  `json:"updatedAt"`
+	Path      string        `json:"path"`
+	Content   string        `json:"content"`
+	Hash      string        `json:"hash"`
+	UpdatedAt int64         `json:"updatedAt"`
+	Rev       int64         `json:"rev"`              // store revision of the last change
+	Device    string        `json:"device,omitempty"` // device that made it, if it identified itself
+	Vector    VersionVector `json:"vector,omitempty"`
 }
 
+// Tombstone records that a path was deleted, so devices that still have it can drop it.
+type Tombstone struct {
+	Path      string        `json:"path"`
+	DeletedAt int64         `json:"deletedAt"`
+	Rev       int64         `json:"rev"`
+	Device    string        `json:"device,omitempty"`
+	Vector    VersionVector `json:"vector,omitempty"` // the deleted file's, ticked by the delete
+}
+
+// Version is an earlier content of a file, kept so it can be recovered. Large content is
+// kept as the hashes of its chunks in the store's Blobs instead; see VersionContent.
+type Version struct {
+	Hash      string   `json:"hash"`
+	Content   string   `json:"content"`
+	Chunks    []string `json:"chunks,omitempty"`
+	UpdatedAt int64    `json:"updatedAt"`
+}
+
+// historyLimit caps the versions kept per path; the oldest are dropped first.
+const historyLimit = 10
+
 type Store struct {
-	mu      sync.RWMutex
-	files   map[string]*File
-	deleted map[string]int64
+	mu        sync.RWMutex
+	files     map[string]*File
+	deleted   map[string]Tombstone
+	moved     map[string]Move // keyed by source path
+	history   map[string][]Version
+	rev       int64  // bumped by every change; stamped on the files, tombstones and moves it touches
+	horizon   int64  // see Horizon
+	path      string // snapshot file for OpenStore; empty for in-memory stores
+	dirty     bool
+	edits     uint64       // counts markDirty calls, so Flush can tell whether the store changed meanwhile
+	undo      *[]pathState // set while a Batch runs
+	device    string       // stamped on changes while a Batch runs; see Tx.As
+	devices   map[string]*Device
+	conflicts map[string]Conflict // keyed by copy path
+	locks     map[string]Lock
+	blobs     *Blobs
+	swept     time.Time // last Blobs.Sweep by Flush
+	flushing  sync.Mutex
 }
 
 func NewStore() *Store {
 	return &Store{
-		files:   make(map[string]*File),
-		deleted: make(map[string]int64),
+		files:     make(map[string]*File),
+		deleted:   make(map[string]Tombstone),
+		moved:     make(map[string]Move),
+		history:   make(map[string][]Version),
+		devices:   make(map[string]*Device),
+		conflicts: make(map[string]Conflict),
+		locks:     make(map[string]Lock),
+		blobs:     NewBlobs(""),
 	}
 }
 
+// Blobs is the chunk store behind the store's large content, shared with clients that
+// upload and download chunks.
+func (s *Store) Blobs() *Blobs {
+	return s.blobs
+}
+
 func (s *Store) UpsertFile(path, content, hash string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.upsert(path, content, hash, nil)
+}
+
+// upsert writes a file. With vv nil the writing device's entry of the path's vector is
+// ticked; otherwise vv, which the client already ticked, is merged into it.
+func (s *Store) upsert(path, content, hash string, vv VersionVector) {
+	s.save(path)
 	now := time.Now().UnixMilli()
-	s.files[path] = &File{Path: path, Content: content, Hash: hash, UpdatedAt: now}
+	if vv == nil {
+		vv = s.vector(path).Tick(s.device)
+	} else {
+		vv = vv.Merge(s.vector(path))
+	}
+	if old, ok := s.files[path]; ok && old.Hash != hash {
+		s.pushHistory(path, old)
+	}
+	s.files[path] = &File{Path: path, Content: content, Hash: hash, UpdatedAt: now, Rev: s.bump(), Device: s.device, Vector: vv}
 	delete(s.deleted, path)
 }
 
+// bump starts a new revision and marks the store dirty. Callers hold s.mu.
+func (s *Store) bump() int64 {
+	s.rev++
+	s.markDirty()
+	return s.rev
+}
+
+// markDirty records a change for the next Flush to save. Callers hold s.mu.
+func (s *Store) markDirty() {
+	s.dirty = true
+	s.edits++
+}
+
+// Revision is the number of changes made to the store; it only grows.
+func (s *Store) Revision() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rev
+}
+
+// pushHistory records f as an earlier version of path, as chunks if its content was already
+// chunked by Flush. Callers hold s.mu.
+func (s *Store) pushHistory(path string, f *File) {
+	v := Version{Hash: f.Hash, Content: f.Content, UpdatedAt: f.UpdatedAt}
+	if hashes, ok := s.blobs.Stored(f.Content); ok {
+		v.Content, v.Chunks = "", hashes
+	}
+	h := append(s.history[path], v)
+	if len(h) > historyLimit {
+		h = h[len(h)-historyLimit:]
+	}
+	s.history[path] = h
+}
+
+// History returns earlier versions of path, oldest first. Read their content with
+// VersionContent.
+func (s *Store) History(path string) []Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.history[path])
+}
+
+// VersionContent returns v's content, reassembling it from its chunks if need be.
+func (s *Store) VersionContent(v Version) (string, error) {
+	if v.Chunks == nil {
+		return v.Content, nil
+	}
+	return s.blobs.Join(v.Chunks)
+}
+
 func (s *Store) DeleteFile(path string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	now := time.Now().UnixMilli()
+	s.save(path)
+	vv := s.vector(path).Tick(s.device)
 	delete(s.files, path)
-	s.deleted[path] = now
+	s.deleted[path] = Tombstone{Path: path, DeletedAt: time.Now().UnixMilli(), Rev: s.bump(), Device: s.device, Vector: vv}
+}
+
+// vector is the version vector of the live file at path, or of its tombstone.
+func (s *Store) vector(path string) VersionVector {
+	if f, ok := s.files[path]; ok {
+		return f.Vector
+	}
+	return s.deleted[path].Vector
+}
+
+// Get returns the live file at path, if any.
+func (s *Store) Get(path string) (*File, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.files[path]
+	return f, ok
+}
+
+// DeleteTree deletes path as a file, or as a folder when files live under it: every file
+// under the prefix is tombstoned in one step. The folder itself gets no tombstone. Returns the
+// tombstoned paths, sorted; a path matching nothing is tombstoned as-is, like DeleteFile.
+func (s *Store) DeleteTree(path string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteTree(path)
+}
+
+// tree lists the live files at path or under it as a folder, unsorted.
+func (s *Store) tree(path string) []string {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	var out []string
+	for p := range s.files {
+		if p == path || strings.HasPrefix(p, prefix) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (s *Store) deleteTree(path string) []string {
+	now := time.Now().UnixMilli()
+	out := s.tree(path)
+	if len(out) == 0 {
+		out = append(out, path)
+	}
+	rev := s.bump()
+	for _, p := range out {
+		s.save(p)
+		vv := s.vector(p).Tick(s.device)
+		delete(s.files, p)
+		delete(s.conflicts, p)
+		s.deleted[p] = Tombstone{Path: p, DeletedAt: now, Rev: rev, Device: s.device, Vector: vv}
+	}
+	slices.Sort(out)
+	return out
 }
 
 func (s *Store) GetFiles() ([]*File, []string) {
@@ -54,3 +228,108 @@ func (s *Store) GetFiles() ([]*File, []string) {
 	}
 	return files, deleted
 }
+
+// Len reports the number of live files and tombstones.
+func (s *Store) Len() (files, deleted int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.files), len(s.deleted)
+}
+
+// PruneTombstones forgets deletes and moves recorded before cutoff (Unix ms) and reports how
+// many were dropped. Dropping any starts a new revision and raises the horizon to the newest
+// of them, since clients last synced before it can no longer learn about every delete.
+func (s *Store) PruneTombstones(cutoff int64) int {
+	return s.prune(func(at, _ int64) bool { return at < cutoff })
+}
+
+// PruneAcked forgets deletes and moves at or before revision rev, e.g. once every device has
+// acknowledged it, like PruneTombstones.
+func (s *Store) PruneAcked(rev int64) int {
+	return s.prune(func(_, r int64) bool { return r <= rev })
+}
+
+func (s *Store) prune(drop func(at, rev int64) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for p, t := range s.deleted {
+		if drop(t.DeletedAt, t.Rev) {
+			delete(s.deleted, p)
+			s.horizon = max(s.horizon, t.Rev)
+			n++
+		}
+	}
+	for p, m := range s.moved {
+		if drop(m.At, m.Rev) {
+			delete(s.moved, p)
+			s.horizon = max(s.horizon, m.Rev)
+			n++
+		}
+	}
+	if n > 0 {
+		s.bump()
+	}
+	return n
+}
+
+// Horizon is the newest revision of any pruned tombstone or move. Changes since an older
+// revision can't be replayed in full; such clients must resync from the complete state.
+func (s *Store) Horizon() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.horizon
+}
+
+// PruneHistory drops earlier versions saved before cutoff (Unix ms) and reports how many were dropped.
+func (s *Store) PruneHistory(cutoff int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for p, h := range s.history {
+		keep := slices.DeleteFunc(slices.Clone(h), func(v Version) bool { return v.UpdatedAt < cutoff })
+		if d := len(h) - len(keep); d > 0 {
+			n += d
+			if len(keep) == 0 {
+				delete(s.history, p)
+			} else {
+				s.history[p] = keep
+			}
+		}
+	}
+	if n > 0 {
+		s.markDirty()
+	}
+	return n
+}
+
+// State is a consistent copy of the store at one revision.
+type State struct {
+	Rev        int64
+	Horizon    int64       // see Store.Horizon
+	Files      []*File     // sorted by path
+	Tombstones []Tombstone // sorted by path
+	Moves      []Move      // in the order they happened
+}
+
+// State returns every file, tombstone and move as of the current revision.
+func (s *Store) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st := State{
+		Rev:        s.rev,
+		Horizon:    s.horizon,
+		Files:      make([]*File, 0, len(s.files)),
+		Tombstones: make([]Tombstone, 0, len(s.deleted)),
+		Moves:      s.moves(),
+	}
+	for _, f := range s.files {
+		st.Files = append(st.Files, f)
+	}
+	for _, t := range s.deleted {
+		st.Tombstones = append(st.Tombstones, t)
+	}
+	slices.SortFunc(st.Files, func(a, b *File) int { return strings.Compare(a.Path, b.Path) })
+	slices.SortFunc(st.Tombstones, func(a, b Tombstone) int { return strings.Compare(a.Path, b.Path) })
+	return st
+}
@@ -1,7 +1,9 @@
 package sync
 
 import (
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestStore_UpsertGetDelete(t *testing.T) {
@@ -22,3 +24,129 @@ func TestStore_UpsertGetDelete(t *testing.T) {
 	}
 }
 
+
+func TestStore_PruneTombstones(t *testing.T) {
+	s := NewStore()
+	s.DeleteFile("old.md")
+	cutoff := time.Now().UnixMilli() + 1
+	time.Sleep(2 * time.Millisecond)
+	s.DeleteFile("new.md")
+	if n := s.PruneTombstones(cutoff); n != 1 {
+		t.Fatalf("pruned %d, want 1", n)
+	}
+	_, deleted := s.GetFiles()
+	if len(deleted) != 1 || deleted[0] != "new.md" {
+		t.Fatalf("remaining tombstones: %v", deleted)
+	}
+}
+
+func TestStore_DeleteTree(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("Notes/a.md", "a", "h")
+	s.UpsertFile("Notes/sub/b.md", "b", "h")
+	s.UpsertFile("Notes.md", "sibling", "h")
+	s.UpsertFile("NotesArchive/c.md", "c", "h")
+
+	got := s.DeleteTree("Notes")
+	if len(got) != 2 || got[0] != "Notes/a.md" || got[1] != "Notes/sub/b.md" {
+		t.Fatalf("DeleteTree folder: %v", got)
+	}
+	files, deleted := s.GetFiles()
+	if len(files) != 2 || len(deleted) != 2 {
+		t.Fatalf("after folder delete: files=%d deleted=%v", len(files), deleted)
+	}
+	if _, ok := s.Get("Notes"); ok {
+		t.Fatal("folder path should not exist")
+	}
+
+	if got := s.DeleteTree("Notes.md"); len(got) != 1 || got[0] != "Notes.md" {
+		t.Fatalf("DeleteTree file: %v", got)
+	}
+	if got := s.DeleteTree("unknown.md"); len(got) != 1 || got[0] != "unknown.md" {
+		t.Fatalf("DeleteTree unknown: %v", got)
+	}
+	if _, ok := s.Get("NotesArchive/c.md"); !ok {
+		t.Fatal("prefix match must stop at folder boundary")
+	}
+}
+
+func TestStore_History(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "v0", "h0")
+	s.UpsertFile("a.md", "v0", "h0") // unchanged: no new version
+	for i := 1; i <= historyLimit+2; i++ {
+		s.UpsertFile("a.md", "v", string(rune('a'+i)))
+	}
+	h := s.History("a.md")
+	if len(h) != historyLimit {
+		t.Fatalf("history length %d, want %d", len(h), historyLimit)
+	}
+	if h[0].Hash != string(rune('a'+2)) {
+		t.Fatalf("oldest versions should be dropped first: %+v", h[0])
+	}
+}
+
+func TestStore_Revision(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("b.md", "b", "hb")
+	s.UpsertFile("a.md", "a", "ha")
+	s.DeleteTree("b.md")
+	if _, err := s.Move("a.md", "c.md"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Revision() != 4 {
+		t.Fatalf("every change should bump the revision: %d", s.Revision())
+	}
+	st := s.State()
+	if st.Rev != 4 || len(st.Files) != 1 || st.Files[0].Path != "c.md" || st.Files[0].Rev != 4 {
+		t.Fatalf("state files: %+v", st)
+	}
+	if len(st.Tombstones) != 2 || st.Tombstones[0].Path != "a.md" || st.Tombstones[0].Rev != 4 || st.Tombstones[1].Rev != 3 {
+		t.Fatalf("state tombstones: %+v", st.Tombstones)
+	}
+	if len(st.Moves) != 1 || st.Moves[0].Rev != 4 {
+		t.Fatalf("state moves: %+v", st.Moves)
+	}
+	s.Batch(func(tx *Tx) error {
+		tx.UpsertFile("d.md", "d", "hd")
+		return errors.New("abort")
+	})
+	if s.Revision() != 4 {
+		t.Fatalf("rolled back batch should not bump the revision: %d", s.Revision())
+	}
+}
+
+func TestStore_PruneTombstones_horizon(t *testing.T) {
+	s := NewStore()
+	s.DeleteFile("a.md")
+	s.UpsertFile("b.md", "b", "h")
+	s.Move("b.md", "c.md")
+	rev := s.Revision()
+	if s.Horizon() != 0 || s.PruneTombstones(0) != 0 || s.Revision() != rev {
+		t.Fatal("nothing pruned, nothing should change")
+	}
+	if n := s.PruneTombstones(time.Now().UnixMilli() + 1); n != 3 {
+		t.Fatalf("pruned %d, want 3", n)
+	}
+	if s.Horizon() != rev || s.Revision() != rev+1 || s.State().Horizon != rev {
+		t.Fatalf("horizon %d revision %d, want %d and %d", s.Horizon(), s.Revision(), rev, rev+1)
+	}
+}
+
+func TestStore_PruneHistory(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "v1", "h1")
+	s.UpsertFile("a.md", "v2", "h2")
+	s.UpsertFile("b.md", "v1", "h1")
+	s.UpsertFile("b.md", "v2", "h2")
+	s.history["b.md"][0].UpdatedAt = time.Now().Add(time.Hour).UnixMilli()
+	if n := s.PruneHistory(time.Now().UnixMilli() + 1); n != 1 {
+		t.Fatalf("pruned %d versions, want 1", n)
+	}
+	if len(s.History("a.md")) != 0 || len(s.History("b.md")) != 1 {
+		t.Fatalf("history left: a=%v b=%v", s.History("a.md"), s.History("b.md"))
+	}
+	if s.PruneHistory(0) != 0 {
+		t.Fatal("nothing is older than the epoch")
+	}
+}
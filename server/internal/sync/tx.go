@@ -0,0 +1,139 @@
+package sync
+
+import "slices"
+
+// Tx is a view of the store inside Batch. Its methods mirror the Store methods of the same
+// name but run under the batch's lock.
+type Tx struct {
+	s *Store
+}
+
+// pathState is everything the store knows about one path, saved so a failed Batch can put it back.
+type pathState struct {
+	path        string
+	file        *File
+	hasFile     bool
+	tombstone   Tombstone
+	hasDeleted  bool
+	move        Move
+	hasMove     bool
+	history     []Version
+	hasHistory  bool
+	conflict    Conflict
+	hasConflict bool
+}
+
+// Batch runs fn with the store locked, so other readers and writers see all of its changes
+// or none. If fn returns an error every change it made is rolled back and the error returned.
+func (s *Store) Batch(fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var undo []pathState
+	s.undo = &undo
+	dirty, rev := s.dirty, s.rev
+	err := fn(&Tx{s: s})
+	s.undo, s.device = nil, ""
+	if err != nil {
+		// Newest first, so a path changed twice ends up at its state before the batch.
+		for _, st := range slices.Backward(undo) {
+			s.restore(st)
+		}
+		s.dirty, s.rev = dirty, rev
+	}
+	return err
+}
+
+// save records the current state of path when a Batch is running. Callers hold s.mu.
+func (s *Store) save(path string) {
+	if s.undo == nil {
+		return
+	}
+	st := pathState{path: path}
+	st.file, st.hasFile = s.files[path]
+	st.tombstone, st.hasDeleted = s.deleted[path]
+	st.move, st.hasMove = s.moved[path]
+	st.history, st.hasHistory = s.history[path]
+	st.conflict, st.hasConflict = s.conflicts[path]
+	*s.undo = append(*s.undo, st)
+}
+
+func (s *Store) restore(st pathState) {
+	if st.hasFile {
+		s.files[st.path] = st.file
+	} else {
+		delete(s.files, st.path)
+	}
+	if st.hasDeleted {
+		s.deleted[st.path] = st.tombstone
+	} else {
+		delete(s.deleted, st.path)
+	}
+	if st.hasMove {
+		s.moved[st.path] = st.move
+	} else {
+		delete(s.moved, st.path)
+	}
+	if st.hasHistory {
+		s.history[st.path] = st.history
+	} else {
+		delete(s.history, st.path)
+	}
+	if st.hasConflict {
+		s.conflicts[st.path] = st.conflict
+	} else {
+		delete(s.conflicts, st.path)
+	}
+}
+
+// Get returns the live file at path, including changes made earlier in the batch.
+func (tx *Tx) Get(path string) (*File, bool) {
+	f, ok := tx.s.files[path]
+	return f, ok
+}
+
+// Vector returns the version vector of the live file at path, or of its tombstone.
+func (tx *Tx) Vector(path string) VersionVector {
+	return tx.s.vector(path)
+}
+
+// Deleted reports whether path has a tombstone.
+func (tx *Tx) Deleted(path string) bool {
+	_, ok := tx.s.deleted[path]
+	return ok
+}
+
+// UpsertFile is Store.UpsertFile within the batch.
+func (tx *Tx) UpsertFile(path, content, hash string) {
+	tx.s.upsert(path, content, hash, nil)
+}
+
+// UpsertFileVector writes a file at version vv, a vector the client has already ticked for
+// its edit. Callers check vv descends from the current one; see VersionVector.
+func (tx *Tx) UpsertFileVector(path, content, hash string, vv VersionVector) {
+	tx.s.upsert(path, content, hash, vv)
+}
+
+// HasTree reports whether a live file exists at path or under it as a folder.
+func (tx *Tx) HasTree(path string) bool {
+	return len(tx.s.tree(path)) > 0
+}
+
+// DeleteTree is Store.DeleteTree within the batch.
+func (tx *Tx) DeleteTree(path string) []string {
+	return tx.s.deleteTree(path)
+}
+
+// Move is Store.Move within the batch.
+func (tx *Tx) Move(from, to string) ([]Move, error) {
+	return tx.s.move(from, to)
+}
+
+// Blobs returns the store's chunk store.
+func (tx *Tx) Blobs() *Blobs {
+	return tx.s.blobs
+}
+
+// As attributes the batch's changes from here on to device id.
+func (tx *Tx) As(id string) {
+	tx.s.device = id
+}
@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStore_Batch(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "a1", "h1")
+	s.UpsertFile("a.md", "a2", "h2")
+	s.UpsertFile("b.md", "b", "hb")
+	s.DeleteFile("gone.md")
+
+	if err := s.Batch(func(tx *Tx) error {
+		tx.UpsertFile("c.md", "c", "hc")
+		if _, err := tx.Move("a.md", "d.md"); err != nil {
+			return err
+		}
+		if f, ok := tx.Get("d.md"); !ok || f.Content != "a2" {
+			t.Errorf("batch should see its own move: %+v", f)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if _, ok := s.Get("c.md"); !ok {
+		t.Fatal("committed batch should keep its changes")
+	}
+
+	boom := errors.New("boom")
+	err := s.Batch(func(tx *Tx) error {
+		tx.UpsertFile("b.md", "b2", "hb2")
+		tx.UpsertFile("b.md", "b3", "hb3")
+		if _, err := tx.Move("d.md", "e.md"); err != nil {
+			return err
+		}
+		tx.DeleteTree("c.md")
+		tx.UpsertFile("gone.md", "back", "hg")
+		if !tx.Deleted("c.md") || tx.Deleted("gone.md") {
+			t.Error("batch should see its own deletes")
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Batch error: %v", err)
+	}
+	if f, _ := s.Get("b.md"); f.Content != "b" || len(s.History("b.md")) != 0 {
+		t.Errorf("b.md not rolled back: %+v %v", f, s.History("b.md"))
+	}
+	if f, ok := s.Get("d.md"); !ok || f.Content != "a2" || len(s.History("d.md")) != 1 {
+		t.Errorf("d.md not rolled back: %+v %v", f, s.History("d.md"))
+	}
+	if _, ok := s.Get("e.md"); ok {
+		t.Error("e.md should not exist after rollback")
+	}
+	if _, ok := s.Get("c.md"); !ok {
+		t.Error("c.md delete not rolled back")
+	}
+	files, deleted := s.Len()
+	if files != 3 || deleted != 2 {
+		t.Errorf("after rollback: files=%d deleted=%d", files, deleted)
+	}
+	if len(s.Moves()) != 1 {
+		t.Errorf("moves after rollback: %+v", s.Moves())
+	}
+}
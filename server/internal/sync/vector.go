@@ -0,0 +1,51 @@
+package sync
+
+// VersionVector counts the edits each device has made to a file, keyed by device ID. A
+// version whose vector descends from another's was made with that one in view, so replacing
+// it is a fast-forward; two vectors where neither descends from the other are concurrent edits.
+//
+// Clients that track vectors start from the vector they last pulled and tick their own
+// entry when they edit. The store ticks the writing device's entry for clients that don't,
+// and serverNode's for anonymous writes.
+type VersionVector map[string]int64
+
+// serverNode is the entry ticked for changes made without a device ID.
+const serverNode = "server"
+
+// Descends reports whether v has seen every edit w has: v[d] >= w[d] for every device d.
+// Every vector descends from an empty one.
+func (v VersionVector) Descends(w VersionVector) bool {
+	for d, n := range w {
+		if v[d] < n {
+			return false
+		}
+	}
+	return true
+}
+
+// Concurrent reports whether neither of v and w descends from the other.
+func (v VersionVector) Concurrent(w VersionVector) bool {
+	return !v.Descends(w) && !w.Descends(v)
+}
+
+// Merge returns a new vector holding the larger count of each device.
+func (v VersionVector) Merge(w VersionVector) VersionVector {
+	out := make(VersionVector, max(len(v), len(w)))
+	for d, n := range v {
+		out[d] = n
+	}
+	for d, n := range w {
+		out[d] = max(out[d], n)
+	}
+	return out
+}
+
+// Tick returns a copy of v with device's count raised by one.
+func (v VersionVector) Tick(device string) VersionVector {
+	if device == "" {
+		device = serverNode
+	}
+	out := v.Merge(nil)
+	out[device]++
+	return out
+}
@@ -0,0 +1,69 @@
+package sync
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestVersionVector(t *testing.T) {
+	a := VersionVector{"laptop": 2, "phone": 1}
+	b := VersionVector{"laptop": 1, "phone": 1}
+	c := VersionVector{"laptop": 1, "phone": 2}
+	if !a.Descends(b) || b.Descends(a) || !a.Descends(nil) || !a.Descends(a) {
+		t.Fatal("descends")
+	}
+	if !a.Concurrent(c) || a.Concurrent(b) {
+		t.Fatal("concurrent")
+	}
+	if m := a.Merge(c); !maps.Equal(m, VersionVector{"laptop": 2, "phone": 2}) || len(a) != 2 || a["phone"] != 1 {
+		t.Fatalf("merge: %v, left %v", m, a)
+	}
+	if n := b.Tick("phone"); n["phone"] != 2 || b["phone"] != 1 {
+		t.Fatalf("tick copies: %v %v", n, b)
+	}
+	if n := VersionVector(nil).Tick(""); !maps.Equal(n, VersionVector{serverNode: 1}) {
+		t.Fatalf("anonymous tick: %v", n)
+	}
+}
+
+func TestStore_vectors(t *testing.T) {
+	s := NewStore()
+	s.UpsertFile("a.md", "1", "h1")
+	s.Batch(func(tx *Tx) error {
+		tx.As("laptop")
+		tx.UpsertFile("a.md", "2", "h2")
+		return nil
+	})
+	f, _ := s.Get("a.md")
+	if !maps.Equal(f.Vector, VersionVector{serverNode: 1, "laptop": 1}) {
+		t.Fatalf("each write ticks its device: %v", f.Vector)
+	}
+
+	// A client-ticked vector is merged, not ticked again.
+	s.Batch(func(tx *Tx) error {
+		tx.As("phone")
+		tx.UpsertFileVector("a.md", "3", "h3", VersionVector{"laptop": 1, "phone": 1})
+		return nil
+	})
+	f, _ = s.Get("a.md")
+	want := VersionVector{serverNode: 1, "laptop": 1, "phone": 1}
+	if !maps.Equal(f.Vector, want) {
+		t.Fatalf("client vector: %v", f.Vector)
+	}
+
+	s.Move("a.md", "b.md")
+	if f, _ := s.Get("b.md"); !maps.Equal(f.Vector, want) {
+		t.Fatalf("a move keeps the vector: %v", f.Vector)
+	}
+	s.DeleteTree("b.md")
+	st := s.State()
+	for _, ts := range st.Tombstones {
+		if !ts.Vector.Descends(want) || maps.Equal(ts.Vector, want) {
+			t.Fatalf("tombstone %s should tick the deleted file's vector: %v", ts.Path, ts.Vector)
+		}
+	}
+	s.UpsertFile("b.md", "4", "h4")
+	if f, _ := s.Get("b.md"); f.Vector[serverNode] != 3 {
+		t.Fatalf("a recreated file continues from its tombstone: %v", f.Vector)
+	}
+}